@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+// adminHandler serves the endpoints used to operate the running proxies.
+func (app *application) adminHandler() http.Handler {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, app.currentConfig().redacted())
+	})
+	serveMux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := app.reload(); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+	})
+	serveMux.HandleFunc("DELETE /namespaces/{namespace}", func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		if !cacheproxy.ValidNamespace(namespace) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid namespace"})
+			return
+		}
+		removed, err := app.repo.DeletePrefix(cacheproxy.NamespaceKeyPrefix(namespace))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+	})
+	for index, target := range app.currentConfig().Targets {
+		proxy := app.proxies[index]
+		// Served here only, so the target listeners proxy every upstream path
+		serveMux.Handle("GET /metrics/"+hostOf(target.URL), proxy.MetricsHandler())
+		serveMux.Handle("GET /healthz/"+hostOf(target.URL), proxy.HealthHandler())
+		serveMux.Handle("GET /readyz/"+hostOf(target.URL), proxy.ReadyHandler())
+		serveMux.HandleFunc("GET /egress/"+hostOf(target.URL), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, proxy.EgressStatus())
+		})
+		serveMux.HandleFunc("GET /bandwidth/"+hostOf(target.URL), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, proxy.Bandwidth())
+		})
+	}
+	return requireToken(app.currentConfig().Admin.Token, serveMux)
+}
+
+// requireToken rejects the requests without the Bearer token, when one is configured.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cacheproxy-admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) serveAdmin(ctx context.Context, address string) error {
+	server := &http.Server{Addr: address, Handler: app.adminHandler()}
+	errChan := make(chan error, 1)
+	go func() {
+		slog.Info("Admin listening", slog.String("address", address))
+		if app.currentConfig().Admin.Token == "" {
+			slog.Warn("Admin endpoints are only protected by the loopback bind, set admin.token to require a credential")
+		}
+		errChan <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-errChan:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("failed to write admin response", slog.String("error", err.Error()))
+	}
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return parsed.Host
+}
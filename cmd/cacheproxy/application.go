@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jictyvoo/radadar_crawlsdk/internal/repositories/badgerepo"
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
+)
+
+// canonicalizers maps the names accepted on rule configs to their implementation
+var canonicalizers = map[string]cacheproxy.BodyCanonicalizer{
+	"":        nil,
+	"json":    cacheproxy.CanonicalJSON,
+	"graphql": cacheproxy.CanonicalGraphQL,
+}
+
+// overflowBehaviors maps the names accepted on limits configs to their overflow behavior
+var overflowBehaviors = map[string]cacheproxy.OverflowBehavior{
+	"":                  cacheproxy.OverflowWait,
+	"wait":              cacheproxy.OverflowWait,
+	"too_many_requests": cacheproxy.OverflowTooManyRequests,
+	"unavailable":       cacheproxy.OverflowUnavailable,
+}
+
+// egressSelections maps the names accepted on egress configs to their selection
+var egressSelections = map[string]httptransport.EgressSelection{
+	"":            httptransport.EgressRoundRobin,
+	"round_robin": httptransport.EgressRoundRobin,
+	"sticky":      httptransport.EgressStickyByHost,
+}
+
+// application holds the storage and proxies described by a config.
+type application struct {
+	configPath string
+	mutex      sync.RWMutex
+	config     config
+	repo       *badgerepo.RemoteFileCache
+	proxies    []*cacheproxy.CacheableProxy
+	closers    []io.Closer
+}
+
+func newApplication(cfg config, configPath string) (*application, error) {
+	applyLogLevel(cfg.Logging.Level)
+	repo, err := badgerepo.NewRemoteFileCache(cfg.Storage.Path, cfg.Storage.repoOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init badger repo: %w", err)
+	}
+	app := &application{configPath: configPath, config: cfg, repo: repo}
+
+	var accessLog io.Writer
+	switch cfg.Logging.AccessLog {
+	case "":
+	case "-":
+		accessLog = os.Stdout
+	default:
+		rotating, logErr := cacheproxy.NewRotatingFile(
+			cfg.Logging.AccessLog, cfg.Logging.AccessLogMaxMB<<20, cfg.Logging.AccessLogBackups,
+		)
+		if logErr != nil {
+			return nil, errors.Join(fmt.Errorf("failed to open access log: %w", logErr), app.Close())
+		}
+		accessLog = rotating
+		app.closers = append(app.closers, rotating)
+	}
+
+	for _, target := range cfg.Targets {
+		options := target.proxyOptions(app.targetProxy)
+		if accessLog != nil {
+			options = append(options, cacheproxy.WithAccessLog(
+				accessLog, cacheproxy.AccessLogFormat(cfg.Logging.AccessLogFormat),
+			))
+		}
+		proxy, proxyErr := cacheproxy.New(repo, target.URL, target.Port, options...)
+		if proxyErr != nil {
+			return nil, errors.Join(proxyErr, app.Close())
+		}
+		app.proxies = append(app.proxies, proxy)
+	}
+	return app, nil
+}
+
+func (storage storageConfig) repoOptions() []badgerepo.CacheOption {
+	var repoOptions []badgerepo.CacheOption
+	if storage.HistoryVersions > 0 || storage.HistoryDays > 0 {
+		repoOptions = append(repoOptions, badgerepo.WithHistory(cacheproxy.HistoryPolicy{
+			MaxVersions: storage.HistoryVersions,
+			MaxAge:      time.Duration(storage.HistoryDays) * 24 * time.Hour,
+		}))
+	}
+	if verify := storage.Verify; verify.SampleRate > 0 || verify.ScrubInterval > 0 {
+		repoOptions = append(repoOptions, badgerepo.WithVerification(badgerepo.VerifyPolicy{
+			SampleRate:    verify.SampleRate,
+			ScrubInterval: time.Duration(verify.ScrubInterval),
+			ScrubRate:     verify.ScrubRate,
+		}))
+	}
+	return repoOptions
+}
+
+// proxyOptions translates the target config, prefetching the assets of other targets through proxyFor.
+func (target targetConfig) proxyOptions(proxyFor func(host string) *cacheproxy.CacheableProxy) []cacheproxy.ProxyOption {
+	proxyOptions := []cacheproxy.ProxyOption{
+		cacheproxy.WithCacheTTL(time.Duration(target.TTL)),
+		cacheproxy.WithNegativeCache(time.Duration(target.NegativeTTL)),
+		cacheproxy.WithTrackedTypes(target.TrackedTypes...),
+		cacheproxy.WithCacheRules(target.cacheRules()...),
+	}
+
+	if target.TLS.Enabled || target.TLS.CertFile != "" {
+		proxyOptions = append(proxyOptions, cacheproxy.WithTLS(cacheproxy.TLSPolicy{
+			CertFile: target.TLS.CertFile,
+			KeyFile:  target.TLS.KeyFile,
+			Hosts:    target.TLS.Hosts,
+		}))
+	}
+
+	if target.Bind != "" {
+		proxyOptions = append(proxyOptions, cacheproxy.WithBindAddress(target.Bind))
+	}
+	if access := target.Access; len(access.AllowedNetworks) > 0 || len(access.Users) > 0 || len(access.Tokens) > 0 {
+		users := make(map[string]string, len(access.Users))
+		for _, user := range access.Users {
+			users[user.Username] = user.Password
+		}
+		proxyOptions = append(proxyOptions, cacheproxy.WithAccessPolicy(cacheproxy.AccessPolicy{
+			AllowedNetworks: access.AllowedNetworks,
+			BasicUsers:      users,
+			BearerTokens:    access.Tokens,
+		}))
+	}
+	if headers := target.Headers; len(headers.Inject) > 0 || len(headers.StripRequest) > 0 ||
+		len(headers.DropStored) > 0 || len(headers.RedactStored) > 0 {
+		inject := make(map[string]string, len(headers.Inject))
+		for _, header := range headers.Inject {
+			inject[header.Name] = header.Value
+		}
+		proxyOptions = append(proxyOptions, cacheproxy.WithHeaderPolicy(cacheproxy.HeaderPolicy{
+			Inject:       inject,
+			StripRequest: headers.StripRequest,
+			DropStored:   headers.DropStored,
+			RedactStored: headers.RedactStored,
+		}))
+	}
+	if namespaces := target.Namespaces; namespaces.Enabled || namespaces.Default != "" ||
+		namespaces.PathPrefix != "" || len(namespaces.Declared) > 0 {
+		declared := make(map[string]cacheproxy.Namespace, len(namespaces.Declared))
+		for _, namespace := range namespaces.Declared {
+			declared[namespace.Name] = cacheproxy.Namespace{
+				TTL:         time.Duration(namespace.TTL),
+				Parent:      namespace.Parent,
+				Fallthrough: namespace.Fallthrough,
+			}
+		}
+		proxyOptions = append(proxyOptions, cacheproxy.WithNamespaces(cacheproxy.NamespacePolicy{
+			Default:    namespaces.Default,
+			PathPrefix: namespaces.PathPrefix,
+			Namespaces: declared,
+		}))
+	}
+	if egress := target.Egress; len(egress.Proxies) > 0 {
+		proxyOptions = append(proxyOptions, cacheproxy.WithEgress(httptransport.EgressPolicy{
+			Proxies:          egress.Proxies,
+			Selection:        egressSelections[egress.Selection],
+			FailureThreshold: egress.FailureThreshold,
+			EjectDuration:    time.Duration(egress.EjectFor),
+		}))
+	}
+	if target.Rewrite.Enabled {
+		proxyOptions = append(proxyOptions, cacheproxy.WithURLRewrite(cacheproxy.URLRewritePolicy{
+			PublicURL:  target.Rewrite.PublicURL,
+			AssetHosts: target.Rewrite.AssetHosts,
+		}))
+	}
+	if bandwidth := target.Bandwidth; bandwidth.DailyBudgetMB > 0 || bandwidth.Window > 0 {
+		proxyOptions = append(proxyOptions, cacheproxy.WithBandwidthBudget(cacheproxy.BandwidthPolicy{
+			DailyBudget: bandwidth.DailyBudgetMB << 20,
+			Window:      time.Duration(bandwidth.Window),
+		}))
+	}
+	if prefetch := target.Prefetch; prefetch.Enabled {
+		proxyOptions = append(proxyOptions, cacheproxy.WithPrefetch(cacheproxy.PrefetchPolicy{
+			AllowedHosts:     prefetch.AllowedHosts,
+			ProxyFor:         proxyFor,
+			Concurrency:      prefetch.Concurrency,
+			MaxAssetsPerPage: prefetch.MaxPerPage,
+		}))
+	}
+	if target.Offline {
+		proxyOptions = append(proxyOptions, cacheproxy.WithOfflineMode())
+	}
+	if target.RecordStreams {
+		proxyOptions = append(proxyOptions, cacheproxy.WithStreamRecording(cacheproxy.StreamRecordPolicy{}))
+	}
+
+	limits := target.Limits
+	if limits.RequestsPerSecond > 0 || limits.MaxInFlight > 0 || limits.MaxPause > 0 {
+		proxyOptions = append(proxyOptions, cacheproxy.WithPoliteness(cacheproxy.PolitenessPolicy{
+			RequestsPerSecond: limits.RequestsPerSecond,
+			Burst:             limits.Burst,
+			MaxInFlight:       limits.MaxInFlight,
+			MaxQueue:          limits.MaxQueue,
+			Overflow:          overflowBehaviors[limits.Overflow],
+			MaxPause:          time.Duration(limits.MaxPause),
+		}))
+	}
+	if limits.RobotsAgent != "" {
+		proxyOptions = append(proxyOptions, cacheproxy.WithRobotsPolicy(cacheproxy.RobotsPolicy{
+			UserAgent: limits.RobotsAgent,
+		}))
+	}
+	if limits.RetryAttempts > 1 {
+		proxyOptions = append(proxyOptions, cacheproxy.WithRetryPolicy(cacheproxy.RetryPolicy{
+			MaxAttempts: limits.RetryAttempts,
+		}))
+	}
+	if limits.BreakerFailures > 0 {
+		proxyOptions = append(proxyOptions, cacheproxy.WithCircuitBreaker(cacheproxy.BreakerPolicy{
+			FailureThreshold: limits.BreakerFailures,
+			OpenDuration:     30 * time.Second,
+		}))
+	}
+	return proxyOptions
+}
+
+func (target targetConfig) cacheRules() []cacheproxy.CacheRule {
+	rules := make([]cacheproxy.CacheRule, 0, len(target.Rules))
+	for _, rule := range target.Rules {
+		rules = append(rules, cacheproxy.CacheRule{
+			PathPrefix:   rule.PathPrefix,
+			Methods:      rule.Methods,
+			Canonicalize: canonicalizers[rule.Canonicalize],
+		})
+	}
+	return rules
+}
+
+func applyLogLevel(level string) {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err == nil {
+		slog.SetLogLoggerLevel(slogLevel)
+	}
+}
+
+// currentConfig returns the configuration in use, including the reloaded rules.
+func (app *application) currentConfig() config {
+	app.mutex.RLock()
+	defer app.mutex.RUnlock()
+	return app.config
+}
+
+// reload reads the config file again, replacing the rules of the running proxies
+// and the log level. The other settings need a restart, so their changes are only reported.
+func (app *application) reload() error {
+	if app.configPath == "" {
+		return errors.New("no config file to reload from")
+	}
+	cfg, err := readConfigFile(app.configPath)
+	if err == nil {
+		cfg, err = finishConfig(cfg, os.Environ())
+	}
+	if err != nil {
+		return err
+	}
+
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	if len(cfg.Targets) != len(app.proxies) {
+		return fmt.Errorf("targets: reloading needs the same %d targets, got %d", len(app.proxies), len(cfg.Targets))
+	}
+	for index, target := range cfg.Targets {
+		running := app.config.Targets[index]
+		if target.URL != running.URL {
+			return fieldError{
+				Path:    fmt.Sprintf("targets[%d].url", index),
+				Message: "changing the target URL needs a restart",
+			}
+		}
+		target.Rules, running.Rules = nil, nil
+		if !reflect.DeepEqual(target, running) {
+			slog.Warn(
+				"Only rules are reloaded, restart to apply the other target settings",
+				slog.String("target", target.URL),
+			)
+		}
+	}
+
+	for index, target := range cfg.Targets {
+		app.proxies[index].SetCacheRules(target.cacheRules()...)
+		app.config.Targets[index].Rules = target.Rules
+	}
+	app.config.Logging.Level = cfg.Logging.Level
+	applyLogLevel(cfg.Logging.Level)
+	return nil
+}
+
+func (app *application) Close() error {
+	var errs []error
+	for _, closer := range app.closers {
+		errs = append(errs, closer.Close())
+	}
+	errs = append(errs, app.repo.Close())
+	return errors.Join(errs...)
+}
+
+// run serves every proxy, and the admin listener when configured, until ctx is done.
+// SIGHUP reloads the rules, without touching the open connections.
+func (app *application) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, len(app.proxies)+1)
+	)
+	for _, proxy := range app.proxies {
+		address, startErr := proxy.Start()
+		if startErr != nil {
+			errs <- startErr
+			cancel()
+			break
+		}
+		slog.Info(fmt.Sprintf("Listening on address %s", address))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if serveErr := proxy.Wait(); serveErr != nil {
+				errs <- serveErr
+				cancel()
+			}
+		}()
+	}
+
+	if listen := app.currentConfig().Admin.Listen; listen != "" && ctx.Err() == nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if adminErr := app.serveAdmin(ctx, listen); adminErr != nil {
+				errs <- adminErr
+				cancel()
+			}
+		}()
+	}
+
+	go app.reloadOnHangup(ctx)
+	<-ctx.Done()
+	runErrs := []error{app.shutdown()}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		runErrs = append(runErrs, err)
+	}
+	return errors.Join(runErrs...)
+}
+
+// shutdown stops every proxy, waiting for the requests in flight and their cache writes.
+func (app *application) shutdown() error {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(app.proxies))
+	)
+	for index, proxy := range app.proxies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[index] = proxy.Shutdown(shutdownCtx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jictyvoo/radadar_crawlsdk/internal/repositories/badgerepo"
+)
+
+// command is a cacheproxy subcommand, returning the process exit code.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, out io.Writer) int
+}
+
+func commandList() []command {
+	return []command{
+		{name: "serve", summary: "run the caching proxy (default)", run: runServe},
+		{name: "warm", summary: "prime the cache from sitemaps or URL lists", run: runWarm},
+		{name: "ls", summary: "list cached keys", run: runList},
+		{name: "cat", summary: "print a cached response", run: runCat},
+		{name: "versions", summary: "list the stored revisions of a key", run: runVersions},
+		{name: "diff", summary: "compare two stored revisions of a key", run: runDiff},
+		{name: "rm", summary: "remove cached keys", run: runRemove},
+		{name: "purge", summary: "remove every key with a prefix", run: runPurge},
+		{name: "stats", summary: "describe the cache contents and disk usage", run: runStats},
+		{name: "export", summary: "write a backup of the cache", run: runExport},
+		{name: "import", summary: "load a backup written by export", run: runImport},
+		{name: "gc", summary: "reclaim disk space from the value log", run: runGC},
+		{name: "verify", summary: "recompute checksums and report corrupted entries", run: runVerify},
+	}
+}
+
+// runCommand dispatches to the subcommand named by the first argument. Without one,
+// the arguments are flags for serve, as the command used to accept.
+func runCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args, out)
+	}
+
+	for _, cmd := range commandList() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], out)
+		}
+	}
+	if args[0] != "help" {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	}
+	printCommands(os.Stderr)
+	return 2
+}
+
+func printCommands(out io.Writer) {
+	_, _ = fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commandList() {
+		_, _ = fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// storageCommand parses the flags of a command that works directly on the cache storage.
+type storageCommand struct {
+	flags       *flag.FlagSet
+	storagePath string
+}
+
+func newStorageCommand(name, arguments string) *storageCommand {
+	cmd := &storageCommand{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	cmd.flags.StringVar(&cmd.storagePath, "storage", defaultStoragePath, "directory of the cache storage")
+	cmd.flags.Usage = func() {
+		_, _ = fmt.Fprintf(cmd.flags.Output(), "Usage: %s %s [flags] %s\n", os.Args[0], name, arguments)
+		cmd.flags.PrintDefaults()
+	}
+	return cmd
+}
+
+// open parses the arguments and opens the storage, failing with a message when it cannot.
+func (cmd *storageCommand) open(args []string) (*badgerepo.RemoteFileCache, bool) {
+	if err := cmd.flags.Parse(args); err != nil {
+		return nil, false
+	}
+	repo, err := badgerepo.NewRemoteFileCache(cmd.storagePath)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to open storage %s: %v\n", cmd.storagePath, err)
+		return nil, false
+	}
+	return repo, true
+}
+
+func closeStorage(repo *badgerepo.RemoteFileCache) {
+	if err := repo.Close(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to close storage: %v\n", err)
+	}
+}
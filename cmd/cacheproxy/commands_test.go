@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jictyvoo/radadar_crawlsdk/internal/repositories/badgerepo"
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+// seedStorage creates a storage with a healthy page, an image and a corrupted entry.
+func seedStorage(t *testing.T) string {
+	t.Helper()
+	storagePath := filepath.Join(t.TempDir(), "cache.badger")
+	repo, err := badgerepo.NewRemoteFileCache(storagePath)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer repo.Close()
+
+	entries := map[string]cacheproxy.FileInformation{
+		"file://GET@example.com#/page": {
+			FileMIME: cacheproxy.FileMIME{MimeType: "text/html"},
+			Envelope: cacheproxy.FileEnvelope{Status: 200, Headers: map[string][]string{"Etag": {`"v1"`}}},
+			Content:  []byte("<html>page</html>"),
+		},
+		"file://GET@example.com#/logo.jpg": {
+			FileMIME: cacheproxy.FileMIME{MimeType: "image/jpeg"},
+			Envelope: cacheproxy.FileEnvelope{Status: 200},
+			Content:  []byte("jpeg"),
+		},
+	}
+	for key, info := range entries {
+		info.Checksum = checksumOf(info.Content)
+		if err = repo.Set(key, info); err != nil {
+			t.Fatalf("Failed to seed %s: %v", key, err)
+		}
+	}
+	corrupted := cacheproxy.FileInformation{Content: []byte("changed"), Checksum: checksumOf([]byte("original"))}
+	if err = repo.Set("file://GET@example.com#/broken", corrupted); err != nil {
+		t.Fatalf("Failed to seed corrupted entry: %v", err)
+	}
+	return storagePath
+}
+
+func checksumOf(content []byte) []byte {
+	sum := sha256.Sum256(content)
+	return sum[:]
+}
+
+func runTestCommand(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	var out bytes.Buffer
+	exitCode := runCommand(args, &out)
+	return out.String(), exitCode
+}
+
+func TestCommands_ListAndCat(t *testing.T) {
+	storagePath := seedStorage(t)
+
+	output, exitCode := runTestCommand(t, "ls", "-storage", storagePath, "-mime", "text/")
+	if exitCode != 0 || strings.TrimSpace(output) != "file://GET@example.com#/page" {
+		t.Errorf("Expected only the HTML page to be listed, got %d `%s`", exitCode, output)
+	}
+
+	output, exitCode = runTestCommand(t, "cat", "-storage", storagePath, "-headers", "file://GET@example.com#/page")
+	expected := "Status: 200\nEtag: \"v1\"\n\n<html>page</html>"
+	if exitCode != 0 || output != expected {
+		t.Errorf("Expected `%s`, got %d `%s`", expected, exitCode, output)
+	}
+}
+
+func TestCommands_VerifyAndRemove(t *testing.T) {
+	storagePath := seedStorage(t)
+
+	output, exitCode := runTestCommand(t, "verify", "-storage", storagePath)
+	if exitCode != 1 || !strings.Contains(output, "corrupted file://GET@example.com#/broken") ||
+		!strings.Contains(output, "checked 3 entries, 1 corrupted") {
+		t.Errorf("Expected the broken entry to be reported, got %d `%s`", exitCode, output)
+	}
+
+	if _, exitCode = runTestCommand(t, "rm", "-storage", storagePath, "file://GET@example.com#/broken"); exitCode != 0 {
+		t.Errorf("Expected rm to succeed, got %d", exitCode)
+	}
+	if output, exitCode = runTestCommand(t, "verify", "-storage", storagePath); exitCode != 0 {
+		t.Errorf("Expected verify to pass after removal, got %d `%s`", exitCode, output)
+	}
+}
+
+func TestCommands_VerifyQuarantine(t *testing.T) {
+	storagePath := seedStorage(t)
+
+	output, exitCode := runTestCommand(t, "verify", "-storage", storagePath, "-quarantine")
+	if exitCode != 1 || !strings.Contains(output, "quarantined 1 entries") {
+		t.Errorf("Expected the broken entry to be quarantined, got %d `%s`", exitCode, output)
+	}
+	if output, exitCode = runTestCommand(t, "verify", "-storage", storagePath); exitCode != 0 {
+		t.Errorf("Expected verify to pass after the quarantine, got %d `%s`", exitCode, output)
+	}
+}
+
+func TestCommands_VersionsAndDiff(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "cache.badger")
+	repo, err := badgerepo.NewRemoteFileCache(
+		storagePath, badgerepo.WithHistory(cacheproxy.HistoryPolicy{MaxVersions: 5}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	const key = "file://GET@example.com#/page"
+	for _, content := range []string{"first\nshared\n", "second\nshared\n"} {
+		info := cacheproxy.FileInformation{Content: []byte(content), Checksum: checksumOf([]byte(content))}
+		if err = repo.Set(key, info); err != nil {
+			t.Fatalf("Failed to seed %s: %v", key, err)
+		}
+	}
+	_ = repo.Close()
+
+	output, exitCode := runTestCommand(t, "versions", "-storage", storagePath, key)
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); exitCode != 0 || len(lines) != 2 {
+		t.Errorf("Expected 2 versions, got %d `%s`", exitCode, output)
+	}
+
+	output, exitCode = runTestCommand(t, "diff", "-storage", storagePath, key)
+	if exitCode != 0 || !strings.Contains(output, "-first") || !strings.Contains(output, "+second") {
+		t.Errorf("Expected the diff of the two versions, got %d `%s`", exitCode, output)
+	}
+}
+
+func TestCommands_ExportImportAndPurge(t *testing.T) {
+	storagePath := seedStorage(t)
+	backupPath := filepath.Join(t.TempDir(), "cache.backup")
+	if _, exitCode := runTestCommand(t, "export", "-storage", storagePath, "-o", backupPath); exitCode != 0 {
+		t.Fatalf("Expected export to succeed, got %d", exitCode)
+	}
+
+	output, exitCode := runTestCommand(t, "purge", "-storage", storagePath, "-all")
+	if exitCode != 0 || output != "removed 3 keys\n" {
+		t.Errorf("Expected 3 keys to be purged, got %d `%s`", exitCode, output)
+	}
+
+	restoredPath := filepath.Join(t.TempDir(), "restored.badger")
+	if _, exitCode = runTestCommand(t, "import", "-storage", restoredPath, "-i", backupPath); exitCode != 0 {
+		t.Fatalf("Expected import to succeed, got %d", exitCode)
+	}
+	output, _ = runTestCommand(t, "ls", "-storage", restoredPath)
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); len(lines) != 3 {
+		t.Errorf("Expected 3 restored keys, got `%s`", output)
+	}
+}
+
+func TestApplication_WarmRoutesByHost(t *testing.T) {
+	var servers []*httptest.Server
+	for range 2 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	listPath := filepath.Join(t.TempDir(), "urls.txt")
+	list := servers[0].URL + "/a\n" + servers[1].URL + "/b\nhttp://elsewhere.example/c\n"
+	if err := os.WriteFile(listPath, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := finishConfig(config{
+		Storage: storageConfig{Path: filepath.Join(t.TempDir(), "cache.badger")},
+		Targets: []targetConfig{{URL: servers[0].URL}, {URL: servers[1].URL}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to finish config: %v", err)
+	}
+	app, err := newApplication(cfg, "")
+	if err != nil {
+		t.Fatalf("Failed to create application: %v", err)
+	}
+	defer app.Close()
+
+	report, err := app.warmFrom(context.Background(), listPath, cacheproxy.WarmOptions{})
+	if err != nil {
+		t.Fatalf("Failed to warm: %v", err)
+	}
+	if report.Total != 3 || report.Succeeded != 2 || len(report.Failures) != 1 {
+		t.Errorf("Expected both targets to be warmed, got %+v", report)
+	}
+	if keys, _ := app.repo.Keys(); len(keys) != 2 {
+		t.Errorf("Expected a page cached for each target, got %v", keys)
+	}
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+// Defaults applied to the settings absent from the configuration
+const (
+	defaultStoragePath      = "http_cache.badger"
+	defaultCacheTTL         = 36 * time.Hour
+	defaultAccessLogFormat  = "json"
+	defaultAccessLogMaxMB   = 100
+	defaultAccessLogBackups = 5
+	defaultLogLevel         = "info"
+)
+
+var defaultTrackedTypes = []string{"text/html", "image/jpeg"}
+
+type (
+	// config describes everything the cacheproxy command runs, as read from a
+	// YAML, TOML or JSON file, or from the command line flags.
+	config struct {
+		Storage storageConfig  `json:"storage" yaml:"storage" toml:"storage"`
+		Targets []targetConfig `json:"targets" yaml:"targets" toml:"targets"`
+		Logging loggingConfig  `json:"logging" yaml:"logging" toml:"logging"`
+		Admin   adminConfig    `json:"admin" yaml:"admin" toml:"admin"`
+	}
+	storageConfig struct {
+		Path            string       `json:"path" yaml:"path" toml:"path"`
+		HistoryVersions int          `json:"history_versions" yaml:"history_versions" toml:"history_versions"`
+		HistoryDays     uint         `json:"history_days" yaml:"history_days" toml:"history_days"`
+		Verify          verifyConfig `json:"verify" yaml:"verify" toml:"verify"`
+	}
+	// verifyConfig checks the stored checksums on reads and in background, quarantining the corrupted entries.
+	verifyConfig struct {
+		// SampleRate is the fraction of reads verified, from 0 to 1
+		SampleRate    float64  `json:"sample_rate" yaml:"sample_rate" toml:"sample_rate"`
+		ScrubInterval duration `json:"scrub_interval" yaml:"scrub_interval" toml:"scrub_interval"`
+		// ScrubRate is the amount of entries verified per second while scrubbing
+		ScrubRate int `json:"scrub_rate" yaml:"scrub_rate" toml:"scrub_rate"`
+	}
+	targetConfig struct {
+		URL          string        `json:"url" yaml:"url" toml:"url"`
+		Port         uint16        `json:"port" yaml:"port" toml:"port"`
+		TTL          duration      `json:"ttl" yaml:"ttl" toml:"ttl"`
+		NegativeTTL  duration      `json:"negative_ttl" yaml:"negative_ttl" toml:"negative_ttl"`
+		TrackedTypes []string      `json:"tracked_types" yaml:"tracked_types" toml:"tracked_types"`
+		Rules        []ruleConfig  `json:"rules" yaml:"rules" toml:"rules"`
+		Limits       limitsConfig  `json:"limits" yaml:"limits" toml:"limits"`
+		TLS          tlsConfig     `json:"tls" yaml:"tls" toml:"tls"`
+		Rewrite      rewriteConfig `json:"rewrite" yaml:"rewrite" toml:"rewrite"`
+		Egress       egressConfig  `json:"egress" yaml:"egress" toml:"egress"`
+		// Bind is the host or IP listened on, every interface when empty
+		Bind   string       `json:"bind" yaml:"bind" toml:"bind"`
+		Access accessConfig `json:"access" yaml:"access" toml:"access"`
+		// Headers changes the headers sent upstream and the ones stored with cached responses
+		Headers    headersConfig    `json:"headers" yaml:"headers" toml:"headers"`
+		Namespaces namespacesConfig `json:"namespaces" yaml:"namespaces" toml:"namespaces"`
+		Bandwidth  bandwidthConfig  `json:"bandwidth" yaml:"bandwidth" toml:"bandwidth"`
+		Prefetch   prefetchConfig   `json:"prefetch" yaml:"prefetch" toml:"prefetch"`
+		// Offline serves only from the storage, replaying the streams recorded with RecordStreams
+		Offline       bool `json:"offline" yaml:"offline" toml:"offline"`
+		RecordStreams bool `json:"record_streams" yaml:"record_streams" toml:"record_streams"`
+	}
+	// tlsConfig serves the target over HTTPS, with a self-signed certificate when no files are given.
+	tlsConfig struct {
+		Enabled  bool     `json:"enabled" yaml:"enabled" toml:"enabled"`
+		CertFile string   `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+		KeyFile  string   `json:"key_file" yaml:"key_file" toml:"key_file"`
+		Hosts    []string `json:"hosts" yaml:"hosts" toml:"hosts"`
+	}
+	// rewriteConfig points the origin URLs of served pages to the proxy.
+	rewriteConfig struct {
+		Enabled    bool     `json:"enabled" yaml:"enabled" toml:"enabled"`
+		PublicURL  string   `json:"public_url" yaml:"public_url" toml:"public_url"`
+		AssetHosts []string `json:"asset_hosts" yaml:"asset_hosts" toml:"asset_hosts"`
+	}
+	// egressConfig sends the upstream requests through a pool of HTTP or SOCKS5 proxies.
+	egressConfig struct {
+		Proxies []string `json:"proxies" yaml:"proxies" toml:"proxies"`
+		// Selection is round_robin, the default, or sticky to keep each host on the same proxy
+		Selection        string   `json:"selection" yaml:"selection" toml:"selection"`
+		FailureThreshold int      `json:"failure_threshold" yaml:"failure_threshold" toml:"failure_threshold"`
+		EjectFor         duration `json:"eject_for" yaml:"eject_for" toml:"eject_for"`
+	}
+	// accessConfig restricts the clients of a target listener to some networks and credentials.
+	accessConfig struct {
+		AllowedNetworks []string     `json:"allowed_networks" yaml:"allowed_networks" toml:"allowed_networks"`
+		Users           []userConfig `json:"users" yaml:"users" toml:"users"`
+		Tokens          []string     `json:"tokens" yaml:"tokens" toml:"tokens"`
+	}
+	// headersConfig names accept a trailing *, like X-Amz-*, to match every header with the prefix.
+	headersConfig struct {
+		Inject       []headerConfig `json:"inject" yaml:"inject" toml:"inject"`
+		StripRequest []string       `json:"strip_request" yaml:"strip_request" toml:"strip_request"`
+		DropStored   []string       `json:"drop_stored" yaml:"drop_stored" toml:"drop_stored"`
+		RedactStored []string       `json:"redact_stored" yaml:"redact_stored" toml:"redact_stored"`
+	}
+	// namespacesConfig splits the cache of a target, selecting namespaces by the X-Cache-Namespace
+	// header, the path prefix, or the default one.
+	namespacesConfig struct {
+		Enabled    bool              `json:"enabled" yaml:"enabled" toml:"enabled"`
+		Default    string            `json:"default" yaml:"default" toml:"default"`
+		PathPrefix string            `json:"path_prefix" yaml:"path_prefix" toml:"path_prefix"`
+		Declared   []namespaceConfig `json:"declared" yaml:"declared" toml:"declared"`
+	}
+	namespaceConfig struct {
+		Name string   `json:"name" yaml:"name" toml:"name"`
+		TTL  duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+		// Parent is looked up on misses when Fallthrough is set, the shared keyspace when empty
+		Parent      string `json:"parent" yaml:"parent" toml:"parent"`
+		Fallthrough bool   `json:"fallthrough" yaml:"fallthrough" toml:"fallthrough"`
+	}
+	// bandwidthConfig serves the target only from the cache once the upstream budget is spent
+	// in the rolling window.
+	bandwidthConfig struct {
+		DailyBudgetMB int64 `json:"daily_budget_mb" yaml:"daily_budget_mb" toml:"daily_budget_mb"`
+		// Window is the rolling accounting period, the last 24 hours when empty
+		Window duration `json:"window" yaml:"window" toml:"window"`
+	}
+	// prefetchConfig fetches in background the stylesheets, scripts and images of the stored HTML pages.
+	prefetchConfig struct {
+		Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+		// AllowedHosts are the hosts of other targets, like a static subdomain, whose assets are
+		// also fetched, through the proxy of their target
+		AllowedHosts []string `json:"allowed_hosts" yaml:"allowed_hosts" toml:"allowed_hosts"`
+		Concurrency  int      `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+		MaxPerPage   int      `json:"max_per_page" yaml:"max_per_page" toml:"max_per_page"`
+	}
+	headerConfig struct {
+		Name  string `json:"name" yaml:"name" toml:"name"`
+		Value string `json:"value" yaml:"value" toml:"value"`
+	}
+	userConfig struct {
+		Username string `json:"username" yaml:"username" toml:"username"`
+		Password string `json:"password" yaml:"password" toml:"password"`
+	}
+	ruleConfig struct {
+		PathPrefix   string   `json:"path_prefix" yaml:"path_prefix" toml:"path_prefix"`
+		Methods      []string `json:"methods" yaml:"methods" toml:"methods"`
+		Canonicalize string   `json:"canonicalize" yaml:"canonicalize" toml:"canonicalize"`
+	}
+	limitsConfig struct {
+		RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+		Burst             int     `json:"burst" yaml:"burst" toml:"burst"`
+		MaxInFlight       int     `json:"max_inflight" yaml:"max_inflight" toml:"max_inflight"`
+		// Overflow is wait, the default, too_many_requests or unavailable, applied past MaxQueue waiting requests
+		Overflow        string   `json:"overflow" yaml:"overflow" toml:"overflow"`
+		MaxQueue        int      `json:"max_queue" yaml:"max_queue" toml:"max_queue"`
+		MaxPause        duration `json:"max_pause" yaml:"max_pause" toml:"max_pause"`
+		RobotsAgent     string   `json:"robots_agent" yaml:"robots_agent" toml:"robots_agent"`
+		RetryAttempts   int      `json:"retry_attempts" yaml:"retry_attempts" toml:"retry_attempts"`
+		BreakerFailures int      `json:"breaker_failures" yaml:"breaker_failures" toml:"breaker_failures"`
+	}
+	loggingConfig struct {
+		Level           string `json:"level" yaml:"level" toml:"level"`
+		AccessLog       string `json:"access_log" yaml:"access_log" toml:"access_log"`
+		AccessLogFormat string `json:"access_log_format" yaml:"access_log_format" toml:"access_log_format"`
+		AccessLogMaxMB  int64  `json:"access_log_max_mb" yaml:"access_log_max_mb" toml:"access_log_max_mb"`
+		// AccessLogBackups is how many rotated access log files are kept
+		AccessLogBackups int `json:"access_log_backups" yaml:"access_log_backups" toml:"access_log_backups"`
+	}
+	adminConfig struct {
+		Listen string `json:"listen" yaml:"listen" toml:"listen"`
+		// Token is the Bearer token required by the admin endpoints
+		Token string `json:"token" yaml:"token" toml:"token"`
+	}
+
+	// duration reads a time.Duration written as text, like "90s" or "36h".
+	duration time.Duration
+
+	// fieldError reports an invalid setting, using its path in the configuration.
+	fieldError struct {
+		Path    string
+		Message string
+	}
+)
+
+func (fe fieldError) Error() string {
+	return fe.Path + ": " + fe.Message
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// readConfigFile decodes the file, choosing the format by its extension.
+// Unknown fields are rejected, so typos do not go unnoticed.
+func readConfigFile(path string) (config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+
+	var cfg config
+	switch extension := strings.ToLower(filepath.Ext(path)); extension {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		metadata, decodeErr := toml.Decode(string(content), &cfg)
+		if decodeErr != nil {
+			return config{}, fmt.Errorf("%s: %w", path, decodeErr)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return config{}, fmt.Errorf("%s: %w", path, fieldError{Path: undecoded[0].String(), Message: "unknown field"})
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&cfg); err != nil {
+			return config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return config{}, fmt.Errorf("%s: unsupported config format %q", path, extension)
+	}
+	return cfg, nil
+}
+
+// finishConfig applies the environment overrides and the defaults, then validates the result.
+func finishConfig(cfg config, environ []string) (config, error) {
+	if err := applyEnvOverrides(&cfg, envPrefix, environ); err != nil {
+		return config{}, err
+	}
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return config{}, err
+	}
+	return cfg, nil
+}
+
+func (cfg *config) applyDefaults() {
+	if cfg.Storage.Path == "" {
+		cfg.Storage.Path = defaultStoragePath
+	}
+	if cfg.Logging.Level == "" {
+		cfg.Logging.Level = defaultLogLevel
+	}
+	if cfg.Logging.AccessLogFormat == "" {
+		cfg.Logging.AccessLogFormat = defaultAccessLogFormat
+	}
+	if cfg.Logging.AccessLogMaxMB == 0 {
+		cfg.Logging.AccessLogMaxMB = defaultAccessLogMaxMB
+	}
+	if cfg.Logging.AccessLogBackups == 0 {
+		cfg.Logging.AccessLogBackups = defaultAccessLogBackups
+	}
+	for index := range cfg.Targets {
+		target := &cfg.Targets[index]
+		if target.TTL == 0 {
+			target.TTL = duration(defaultCacheTTL)
+		}
+		if target.TrackedTypes == nil {
+			target.TrackedTypes = defaultTrackedTypes
+		}
+	}
+}
+
+// validate checks every setting, reporting all the invalid ones at once.
+func (cfg *config) validate() error {
+	var errs []error
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, fieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.Storage.HistoryVersions < 0 {
+		fail("storage.history_versions", "must not be negative")
+	}
+	if verify := cfg.Storage.Verify; verify.SampleRate < 0 || verify.SampleRate > 1 {
+		fail("storage.verify.sample_rate", "must be between 0 and 1, got %v", verify.SampleRate)
+	}
+	if verify := cfg.Storage.Verify; verify.ScrubInterval < 0 || verify.ScrubRate < 0 {
+		fail("storage.verify", "scrub_interval and scrub_rate must not be negative")
+	}
+	if len(cfg.Targets) == 0 {
+		fail("targets", "at least one target is required")
+	}
+
+	var listeners []listenAddress
+	// The admin routes and the warm and prefetch lookups find a target by its upstream host
+	targetHosts := make(map[string]string, len(cfg.Targets))
+	for index, target := range cfg.Targets {
+		path := fmt.Sprintf("targets[%d]", index)
+		if target.URL == "" {
+			fail(path+".url", "is required")
+		} else if parsed, err := url.ParseRequestURI(target.URL); err != nil ||
+			(parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			fail(path+".url", "must be an absolute http(s) URL, got %q", target.URL)
+		} else if previous, ok := targetHosts[strings.ToLower(parsed.Host)]; ok {
+			fail(path+".url", "host %s is already proxied by %s", parsed.Host, previous)
+		} else {
+			targetHosts[strings.ToLower(parsed.Host)] = path
+		}
+		if target.Port != 0 {
+			address := listenAddress{host: target.Bind, port: strconv.Itoa(int(target.Port)), path: path}
+			if previous, ok := address.conflict(listeners); ok {
+				fail(path+".port", "%s is already used by %s", address, previous.path)
+			}
+			listeners = append(listeners, address)
+		}
+		if target.TTL < 0 {
+			fail(path+".ttl", "must not be negative")
+		}
+		if target.NegativeTTL < 0 {
+			fail(path+".negative_ttl", "must not be negative")
+		}
+		for ruleIndex, rule := range target.Rules {
+			rulePath := fmt.Sprintf("%s.rules[%d]", path, ruleIndex)
+			if rule.PathPrefix != "" && !strings.HasPrefix(rule.PathPrefix, "/") {
+				fail(rulePath+".path_prefix", "must start with /, got %q", rule.PathPrefix)
+			}
+			if _, ok := canonicalizers[rule.Canonicalize]; !ok {
+				fail(rulePath+".canonicalize", "unknown canonicalizer %q, expected json or graphql", rule.Canonicalize)
+			}
+		}
+
+		if (target.TLS.CertFile == "") != (target.TLS.KeyFile == "") {
+			fail(path+".tls", "cert_file and key_file must be given together")
+		}
+		validateEgress(path+".egress", target.Egress, fail)
+		validateAccess(path+".access", target.Access, fail)
+		validateHeaders(path+".headers", target.Headers, fail)
+		validateNamespaces(path+".namespaces", target.Namespaces, fail)
+		if publicURL := target.Rewrite.PublicURL; publicURL != "" {
+			if parsed, err := url.ParseRequestURI(publicURL); err != nil || parsed.Host == "" {
+				fail(path+".rewrite.public_url", "must be an absolute URL, got %q", publicURL)
+			}
+		}
+
+		limits := target.Limits
+		if limits.RequestsPerSecond < 0 || limits.Burst < 0 || limits.MaxInFlight < 0 || limits.MaxQueue < 0 ||
+			limits.MaxPause < 0 || limits.RetryAttempts < 0 || limits.BreakerFailures < 0 {
+			fail(path+".limits", "limits must not be negative")
+		}
+		if _, ok := overflowBehaviors[limits.Overflow]; !ok {
+			fail(path+".limits.overflow", "unknown overflow %q, expected wait, too_many_requests or unavailable", limits.Overflow)
+		}
+		if target.Bandwidth.DailyBudgetMB < 0 || target.Bandwidth.Window < 0 {
+			fail(path+".bandwidth", "daily_budget_mb and window must not be negative")
+		}
+		if target.Prefetch.Concurrency < 0 || target.Prefetch.MaxPerPage < 0 {
+			fail(path+".prefetch", "concurrency and max_per_page must not be negative")
+		}
+		for hostIndex, host := range target.Prefetch.AllowedHosts {
+			served := slices.ContainsFunc(cfg.Targets, func(other targetConfig) bool {
+				return strings.EqualFold(hostOf(other.URL), host)
+			})
+			if !served {
+				fail(
+					fmt.Sprintf("%s.prefetch.allowed_hosts[%d]", path, hostIndex),
+					"%q must be the host of a target, whose proxy fetches its assets", host,
+				)
+			}
+		}
+	}
+
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("logging.level", "unknown level %q, expected debug, info, warn or error", cfg.Logging.Level)
+	}
+	switch cfg.Logging.AccessLogFormat {
+	case "json", "common", "combined":
+	default:
+		fail("logging.access_log_format", "unknown format %q, expected json, common or combined", cfg.Logging.AccessLogFormat)
+	}
+	if cfg.Logging.AccessLogMaxMB < 0 {
+		fail("logging.access_log_max_mb", "must not be negative")
+	}
+	if cfg.Logging.AccessLogBackups < 0 {
+		fail("logging.access_log_backups", "must not be negative")
+	}
+	if cfg.Admin.Listen != "" {
+		host, port, err := net.SplitHostPort(cfg.Admin.Listen)
+		if err != nil {
+			fail("admin.listen", "must be a host:port address, got %q", cfg.Admin.Listen)
+		} else if cfg.Admin.Token == "" && !isLoopback(host) {
+			fail("admin.token", "is required when admin.listen is not a loopback address")
+		} else if address := (listenAddress{host: host, port: port, path: "admin.listen"}); port != "0" {
+			if previous, ok := address.conflict(listeners); ok {
+				fail("admin.listen", "%s is already used by %s", address, previous.path)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listenAddress is a host and port some listener of the config binds to.
+type listenAddress struct {
+	host, port, path string
+}
+
+func (address listenAddress) String() string {
+	return net.JoinHostPort(address.host, address.port)
+}
+
+// conflict returns the listener already bound to the same port on an overlapping host.
+// An empty or unspecified host listens on every interface, so it overlaps with any other.
+func (address listenAddress) conflict(listeners []listenAddress) (listenAddress, bool) {
+	for _, other := range listeners {
+		if other.port != address.port {
+			continue
+		}
+		if address.host == other.host || isEveryInterface(address.host) || isEveryInterface(other.host) {
+			return other, true
+		}
+	}
+	return listenAddress{}, false
+}
+
+func isEveryInterface(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// isLoopback reports whether a listener on host is only reachable from this machine.
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+func validateEgress(path string, egress egressConfig, fail func(path, format string, args ...any)) {
+	for index, rawURL := range egress.Proxies {
+		proxyPath := fmt.Sprintf("%s.proxies[%d]", path, index)
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			fail(proxyPath, "must be a proxy URL like http://host:port or socks5://host:port")
+			continue
+		}
+		switch parsed.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			fail(proxyPath, "unknown scheme %q, expected http, https or socks5", parsed.Scheme)
+		}
+	}
+	if _, ok := egressSelections[egress.Selection]; !ok {
+		fail(path+".selection", "unknown selection %q, expected round_robin or sticky", egress.Selection)
+	}
+	if egress.FailureThreshold < 0 {
+		fail(path+".failure_threshold", "must not be negative")
+	}
+	if egress.EjectFor < 0 {
+		fail(path+".eject_for", "must not be negative")
+	}
+}
+
+func validateNamespaces(path string, namespaces namespacesConfig, fail func(path, format string, args ...any)) {
+	if namespaces.Default != "" && !cacheproxy.ValidNamespace(namespaces.Default) {
+		fail(path+".default", "invalid namespace %q", namespaces.Default)
+	}
+	if prefix := namespaces.PathPrefix; prefix != "" && (!strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/")) {
+		fail(path+".path_prefix", "must start and end with /, got %q", prefix)
+	}
+	declared := make(map[string]bool, len(namespaces.Declared))
+	for index, namespace := range namespaces.Declared {
+		namespacePath := fmt.Sprintf("%s.declared[%d]", path, index)
+		if !cacheproxy.ValidNamespace(namespace.Name) {
+			fail(namespacePath+".name", "invalid namespace %q", namespace.Name)
+		} else if declared[namespace.Name] {
+			fail(namespacePath+".name", "namespace %q is declared twice", namespace.Name)
+		}
+		declared[namespace.Name] = true
+		if namespace.Parent != "" && !cacheproxy.ValidNamespace(namespace.Parent) {
+			fail(namespacePath+".parent", "invalid namespace %q", namespace.Parent)
+		}
+		if namespace.TTL < 0 {
+			fail(namespacePath+".ttl", "must not be negative")
+		}
+	}
+}
+
+func validateHeaders(path string, headers headersConfig, fail func(path, format string, args ...any)) {
+	for index, header := range headers.Inject {
+		if !validHeaderName(header.Name) || strings.HasSuffix(header.Name, "*") {
+			fail(fmt.Sprintf("%s.inject[%d].name", path, index), "invalid header name %q", header.Name)
+		}
+	}
+	lists := []struct {
+		name  string
+		names []string
+	}{
+		{"strip_request", headers.StripRequest},
+		{"drop_stored", headers.DropStored},
+		{"redact_stored", headers.RedactStored},
+	}
+	for _, list := range lists {
+		for index, name := range list.names {
+			if !validHeaderName(strings.TrimSuffix(name, "*")) {
+				fail(fmt.Sprintf("%s.%s[%d]", path, list.name, index), "invalid header name %q", name)
+			}
+		}
+	}
+}
+
+// validHeaderName reports whether the name holds only the token characters of RFC 9110.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		alphanumeric := ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+		if !alphanumeric && !strings.ContainsRune("!#$%&'*+-.^_`|~", char) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateAccess(path string, access accessConfig, fail func(path, format string, args ...any)) {
+	for index, network := range access.AllowedNetworks {
+		if _, err := netip.ParsePrefix(network); err != nil {
+			if _, err = netip.ParseAddr(network); err != nil {
+				fail(fmt.Sprintf("%s.allowed_networks[%d]", path, index), "must be a CIDR range or an IP, got %q", network)
+			}
+		}
+	}
+	for index, user := range access.Users {
+		if user.Username == "" || strings.Contains(user.Username, ":") || user.Password == "" {
+			fail(fmt.Sprintf("%s.users[%d]", path, index), "needs a username without colons and a password")
+		}
+	}
+	for index, token := range access.Tokens {
+		if strings.TrimSpace(token) == "" {
+			fail(fmt.Sprintf("%s.tokens[%d]", path, index), "must not be empty")
+		}
+	}
+}
+
+// redacted returns a copy of the config without credentials: the admin token, the
+// access users and tokens, the injected header values, and the passwords of the egress proxies.
+func (cfg config) redacted() config {
+	const hidden = "xxxxx"
+	if cfg.Admin.Token != "" {
+		cfg.Admin.Token = hidden
+	}
+	cfg.Targets = slices.Clone(cfg.Targets)
+	for index := range cfg.Targets {
+		access := &cfg.Targets[index].Access
+		access.Users = slices.Clone(access.Users)
+		for userIndex := range access.Users {
+			access.Users[userIndex].Password = hidden
+		}
+		access.Tokens = slices.Clone(access.Tokens)
+		for tokenIndex := range access.Tokens {
+			access.Tokens[tokenIndex] = hidden
+		}
+		headers := &cfg.Targets[index].Headers
+		headers.Inject = slices.Clone(headers.Inject)
+		for headerIndex := range headers.Inject {
+			headers.Inject[headerIndex].Value = hidden
+		}
+
+		proxies := slices.Clone(cfg.Targets[index].Egress.Proxies)
+		for proxyIndex, rawURL := range proxies {
+			if parsed, err := url.Parse(rawURL); err == nil {
+				proxies[proxyIndex] = parsed.Redacted()
+			}
+		}
+		cfg.Targets[index].Egress.Proxies = proxies
+	}
+	return cfg
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variables that override the configuration,
+// like CACHEPROXY_STORAGE_PATH or CACHEPROXY_TARGETS_0_LIMITS_BURST.
+const envPrefix = "CACHEPROXY"
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// applyEnvOverrides replaces the configuration fields that have a matching environment variable.
+// Targets are addressed by their index, and new ones are added when their variables are set.
+func applyEnvOverrides(cfg *config, prefix string, environ []string) error {
+	variables := make(map[string]string, len(environ))
+	for _, entry := range environ {
+		if name, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(name, prefix+"_") {
+			variables[name] = value
+		}
+	}
+	if len(variables) == 0 {
+		return nil
+	}
+	return overrideValue(reflect.ValueOf(cfg).Elem(), prefix, variables)
+}
+
+func overrideValue(value reflect.Value, name string, variables map[string]string) error {
+	if reflect.PointerTo(value.Type()).Implements(textUnmarshalerType) {
+		if raw, ok := variables[name]; ok {
+			if err := value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw)); err != nil {
+				return fieldError{Path: name, Message: err.Error()}
+			}
+		}
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		for index := range value.NumField() {
+			field := value.Type().Field(index)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			fieldName := name + "_" + strings.ToUpper(tag)
+			if err := overrideValue(value.Field(index), fieldName, variables); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Struct {
+			return overrideSlice(value, name, variables)
+		}
+	}
+
+	raw, ok := variables[name]
+	if !ok {
+		return nil
+	}
+	if err := setFromText(value, raw); err != nil {
+		return fieldError{Path: name, Message: err.Error()}
+	}
+	return nil
+}
+
+// overrideSlice walks the existing elements, growing the slice while variables for the next index exist.
+func overrideSlice(value reflect.Value, name string, variables map[string]string) error {
+	for index := 0; ; index++ {
+		elementName := name + "_" + strconv.Itoa(index)
+		if index >= value.Len() {
+			if !hasVariableWithPrefix(variables, elementName+"_") {
+				return nil
+			}
+			value.Set(reflect.Append(value, reflect.Zero(value.Type().Elem())))
+		}
+		if err := overrideValue(value.Index(index), elementName, variables); err != nil {
+			return err
+		}
+	}
+}
+
+func hasVariableWithPrefix(variables map[string]string, prefix string) bool {
+	for name := range variables {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setFromText(value reflect.Value, raw string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list of %s", value.Type().Elem())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
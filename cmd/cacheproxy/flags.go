@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+)
+
+// proxyFlags holds the command line settings shared by the commands that build a proxy.
+// When a config file is given, the other flags are ignored.
+type proxyFlags struct {
+	configPath      string
+	storagePath     string
+	port            uint
+	targetURL       string
+	historyVersions int
+	historyDays     uint
+	verifySample    float64
+	scrubInterval   time.Duration
+	negativeTTL     time.Duration
+	upstreamRPS     float64
+	maxInFlight     int
+	robotsAgent     string
+	retryAttempts   int
+	breakerFailures int
+	accessLogPath   string
+	accessLogFormat string
+	accessLogMaxMB  int64
+	tlsEnabled      bool
+	tlsCertFile     string
+	tlsKeyFile      string
+	offline         bool
+	recordStreams   bool
+	rewriteURLs     bool
+	egressProxies   string
+	egressSelection string
+	bind            string
+	authTokens      string
+	allowNetworks   string
+	namespace       string
+	dailyBudgetMB   int64
+	prefetch        bool
+}
+
+func (pf *proxyFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&pf.configPath, "config", "", "YAML, TOML or JSON config file, replacing the other flags")
+	flags.StringVar(&pf.storagePath, "storage", defaultStoragePath, "directory of the cache storage")
+	flags.UintVar(&pf.port, "port", 0, "port to listen on")
+	flags.StringVar(&pf.targetURL, "target-url", "", "target URL")
+	flags.IntVar(&pf.historyVersions, "history-versions", 0, "amount of page versions to keep per key")
+	flags.UintVar(&pf.historyDays, "history-days", 0, "amount of days to keep page versions")
+	flags.Float64Var(&pf.verifySample, "verify-sample-rate", 0, "fraction of cache reads whose checksum is verified")
+	flags.DurationVar(&pf.scrubInterval, "scrub-interval", 0, "time between the background checksum passes over the storage")
+	flags.DurationVar(&pf.negativeTTL, "negative-ttl", 0, "time to keep error responses cached")
+	flags.Float64Var(&pf.upstreamRPS, "upstream-rps", 0, "max requests per second sent to upstream")
+	flags.IntVar(&pf.maxInFlight, "upstream-max-inflight", 0, "max concurrent requests sent to upstream")
+	flags.StringVar(&pf.robotsAgent, "robots-agent", "", "user agent used to obey upstream robots.txt")
+	flags.IntVar(&pf.retryAttempts, "upstream-attempts", 0, "max attempts for idempotent upstream requests")
+	flags.IntVar(&pf.breakerFailures, "breaker-failures", 0, "consecutive upstream failures that open the circuit")
+	flags.StringVar(&pf.accessLogPath, "access-log", "", "access log file, or - for stdout")
+	flags.StringVar(&pf.accessLogFormat, "access-log-format", defaultAccessLogFormat, "access log format: json, common or combined")
+	flags.Int64Var(&pf.accessLogMaxMB, "access-log-max-mb", defaultAccessLogMaxMB, "size in MB that rotates the access log file")
+	flags.BoolVar(&pf.tlsEnabled, "tls", false, "serve over HTTPS, with a self-signed certificate unless -tls-cert is given")
+	flags.StringVar(&pf.tlsCertFile, "tls-cert", "", "PEM certificate file served over HTTPS")
+	flags.StringVar(&pf.tlsKeyFile, "tls-key", "", "PEM private key file of -tls-cert")
+	flags.BoolVar(&pf.offline, "offline", false, "serve only from the cache, replaying recorded streams")
+	flags.StringVar(&pf.bind, "bind", "", "host or IP to listen on, every interface when empty")
+	flags.StringVar(&pf.authTokens, "auth-tokens", "", "comma separated Bearer tokens required from clients")
+	flags.StringVar(&pf.allowNetworks, "allow-networks", "", "comma separated CIDR ranges allowed to use the proxy")
+	flags.StringVar(&pf.egressProxies, "egress-proxies", "", "comma separated HTTP or SOCKS5 proxy URLs used to reach upstream")
+	flags.StringVar(&pf.egressSelection, "egress-selection", "", "egress proxy selection: round_robin or sticky")
+	flags.BoolVar(&pf.rewriteURLs, "rewrite-urls", false, "rewrite origin URLs in served pages to the proxy address")
+	flags.StringVar(&pf.namespace, "namespace", "", "default cache namespace, also enabling the X-Cache-Namespace header")
+	flags.Int64Var(&pf.dailyBudgetMB, "daily-budget-mb", 0, "MB fetched from upstream per day before serving only from cache")
+	flags.BoolVar(&pf.prefetch, "prefetch", false, "fetch in background the assets linked by the cached HTML pages")
+	flags.BoolVar(&pf.recordStreams, "record-streams", false, "record SSE and WebSocket streams for offline replay")
+}
+
+// load returns the validated configuration, read from the config file when one was given.
+func (pf *proxyFlags) load() (config, error) {
+	cfg := pf.config()
+	if pf.configPath != "" {
+		var err error
+		if cfg, err = readConfigFile(pf.configPath); err != nil {
+			return config{}, err
+		}
+	}
+	return finishConfig(cfg, os.Environ())
+}
+
+// config describes the single target given through the flags.
+func (pf *proxyFlags) config() config {
+	cfg := config{
+		Storage: storageConfig{
+			Path:            pf.storagePath,
+			HistoryVersions: pf.historyVersions,
+			HistoryDays:     pf.historyDays,
+			Verify:          verifyConfig{SampleRate: pf.verifySample, ScrubInterval: duration(pf.scrubInterval)},
+		},
+		Logging: loggingConfig{
+			AccessLog:       pf.accessLogPath,
+			AccessLogFormat: pf.accessLogFormat,
+			AccessLogMaxMB:  pf.accessLogMaxMB,
+		},
+	}
+	if pf.targetURL != "" {
+		cfg.Targets = []targetConfig{{
+			URL:         pf.targetURL,
+			Port:        uint16(pf.port),
+			NegativeTTL: duration(pf.negativeTTL),
+			Limits: limitsConfig{
+				RequestsPerSecond: pf.upstreamRPS,
+				MaxInFlight:       pf.maxInFlight,
+				RobotsAgent:       pf.robotsAgent,
+				RetryAttempts:     pf.retryAttempts,
+				BreakerFailures:   pf.breakerFailures,
+			},
+			TLS:           tlsConfig{Enabled: pf.tlsEnabled, CertFile: pf.tlsCertFile, KeyFile: pf.tlsKeyFile},
+			Rewrite:       rewriteConfig{Enabled: pf.rewriteURLs},
+			Egress:        egressConfig{Proxies: splitList(pf.egressProxies), Selection: pf.egressSelection},
+			Bind:          pf.bind,
+			Access:        accessConfig{AllowedNetworks: splitList(pf.allowNetworks), Tokens: splitList(pf.authTokens)},
+			Offline:       pf.offline,
+			RecordStreams: pf.recordStreams,
+			Namespaces:    namespacesConfig{Enabled: pf.namespace != "", Default: pf.namespace},
+			Bandwidth:     bandwidthConfig{DailyBudgetMB: pf.dailyBudgetMB},
+			Prefetch:      prefetchConfig{Enabled: pf.prefetch},
+		}}
+	}
+	return cfg
+}
+
+// splitList returns the non-empty items of a comma separated flag.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
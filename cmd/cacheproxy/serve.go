@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// runServe implements `cacheproxy serve`, running the proxies until a shutdown signal.
+func runServe(args []string, _ io.Writer) int {
+	var (
+		settings proxyFlags
+		flags    = flag.NewFlagSet("serve", flag.ContinueOnError)
+	)
+	settings.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if settings.configPath == "" && settings.targetURL == "" {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := settings.load()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 2
+	}
+
+	app, err := newApplication(cfg, settings.configPath)
+	if err != nil {
+		slog.Error("failed to initialize cacheproxy", slog.String("err", err.Error()))
+		return 1
+	}
+	defer func() {
+		if closeErr := app.Close(); closeErr != nil {
+			slog.Error("failed to close cacheproxy resources", slog.String("error", closeErr.Error()))
+		}
+	}()
+
+	exitCode := 0
+	if runErr := app.run(gracefulShutdown()); runErr != nil && !errors.Is(runErr, context.Canceled) {
+		slog.Error("cacheproxy stopped", slog.String("error", runErr.Error()))
+		exitCode = 1
+	}
+
+	slog.Info("Shutdown complete, exiting...")
+	return exitCode
+}
@@ -6,8 +6,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long the listeners wait for the requests in flight
+const shutdownTimeout = time.Minute >> 1
+
 func gracefulShutdown() (ctx context.Context) {
 	// Create a context that will be canceled on shutdown
 	var cancel context.CancelFunc
@@ -26,3 +30,23 @@ func gracefulShutdown() (ctx context.Context) {
 
 	return
 }
+
+// reloadOnHangup reloads the application config every time SIGHUP is received, until ctx is done.
+func (app *application) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := app.reload(); err != nil {
+				slog.Error("failed to reload config", slog.String("error", err.Error()))
+				continue
+			}
+			slog.Info("Config reloaded", slog.String("path", app.configPath))
+		}
+	}
+}
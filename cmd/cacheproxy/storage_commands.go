@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+func runList(args []string, out io.Writer) int {
+	var (
+		cmd                    = newStorageCommand("ls", "")
+		prefix, contains, mime string
+		long                   bool
+	)
+	cmd.flags.StringVar(&prefix, "prefix", "", "only keys starting with the prefix")
+	cmd.flags.StringVar(&contains, "contains", "", "only keys containing the text")
+	cmd.flags.StringVar(&mime, "mime", "", "only entries whose MIME type starts with the text")
+	cmd.flags.BoolVar(&long, "l", false, "show status, MIME type, size and modification time")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	err := repo.Walk(prefix, func(key string, info cacheproxy.FileInformation, decodeErr error) error {
+		if contains != "" && !strings.Contains(key, contains) {
+			return nil
+		}
+		if mime != "" && (decodeErr != nil || !strings.HasPrefix(info.MimeType, mime)) {
+			return nil
+		}
+		if !long {
+			_, err := fmt.Fprintln(table, key)
+			return err
+		}
+		if decodeErr != nil {
+			_, err := fmt.Fprintf(table, "%s\t-\t-\t-\t-\n", key)
+			return err
+		}
+		_, err := fmt.Fprintf(
+			table, "%s\t%d\t%s\t%d\t%s\n", key, info.Envelope.Status,
+			orDash(info.MimeType), len(info.Content), info.ModifiedAt.Format(time.RFC3339),
+		)
+		return err
+	})
+	if err == nil {
+		err = table.Flush()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to list keys: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runCat(args []string, out io.Writer) int {
+	var (
+		cmd             = newStorageCommand("cat", "<key>")
+		headers, noBody bool
+		versionID       string
+	)
+	cmd.flags.BoolVar(&headers, "headers", false, "print the status and headers before the body")
+	cmd.flags.BoolVar(&noBody, "no-body", false, "do not print the body")
+	cmd.flags.StringVar(&versionID, "version", "", "print a stored revision instead of the current entry")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+	if cmd.flags.NArg() != 1 {
+		cmd.flags.Usage()
+		return 2
+	}
+
+	key := cmd.flags.Arg(0)
+	var (
+		info cacheproxy.FileInformation
+		err  error
+	)
+	if versionID != "" {
+		info, err = repo.Version(key, versionID)
+	} else {
+		info, err = repo.Get(key)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", key, err)
+		return 1
+	}
+
+	if headers {
+		_, _ = fmt.Fprintf(out, "Status: %d\n", info.Envelope.Status)
+		names := make([]string, 0, len(info.Envelope.Headers))
+		for name := range info.Envelope.Headers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(out, "%s: %s\n", name, strings.Join(info.Envelope.Headers[name], ", "))
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+	if !noBody {
+		if _, err = out.Write(info.Content); err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+func runVersions(args []string, out io.Writer) int {
+	cmd := newStorageCommand("versions", "<key>")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+	if cmd.flags.NArg() != 1 {
+		cmd.flags.Usage()
+		return 2
+	}
+
+	key := cmd.flags.Arg(0)
+	versions, err := repo.Versions(key)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to list versions of %s: %v\n", key, err)
+		return 1
+	}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, version := range versions {
+		_, _ = fmt.Fprintf(table, "%s\t%s\t%x\n", version.ID, version.CreatedAt.Format(time.RFC3339), version.Checksum)
+	}
+	if err = table.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func runDiff(args []string, out io.Writer) int {
+	cmd := newStorageCommand("diff", "<key> [<from-version> <to-version>]")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+	if cmd.flags.NArg() != 1 && cmd.flags.NArg() != 3 {
+		cmd.flags.Usage()
+		return 2
+	}
+
+	key, fromID, toID := cmd.flags.Arg(0), cmd.flags.Arg(1), cmd.flags.Arg(2)
+	if fromID == "" {
+		// Without versions, compare the two newest revisions
+		versions, err := repo.Versions(key)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to list versions of %s: %v\n", key, err)
+			return 1
+		}
+		if len(versions) < 2 {
+			_, _ = fmt.Fprintf(os.Stderr, "%s has %d versions, at least 2 are needed\n", key, len(versions))
+			return 1
+		}
+		fromID, toID = versions[len(versions)-2].ID, versions[len(versions)-1].ID
+	}
+
+	diff, err := cacheproxy.DiffStoredVersions(repo, key, fromID, toID)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to diff %s: %v\n", key, err)
+		return 1
+	}
+	if _, err = io.WriteString(out, diff); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func runRemove(args []string, out io.Writer) int {
+	cmd := newStorageCommand("rm", "<key>...")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+	if cmd.flags.NArg() == 0 {
+		cmd.flags.Usage()
+		return 2
+	}
+
+	exitCode := 0
+	for _, key := range cmd.flags.Args() {
+		if _, err := repo.Get(key); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", key, err)
+			exitCode = 1
+			continue
+		}
+		if err := repo.Delete(key); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", key, err)
+			exitCode = 1
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "removed %s\n", key)
+	}
+	return exitCode
+}
+
+func runPurge(args []string, out io.Writer) int {
+	var (
+		cmd       = newStorageCommand("purge", "")
+		prefix    string
+		namespace string
+		all       bool
+	)
+	cmd.flags.StringVar(&prefix, "prefix", "", "remove the keys starting with the prefix")
+	cmd.flags.StringVar(&namespace, "namespace", "", "remove the keys of the cache namespace")
+	cmd.flags.BoolVar(&all, "all", false, "remove every key")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+	if namespace != "" {
+		if !cacheproxy.ValidNamespace(namespace) {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid namespace %q\n", namespace)
+			return 2
+		}
+		prefix = cacheproxy.NamespaceKeyPrefix(namespace) + prefix
+	}
+	if prefix == "" && !all {
+		_, _ = fmt.Fprintln(os.Stderr, "purge needs -prefix, -namespace, or -all to empty the cache")
+		return 2
+	}
+
+	removed, err := repo.DeletePrefix(prefix)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to purge: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(out, "removed %d keys\n", removed)
+	return 0
+}
+
+func runStats(args []string, out io.Writer) int {
+	cmd := newStorageCommand("stats", "")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	var (
+		entries, undecodable, negative int
+		contentBytes                   int64
+		byMIME                         = make(map[string]int)
+	)
+	err := repo.Walk("", func(key string, info cacheproxy.FileInformation, decodeErr error) error {
+		entries++
+		if decodeErr != nil {
+			undecodable++
+			return nil
+		}
+		contentBytes += int64(len(info.Content))
+		if info.ExtraMetadata[cacheproxy.MetadataCacheError] != "" {
+			negative++
+		}
+		mimeType, _, _ := strings.Cut(info.MimeType, ";")
+		byMIME[orDash(strings.TrimSpace(mimeType))]++
+		return nil
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to read storage: %v\n", err)
+		return 1
+	}
+
+	stats := repo.Stats()
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(table, "entries\t%d\n", entries)
+	_, _ = fmt.Fprintf(table, "negative entries\t%d\n", negative)
+	_, _ = fmt.Fprintf(table, "undecodable entries\t%d\n", undecodable)
+	_, _ = fmt.Fprintf(table, "content bytes\t%d\n", contentBytes)
+	_, _ = fmt.Fprintf(table, "lsm bytes\t%d\n", stats.LSMSize)
+	_, _ = fmt.Fprintf(table, "value log bytes\t%d\n", stats.ValueLogSize)
+
+	mimeTypes := make([]string, 0, len(byMIME))
+	for mimeType := range byMIME {
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	slices.Sort(mimeTypes)
+	for _, mimeType := range mimeTypes {
+		_, _ = fmt.Fprintf(table, "mime %s\t%d\n", mimeType, byMIME[mimeType])
+	}
+	if err = table.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func runExport(args []string, out io.Writer) int {
+	var (
+		cmd    = newStorageCommand("export", "")
+		output string
+	)
+	cmd.flags.StringVar(&output, "o", "-", "file to write the backup to, - for stdout")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	writer := out
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", output, err)
+			return 1
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := repo.Export(writer); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runImport(args []string, out io.Writer) int {
+	var (
+		cmd   = newStorageCommand("import", "")
+		input string
+	)
+	cmd.flags.StringVar(&input, "i", "-", "backup file to load, - for stdin")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	var reader io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", input, err)
+			return 1
+		}
+		defer file.Close()
+		reader = file
+	}
+	if err := repo.Import(reader); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to import: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintln(out, "import complete")
+	return 0
+}
+
+func runGC(args []string, out io.Writer) int {
+	var (
+		cmd          = newStorageCommand("gc", "")
+		discardRatio float64
+	)
+	cmd.flags.Float64Var(&discardRatio, "ratio", 0.5, "fraction of a value log file that must be stale to rewrite it")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	before := repo.Stats()
+	passes, err := repo.CollectGarbage(discardRatio)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to collect garbage: %v\n", err)
+		return 1
+	}
+	after := repo.Stats()
+	_, _ = fmt.Fprintf(
+		out, "rewrote %d value log files, value log %d -> %d bytes\n",
+		passes, before.ValueLogSize, after.ValueLogSize,
+	)
+	return 0
+}
+
+func runVerify(args []string, out io.Writer) int {
+	var (
+		cmd                      = newStorageCommand("verify", "")
+		deleteBroken, quarantine bool
+	)
+	cmd.flags.BoolVar(&deleteBroken, "delete", false, "remove the corrupted entries")
+	cmd.flags.BoolVar(&quarantine, "quarantine", false, "move the corrupted entries under the quarantine prefix")
+	repo, ok := cmd.open(args)
+	if !ok {
+		return 2
+	}
+	defer closeStorage(repo)
+
+	var (
+		checked int
+		broken  []string
+	)
+	err := repo.Walk("", func(key string, info cacheproxy.FileInformation, decodeErr error) error {
+		checked++
+		if decodeErr == nil && !info.ChecksumMatches() {
+			decodeErr = cacheproxy.ErrChecksumMismatch
+		}
+		if decodeErr != nil {
+			broken = append(broken, key)
+			_, _ = fmt.Fprintf(out, "corrupted %s: %v\n", key, decodeErr)
+		}
+		return nil
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to read storage: %v\n", err)
+		return 1
+	}
+
+	switch {
+	case deleteBroken:
+		for _, key := range broken {
+			if err = repo.Delete(key); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", key, err)
+			}
+		}
+	case quarantine && len(broken) > 0:
+		report, scrubErr := repo.Scrub(context.Background(), 0)
+		if scrubErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to quarantine: %v\n", scrubErr)
+			return 1
+		}
+		_, _ = fmt.Fprintf(out, "quarantined %d entries\n", report.Quarantined)
+	}
+	_, _ = fmt.Fprintf(out, "checked %d entries, %d corrupted\n", checked, len(broken))
+	if len(broken) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
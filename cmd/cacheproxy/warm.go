@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+// runWarm implements `cacheproxy warm`, priming the cache from sitemaps or URL lists.
+func runWarm(args []string, _ io.Writer) int {
+	var (
+		settings    proxyFlags
+		concurrency int
+		flags       = flag.NewFlagSet("warm", flag.ContinueOnError)
+	)
+	settings.register(flags)
+	flags.IntVar(&concurrency, "concurrency", 4, "amount of URLs fetched at the same time")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: %s warm [flags] <sitemap or URL list>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if (settings.configPath == "" && settings.targetURL == "") || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := settings.load()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 2
+	}
+	app, err := newApplication(cfg, settings.configPath)
+	if err != nil {
+		slog.Error("failed to initialize cacheproxy", slog.String("err", err.Error()))
+		return 1
+	}
+	defer app.Close()
+	// Flushes the cache writes and the bandwidth counters before the storage closes
+	defer func() { _ = app.shutdown() }()
+
+	ctx := gracefulShutdown()
+	options := cacheproxy.WarmOptions{
+		Concurrency: concurrency,
+		Progress: func(progress cacheproxy.WarmProgress) {
+			attrs := []any{
+				slog.String("url", progress.URL),
+				slog.Int("status", progress.Status),
+				slog.String("cache", string(progress.CacheStatus)),
+				slog.String("progress", fmt.Sprintf("%d/%d", progress.Completed, progress.Total)),
+			}
+			if progress.Err != nil {
+				slog.Warn("warm failed", append(attrs, slog.String("error", progress.Err.Error()))...)
+				return
+			}
+			slog.Info("warmed", attrs...)
+		},
+	}
+
+	exitCode := 0
+	for _, location := range flags.Args() {
+		report, warmErr := app.warmFrom(ctx, location, options)
+		if warmErr != nil {
+			slog.Error("failed to read URLs", slog.String("source", location), slog.String("error", warmErr.Error()))
+			exitCode = 1
+			continue
+		}
+		slog.Info(
+			"warm finished",
+			slog.String("source", location),
+			slog.Int("total", report.Total),
+			slog.Int("succeeded", report.Succeeded),
+			slog.Int("failed", len(report.Failures)),
+		)
+		if len(report.Failures) > 0 {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// warmFrom loads the URLs listed at the location and warms each one through the proxy
+// of its host. URLs of unknown hosts go to the first proxy, which reports them as outside.
+func (app *application) warmFrom(
+	ctx context.Context, location string, options cacheproxy.WarmOptions,
+) (cacheproxy.WarmReport, error) {
+	urls, err := app.proxyFor(location).LoadURLs(ctx, location)
+	if err != nil {
+		return cacheproxy.WarmReport{}, err
+	}
+
+	groups := make(map[*cacheproxy.CacheableProxy][]string, len(app.proxies))
+	for _, rawURL := range urls {
+		proxy := app.proxyFor(rawURL)
+		groups[proxy] = append(groups[proxy], rawURL)
+	}
+	var report cacheproxy.WarmReport
+	for _, proxy := range app.proxies {
+		if len(groups[proxy]) == 0 {
+			continue
+		}
+		groupReport := proxy.Warm(ctx, groups[proxy], options)
+		report.Total += groupReport.Total
+		report.Succeeded += groupReport.Succeeded
+		report.Failures = append(report.Failures, groupReport.Failures...)
+	}
+	return report, nil
+}
+
+// proxyFor returns the proxy whose target serves the URL host, or the first one.
+func (app *application) proxyFor(rawURL string) *cacheproxy.CacheableProxy {
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		if proxy := app.targetProxy(parsed.Host); proxy != nil {
+			return proxy
+		}
+	}
+	return app.proxies[0]
+}
+
+// targetProxy returns the proxy of the target with the given host, nil when no target has it.
+func (app *application) targetProxy(host string) *cacheproxy.CacheableProxy {
+	for index, target := range app.currentConfig().Targets {
+		if strings.EqualFold(hostOf(target.URL), host) && index < len(app.proxies) {
+			return app.proxies[index]
+		}
+	}
+	return nil
+}
@@ -3,8 +3,15 @@ package crawler
 import (
 	"context"
 	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/jictyvoo/radadar_crawlsdk/internal/domain/crawler"
+
 type (
 	FetchResult struct {
 		Err      error
@@ -40,12 +47,22 @@ func (w fetchWorker) Run(ctx context.Context, onFinishCallback func()) (err erro
 			if !ok {
 				return ErrInputChannelClosed
 			}
-			respBody, downErr := w.datasource.DownloadPage(url)
-			w.outputCh <- FetchResult{
-				Err:      downErr,
-				url:      url,
-				RespBody: respBody,
-			}
+			w.outputCh <- w.fetch(ctx, url)
 		}
 	}
 }
+
+func (w fetchWorker) fetch(ctx context.Context, url string) FetchResult {
+	ctx, span := otel.Tracer(tracerName).Start(
+		ctx, "ParallelFetch.fetch",
+		trace.WithAttributes(attribute.String("url.full", url)),
+	)
+	defer span.End()
+
+	respBody, downErr := w.datasource.DownloadPage(ctx, url)
+	if downErr != nil {
+		span.RecordError(downErr)
+		span.SetStatus(codes.Error, downErr.Error())
+	}
+	return FetchResult{Err: downErr, url: url, RespBody: respBody}
+}
@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jictyvoo/radadar_crawlsdk/internal/repositories/datasources/dsrest"
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/datatypes"
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
+)
+
+func TestParallelFetch_SpanChain(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var proxyTraceParent string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyTraceParent = r.Header.Get("Traceparent")
+		_, _ = w.Write([]byte("<html>page</html>"))
+	}))
+	defer proxyServer.Close()
+
+	originURL, _ := url.Parse("http://origin.test")
+	fetcher := NewParallelFetch(datatypes.NewConstructorFactory(func() FetchDatasource {
+		return dsrest.NewHTTPDatasource(
+			httptransport.NewTransportRewrite(originURL, proxyServer.Listener.Addr().String()),
+		)
+	}))
+	if err := fetcher.Start(context.Background(), 1); err != nil {
+		t.Fatalf("Failed to start the workers: %v", err)
+	}
+	fetcher.Fetch(originURL.String() + "/page")
+	for _, result := range fetcher.Responses() {
+		if result.Err != nil || result.RespBody != "<html>page</html>" {
+			t.Errorf("Expected the page body, got %q (%v)", result.RespBody, result.Err)
+		}
+		break
+	}
+	fetcher.Stop()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	parent, found := spans["ParallelFetch.fetch"]
+	if !found {
+		t.Fatalf("Expected a ParallelFetch.fetch span, got %v", spans)
+	}
+	child, found := spans["HTTPDatasource.attemptRequest"]
+	if !found {
+		t.Fatalf("Expected a HTTPDatasource.attemptRequest span, got %v", spans)
+	}
+	if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Errorf("Expected the request span to be a child of the fetch span")
+	}
+	rewrite, found := spans["TransportRewrite.RoundTrip"]
+	if !found || rewrite.Parent.SpanID() != child.SpanContext.SpanID() {
+		t.Fatalf("Expected a TransportRewrite.RoundTrip span child of the request span, got %v", spans)
+	}
+
+	carrier := propagation.HeaderCarrier{"Traceparent": {proxyTraceParent}}
+	remote := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	if remote.TraceID() != parent.SpanContext.TraceID() || remote.SpanID() != rewrite.SpanContext.SpanID() {
+		t.Errorf("Expected the proxy to receive the rewrite span context, got %q", proxyTraceParent)
+	}
+}
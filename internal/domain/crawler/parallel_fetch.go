@@ -11,7 +11,7 @@ import (
 
 type (
 	FetchDatasource interface {
-		DownloadPage(url string) (string, error)
+		DownloadPage(ctx context.Context, url string) (string, error)
 		Close() error
 	}
 	ParallelFetch struct {
@@ -2,8 +2,11 @@ package badgerepo
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -11,15 +14,30 @@ import (
 	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
 )
 
-type RemoteFileCache struct {
-	db            *badger.DB
-	entryTTL      time.Duration
-	mutex         sync.Mutex
-	finishThreads context.CancelFunc
+type (
+	CacheOption     func(cache *RemoteFileCache)
+	RemoteFileCache struct {
+		db             *badger.DB
+		entryTTL       time.Duration
+		history        *cacheproxy.HistoryPolicy
+		verify         *VerifyPolicy
+		mutex          sync.Mutex
+		gcRuns         atomic.Uint64
+		corruptEntries atomic.Uint64
+		scrubPasses    atomic.Uint64
+		finishThreads  context.CancelFunc
+	}
+)
+
+// WithHistory keeps older revisions of every key, according to the given policy.
+func WithHistory(policy cacheproxy.HistoryPolicy) CacheOption {
+	return func(cache *RemoteFileCache) {
+		cache.history = &policy
+	}
 }
 
 // NewRemoteFileCache initializes a new Badger database instance for the RemoteFileCache
-func NewRemoteFileCache(dbPath string) (*RemoteFileCache, error) {
+func NewRemoteFileCache(dbPath string, options ...CacheOption) (*RemoteFileCache, error) {
 	// Set up Badger options and open the database
 	opts := badger.DefaultOptions(dbPath)
 	db, err := badger.Open(opts)
@@ -28,8 +46,15 @@ func NewRemoteFileCache(dbPath string) (*RemoteFileCache, error) {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	go gcThread(ctx, db)
-	return &RemoteFileCache{db: db, entryTTL: 36 * time.Hour, finishThreads: cancel}, nil
+	cache := &RemoteFileCache{db: db, entryTTL: 36 * time.Hour, finishThreads: cancel}
+	for _, option := range options {
+		option(cache)
+	}
+	go gcThread(ctx, db, &cache.gcRuns)
+	if cache.verify != nil && cache.verify.ScrubInterval > 0 {
+		go scrubThread(ctx, cache)
+	}
+	return cache, nil
 }
 
 // Set stores a key-value pair in the Badger database
@@ -42,14 +67,26 @@ func (r *RemoteFileCache) Set(key string, information cacheproxy.FileInformation
 		if encodErr != nil {
 			return encodErr
 		}
+		// The bandwidth counters outlive the cached entries, and have no history worth keeping
+		if strings.HasPrefix(key, cacheproxy.BandwidthKeyPrefix) {
+			return txn.SetEntry(badger.NewEntry([]byte(key), valBytes))
+		}
 		badgerEntry := badger.NewEntry([]byte(key), valBytes).WithTTL(r.entryTTL).WithDiscard()
-		return txn.SetEntry(badgerEntry)
+		if err := txn.SetEntry(badgerEntry); err != nil {
+			return err
+		}
+
+		if r.history == nil {
+			return nil
+		}
+		return r.storeVersion(txn, key, information.Checksum, valBytes)
 	})
 
 	return err
 }
 
-// Get retrieves a value by key from the Badger database
+// Get retrieves a value by key from the Badger database.
+// With WithVerification, a corrupted entry is quarantined and reported as not found.
 func (r *RemoteFileCache) Get(key string) (cacheproxy.FileInformation, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -68,8 +105,22 @@ func (r *RemoteFileCache) Get(key string) (cacheproxy.FileInformation, error) {
 		return cacheproxy.FileInformation{}, err
 	}
 
+	var information cacheproxy.FileInformation
+	if r.shouldVerify() {
+		var checkErr error
+		if information, checkErr = checkEntry(valCopy); checkErr != nil {
+			if err = r.quarantine(key, valCopy, checkErr); err != nil {
+				return cacheproxy.FileInformation{}, errors.Join(corruptedEntryError(key, checkErr), err)
+			}
+			return cacheproxy.FileInformation{}, corruptedEntryError(key, checkErr)
+		}
+	} else if information, err = DecodeFileInfo(valCopy); err != nil {
+		return information, err
+	}
+
+	// Logged once the entry is known to be served, a quarantined one is a miss
 	slog.Info("Successfully loaded data from cache", slog.String("key", key))
-	return DecodeFileInfo(valCopy)
+	return information, nil
 }
 
 // Close closes the Badger database
@@ -80,6 +131,26 @@ func (r *RemoteFileCache) Close() error {
 	return r.db.Close()
 }
 
+// Stats reports the size of the LSM tree and value log, and how many GC passes ran.
+func (r *RemoteFileCache) Stats() cacheproxy.StorageStats {
+	lsmSize, valueLogSize := r.db.Size()
+	return cacheproxy.StorageStats{
+		LSMSize:        lsmSize,
+		ValueLogSize:   valueLogSize,
+		GCRuns:         r.gcRuns.Load(),
+		CorruptEntries: r.corruptEntries.Load(),
+		ScrubPasses:    r.scrubPasses.Load(),
+	}
+}
+
+// Healthy reports an error once the database is closed.
+func (r *RemoteFileCache) Healthy() error {
+	if r.db.IsClosed() {
+		return badger.ErrDBClosed
+	}
+	return nil
+}
+
 func (r *RemoteFileCache) Keys() (keys []string, err error) {
 	err = r.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -88,11 +159,19 @@ func (r *RemoteFileCache) Keys() (keys []string, err error) {
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
-			k := item.Key()
-			keys = append(keys, string(k))
+			k := string(item.Key())
+			if isInternalKey(k) {
+				continue
+			}
+			keys = append(keys, k)
 		}
 		return nil
 	})
 
 	return
 }
+
+func isInternalKey(key string) bool {
+	return strings.HasPrefix(key, historyPrefix) || strings.HasPrefix(key, quarantinePrefix) ||
+		strings.HasPrefix(key, cacheproxy.BandwidthKeyPrefix)
+}
@@ -5,6 +5,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/dgraph-io/badger/v4"
+
 	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
 )
 
@@ -112,3 +114,70 @@ func TestRemoteFileCache_Keys(t *testing.T) {
 		}
 	}
 }
+
+// Test the bandwidth counters are kept without TTL nor history, and left out of the keys
+func TestRemoteFileCache_BandwidthKey(t *testing.T) {
+	cache, err := NewRemoteFileCache(
+		createTempDir(t), WithHistory(cacheproxy.HistoryPolicy{MaxVersions: 2}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	key := cacheproxy.BandwidthKeyPrefix + "example.com"
+	if err = cache.Set(key, fixtureFileInfo()); err != nil {
+		t.Fatalf("Failed to set the counters: %v", err)
+	}
+	if _, err = cache.Get(key); err != nil {
+		t.Errorf("Expected the counters to be stored, got %v", err)
+	}
+	if keys, _ := cache.Keys(); len(keys) != 0 {
+		t.Errorf("Expected the counters out of the keys, got %v", keys)
+	}
+	if versions, _ := cache.Versions(key); len(versions) != 0 {
+		t.Errorf("Expected no history for the counters, got %d versions", len(versions))
+	}
+	_ = cache.db.View(func(txn *badger.Txn) error {
+		item, getErr := txn.Get([]byte(key))
+		if getErr == nil && item.ExpiresAt() != 0 {
+			t.Errorf("Expected the counters to never expire, got %d", item.ExpiresAt())
+		}
+		return getErr
+	})
+}
+
+// Test Stats reports the database sizes
+func TestRemoteFileCache_Stats(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	if err = cache.Set("statsKEY", fixtureFileInfo()); err != nil {
+		t.Fatalf("Failed to set key in cache: %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.LSMSize < 0 || stats.ValueLogSize < 0 {
+		t.Errorf("Expected non negative sizes, got %+v", stats)
+	}
+	if stats.GCRuns != 0 {
+		t.Errorf("Expected no GC runs on a new database, got %d", stats.GCRuns)
+	}
+}
+
+func TestRemoteFileCache_Healthy(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	if err = cache.Healthy(); err != nil {
+		t.Errorf("Expected an open database to be healthy, got %v", err)
+	}
+	_ = cache.Close()
+	if err = cache.Healthy(); err == nil {
+		t.Errorf("Expected a closed database to be unhealthy")
+	}
+}
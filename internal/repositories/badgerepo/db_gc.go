@@ -2,12 +2,13 @@ package badgerepo
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
 )
 
-func gcThread(ctx context.Context, db *badger.DB) {
+func gcThread(ctx context.Context, db *badger.DB, runs *atomic.Uint64) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 	for {
@@ -16,6 +17,7 @@ func gcThread(ctx context.Context, db *badger.DB) {
 			return
 		case <-ticker.C:
 			_ = runGC(db)
+			runs.Add(1)
 		}
 	}
 }
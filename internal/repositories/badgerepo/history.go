@@ -0,0 +1,164 @@
+package badgerepo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+const (
+	historyPrefix    = "history://"
+	historySeparator = "\x00"
+	// versionChecksumSeparator splits the creation time from the checksum in a version ID.
+	versionChecksumSeparator = "-"
+)
+
+func historyKeyPrefix(key string) []byte {
+	return []byte(historyPrefix + key + historySeparator)
+}
+
+// storeVersion appends a new revision for the key, but only when its checksum changed.
+func (r *RemoteFileCache) storeVersion(
+	txn *badger.Txn, key string, checksum []byte, valBytes []byte,
+) error {
+	versionKeys, lastChecksum, err := listVersionKeys(txn, key)
+	if err != nil {
+		return err
+	}
+	if len(versionKeys) > 0 && bytes.Equal(lastChecksum, checksum) {
+		return nil
+	}
+
+	versionKey := append(historyKeyPrefix(key), versionID(time.Now(), checksum)...)
+	entry := badger.NewEntry(versionKey, valBytes)
+	if r.history.MaxAge > 0 {
+		entry = entry.WithTTL(r.history.MaxAge)
+	}
+	if err = txn.SetEntry(entry); err != nil {
+		return err
+	}
+	versionKeys = append(versionKeys, versionKey)
+
+	// Remove the oldest versions that exceed the policy limit
+	if r.history.MaxVersions <= 0 || len(versionKeys) <= r.history.MaxVersions {
+		return nil
+	}
+	for _, oldKey := range versionKeys[:len(versionKeys)-r.history.MaxVersions] {
+		if err = txn.Delete(oldKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionID names a revision after its creation time, followed by its checksum in hex,
+// so versions sort by age and their checksum is known without reading the value.
+func versionID(createdAt time.Time, checksum []byte) string {
+	return fmt.Sprintf("%020d%s%x", createdAt.UnixNano(), versionChecksumSeparator, checksum)
+}
+
+// listVersionKeys returns the version keys sorted from oldest to newest,
+// together with the checksum of the newest one. Only the keys are iterated.
+func listVersionKeys(txn *badger.Txn, key string) (keys [][]byte, lastChecksum []byte, err error) {
+	prefix := historyKeyPrefix(key)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var lastItem *badger.Item
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		lastItem = it.Item()
+		keys = append(keys, lastItem.KeyCopy(nil))
+	}
+	if lastItem == nil {
+		return keys, nil, nil
+	}
+
+	lastChecksum, err = versionChecksum(lastItem, prefix)
+	return keys, lastChecksum, err
+}
+
+// versionChecksum reads the checksum from the version key, or decodes the value
+// for versions stored before the checksum was part of the key.
+func versionChecksum(item *badger.Item, prefix []byte) ([]byte, error) {
+	id := strings.TrimPrefix(string(item.Key()), string(prefix))
+	if _, hexChecksum, found := strings.Cut(id, versionChecksumSeparator); found {
+		return hex.DecodeString(hexChecksum)
+	}
+
+	valBytes, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := DecodeFileInfo(valBytes)
+	if err != nil {
+		return nil, err
+	}
+	return info.Checksum, nil
+}
+
+// Versions lists every stored revision of the key, from oldest to newest.
+func (r *RemoteFileCache) Versions(key string) (versions []cacheproxy.FileVersion, err error) {
+	prefix := historyKeyPrefix(key)
+	err = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			checksum, checksumErr := versionChecksum(item, prefix)
+			if checksumErr != nil {
+				return checksumErr
+			}
+
+			id := strings.TrimPrefix(string(item.Key()), string(prefix))
+			versions = append(versions, cacheproxy.FileVersion{
+				ID:        id,
+				Checksum:  checksum,
+				CreatedAt: versionTime(id),
+			})
+		}
+		return nil
+	})
+
+	return
+}
+
+// Version loads a single revision of the key, as listed by Versions.
+func (r *RemoteFileCache) Version(key string, versionID string) (cacheproxy.FileInformation, error) {
+	var valCopy []byte
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(historyKeyPrefix(key), versionID...))
+		if err != nil {
+			return err
+		}
+		valCopy, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return cacheproxy.FileInformation{}, err
+	}
+
+	return DecodeFileInfo(valCopy)
+}
+
+func versionTime(id string) time.Time {
+	nanos, _, _ := strings.Cut(id, versionChecksumSeparator)
+	nanoseconds, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanoseconds)
+}
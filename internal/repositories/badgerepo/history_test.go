@@ -0,0 +1,85 @@
+package badgerepo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+func TestRemoteFileCache_History(t *testing.T) {
+	cache, err := NewRemoteFileCache(
+		createTempDir(t), WithHistory(cacheproxy.HistoryPolicy{MaxVersions: 2}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	const key = "file://GET@http://example.com#/page"
+	contents := []string{"first", "first", "second", "third"}
+	for _, content := range contents {
+		fileInfo := fixtureFileInfo()
+		fileInfo.Content = []byte(content)
+		fileInfo.Checksum = []byte("checksum-" + content)
+		if err = cache.Set(key, fileInfo); err != nil {
+			t.Fatalf("Failed to set key in cache: %v", err)
+		}
+	}
+
+	var versions []cacheproxy.FileVersion
+	if versions, err = cache.Versions(key); err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+
+	expectedContents := []string{"second", "third"}
+	for index, version := range versions {
+		if version.CreatedAt.IsZero() {
+			t.Errorf("Expected version `%s` to have a creation time", version.ID)
+		}
+		if expected := "checksum-" + expectedContents[index]; string(version.Checksum) != expected {
+			t.Errorf("Expected checksum %q, got %q", expected, version.Checksum)
+		}
+
+		var stored cacheproxy.FileInformation
+		if stored, err = cache.Version(key, version.ID); err != nil {
+			t.Fatalf("Failed to load version %s: %v", version.ID, err)
+		}
+		if !bytes.Equal(stored.Content, []byte(expectedContents[index])) {
+			t.Errorf("Expected content %q, got %q", expectedContents[index], stored.Content)
+		}
+	}
+
+	// History entries must not be listed as regular keys
+	var keys []string
+	if keys, err = cache.Keys(); err != nil {
+		t.Fatalf("Failed to retrieve keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("Expected only key %q, got %v", key, keys)
+	}
+}
+
+func TestRemoteFileCache_WithoutHistory(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	const key = "no-history"
+	if err = cache.Set(key, fixtureFileInfo()); err != nil {
+		t.Fatalf("Failed to set key in cache: %v", err)
+	}
+
+	var versions []cacheproxy.FileVersion
+	if versions, err = cache.Versions(key); err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected no versions, got %d", len(versions))
+	}
+}
@@ -0,0 +1,204 @@
+package badgerepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+const (
+	quarantinePrefix = "quarantine://"
+	defaultScrubRate = 100
+)
+
+type (
+	// VerifyPolicy checks the stored checksums, moving the corrupted entries under the quarantine prefix.
+	VerifyPolicy struct {
+		// SampleRate is the fraction of Get calls verified, from 0, never, to 1, always
+		SampleRate float64
+		// ScrubInterval is the time between the background passes over the database, disabled when zero
+		ScrubInterval time.Duration
+		// ScrubRate limits the entries verified per second by the background passes,
+		// rates above one per nanosecond are not paced
+		ScrubRate int
+	}
+	// ScrubReport summarizes a verification pass over the database.
+	ScrubReport struct {
+		Checked     int
+		Quarantined int
+	}
+)
+
+// WithVerification verifies the checksum of the entries read, and scrubs the database in background.
+func WithVerification(policy VerifyPolicy) CacheOption {
+	return func(cache *RemoteFileCache) {
+		if policy.ScrubRate <= 0 {
+			policy.ScrubRate = defaultScrubRate
+		}
+		cache.verify = &policy
+	}
+}
+
+// QuarantineKey is where the corrupted entry of the key is moved to.
+func QuarantineKey(key string) string {
+	return quarantinePrefix + key
+}
+
+// Quarantined lists the keys whose entry was quarantined, they expire like the other entries.
+func (r *RemoteFileCache) Quarantined() ([]string, error) {
+	keys, err := r.keysWithPrefix([]byte(quarantinePrefix))
+	if err != nil {
+		return nil, err
+	}
+	quarantined := make([]string, 0, len(keys))
+	for _, key := range keys {
+		quarantined = append(quarantined, strings.TrimPrefix(string(key), quarantinePrefix))
+	}
+	return quarantined, nil
+}
+
+func (r *RemoteFileCache) shouldVerify() bool {
+	if r.verify == nil || r.verify.SampleRate <= 0 {
+		return false
+	}
+	return r.verify.SampleRate >= 1 || rand.Float64() < r.verify.SampleRate
+}
+
+// checkEntry decodes the value, reporting why it is corrupted.
+func checkEntry(valBytes []byte) (cacheproxy.FileInformation, error) {
+	information, err := DecodeFileInfo(valBytes)
+	if err != nil {
+		return information, err
+	}
+	if !information.ChecksumMatches() {
+		return information, cacheproxy.ErrChecksumMismatch
+	}
+	return information, nil
+}
+
+// quarantine moves the corrupted value of the key under the quarantine prefix.
+// Callers must hold the mutex, so the key is not rewritten meanwhile.
+func (r *RemoteFileCache) quarantine(key string, valBytes []byte, reason error) error {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry([]byte(QuarantineKey(key)), valBytes).WithTTL(r.entryTTL)
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	r.corruptEntries.Add(1)
+	slog.Warn(
+		"Quarantined corrupted cache entry",
+		slog.String("key", key), slog.String("reason", reason.Error()),
+	)
+	return nil
+}
+
+// Scrub verifies every entry and stored version, reading at most rate entries per second,
+// and quarantines the corrupted ones. A rate of zero, or too high for a ticker, is not paced.
+func (r *RemoteFileCache) Scrub(ctx context.Context, rate int) (report ScrubReport, err error) {
+	keys, err := r.keysWithPrefix(nil)
+	if err != nil {
+		return report, err
+	}
+
+	var pace <-chan time.Time
+	if interval := time.Second / time.Duration(max(rate, 1)); rate > 0 && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+	for _, key := range keys {
+		// The history versions hold their own copy of the content, so they are verified too
+		if strings.HasPrefix(string(key), quarantinePrefix) {
+			continue
+		}
+		if pace != nil {
+			select {
+			case <-ctx.Done():
+				return report, ctx.Err()
+			case <-pace:
+			}
+		} else if err = ctx.Err(); err != nil {
+			return report, err
+		}
+
+		quarantined, scrubErr := r.scrubKey(string(key))
+		if scrubErr != nil {
+			return report, scrubErr
+		}
+		report.Checked++
+		if quarantined {
+			report.Quarantined++
+		}
+	}
+	r.scrubPasses.Add(1)
+	return report, nil
+}
+
+func (r *RemoteFileCache) scrubKey(key string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var valCopy []byte
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		valCopy, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		// Removed or expired since the keys were listed
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, checkErr := checkEntry(valCopy); checkErr != nil {
+		return true, r.quarantine(key, valCopy, checkErr)
+	}
+	return false, nil
+}
+
+func scrubThread(ctx context.Context, cache *RemoteFileCache) {
+	ticker := time.NewTicker(cache.verify.ScrubInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := cache.Scrub(ctx, cache.verify.ScrubRate)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					slog.Error("Failed to scrub the cache storage", slog.String("error", err.Error()))
+				}
+				continue
+			}
+			slog.Info(
+				"Scrubbed the cache storage",
+				slog.Int("checked", report.Checked), slog.Int("quarantined", report.Quarantined),
+			)
+		}
+	}
+}
+
+// corruptedEntryError is returned by Get for a quarantined entry, it is also a badger.ErrKeyNotFound.
+func corruptedEntryError(key string, reason error) error {
+	return fmt.Errorf("%w: corrupted entry %s: %w", badger.ErrKeyNotFound, key, reason)
+}
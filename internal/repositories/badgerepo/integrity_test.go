@@ -0,0 +1,138 @@
+package badgerepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+func checkedFileInfo(content string) cacheproxy.FileInformation {
+	sum := sha256.Sum256([]byte(content))
+	return cacheproxy.FileInformation{Content: []byte(content), Checksum: sum[:]}
+}
+
+func TestRemoteFileCache_VerifiedGet(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t), WithVerification(VerifyPolicy{SampleRate: 1}))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	corrupted := checkedFileInfo("original")
+	corrupted.Content = []byte("flipped bits")
+	entries := map[string]cacheproxy.FileInformation{"good": checkedFileInfo("page"), "corrupted": corrupted}
+	for key, info := range entries {
+		if err = cache.Set(key, info); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	if _, err = cache.Get("good"); err != nil {
+		t.Errorf("Expected the intact entry to be served, got %v", err)
+	}
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	_, err = cache.Get("corrupted")
+	slog.SetDefault(previous)
+	if !errors.Is(err, badger.ErrKeyNotFound) || !errors.Is(err, cacheproxy.ErrChecksumMismatch) {
+		t.Errorf("Expected the corrupted entry to be a miss, got %v", err)
+	}
+	if strings.Contains(logs.String(), "Successfully loaded") {
+		t.Errorf("Expected the corrupted entry to not be logged as loaded, got:\n%s", logs.String())
+	}
+
+	quarantined, _ := cache.Quarantined()
+	keys, _ := cache.Keys()
+	if !slices.Equal(quarantined, []string{"corrupted"}) || !slices.Equal(keys, []string{"good"}) {
+		t.Errorf("Expected only the corrupted entry to be quarantined, got %v and kept %v", quarantined, keys)
+	}
+	if stats := cache.Stats(); stats.CorruptEntries != 1 {
+		t.Errorf("Expected a corrupted entry in the stats, got %d", stats.CorruptEntries)
+	}
+}
+
+func TestRemoteFileCache_Scrub(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	for _, key := range []string{"site/1", "site/2", "site/3"} {
+		info := checkedFileInfo(key)
+		if key == "site/2" {
+			info.Checksum = []byte("stale checksum")
+		}
+		if err = cache.Set(key, info); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		expected ScrubReport
+	}{
+		{name: "first pass", expected: ScrubReport{Checked: 3, Quarantined: 1}},
+		{name: "second pass", expected: ScrubReport{Checked: 2}},
+	}
+	for _, tCase := range testCases {
+		report, scrubErr := cache.Scrub(context.Background(), 1000)
+		if scrubErr != nil || report != tCase.expected {
+			t.Errorf("Expected %+v on the %s, got %+v (%v)", tCase.expected, tCase.name, report, scrubErr)
+		}
+	}
+	if _, err = cache.Get("site/2"); !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("Expected the corrupted entry to be removed, got %v", err)
+	}
+	if stats := cache.Stats(); stats.ScrubPasses != 2 || stats.CorruptEntries != 1 {
+		t.Errorf("Expected 2 passes and a corrupted entry, got %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = cache.Scrub(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled scrub to stop, got %v", err)
+	}
+}
+
+func TestRemoteFileCache_ScrubHistory(t *testing.T) {
+	cache, err := NewRemoteFileCache(
+		createTempDir(t), WithHistory(cacheproxy.HistoryPolicy{MaxVersions: 5}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	const key = "site/page"
+	corrupted := checkedFileInfo("first")
+	corrupted.Content = []byte("flipped bits")
+	for _, info := range []cacheproxy.FileInformation{corrupted, checkedFileInfo("second")} {
+		if err = cache.Set(key, info); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	// A rate beyond the ticker resolution is not paced
+	report, err := cache.Scrub(context.Background(), 2_000_000_000)
+	if err != nil || report != (ScrubReport{Checked: 3, Quarantined: 1}) {
+		t.Errorf("Expected the corrupted version to be quarantined, got %+v (%v)", report, err)
+	}
+	versions, _ := cache.Versions(key)
+	if len(versions) != 1 || !bytes.Equal(versions[0].Checksum, checkedFileInfo("second").Checksum) {
+		t.Errorf("Expected only the intact version to be kept, got %+v", versions)
+	}
+	if _, err = cache.Get(key); err != nil {
+		t.Errorf("Expected the current entry to be kept, got %v", err)
+	}
+}
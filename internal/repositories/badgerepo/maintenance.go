@@ -0,0 +1,143 @@
+package badgerepo
+
+import (
+	"errors"
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+// maxPendingImportWrites bounds the memory used while importing a backup
+const maxPendingImportWrites = 256
+
+// Delete removes the key, along with every revision kept for it.
+func (r *RemoteFileCache) Delete(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	versionKeys, err := r.keysWithPrefix(historyKeyPrefix(key))
+	if err != nil {
+		return err
+	}
+	return r.deleteKeys(append(versionKeys, []byte(key)))
+}
+
+// DeletePrefix removes every key starting with prefix, besides their revisions,
+// returning how many keys were removed. An empty prefix purges the whole cache.
+func (r *RemoteFileCache) DeletePrefix(prefix string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys, err := r.keysWithPrefix([]byte(prefix))
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		removeKeys = make([][]byte, 0, len(keys))
+		removed    int
+	)
+	for _, key := range keys {
+		if isInternalKey(string(key)) {
+			continue
+		}
+		versionKeys, listErr := r.keysWithPrefix(historyKeyPrefix(string(key)))
+		if listErr != nil {
+			return 0, listErr
+		}
+		removeKeys = append(append(removeKeys, key), versionKeys...)
+		removed++
+	}
+	if err = r.deleteKeys(removeKeys); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (r *RemoteFileCache) keysWithPrefix(prefix []byte) (keys [][]byte, err error) {
+	err = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	return
+}
+
+func (r *RemoteFileCache) deleteKeys(keys [][]byte) error {
+	batch := r.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// Walk calls visit for every cached key starting with prefix, in key order.
+// Entries that cannot be decoded are visited with the decoding error, so they can be reported.
+// Returning an error from visit stops the walk.
+func (r *RemoteFileCache) Walk(
+	prefix string,
+	visit func(key string, information cacheproxy.FileInformation, decodeErr error) error,
+) error {
+	return r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+			if isInternalKey(key) {
+				continue
+			}
+
+			valBytes, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			information, decodeErr := DecodeFileInfo(valBytes)
+			if err = visit(key, information, decodeErr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CollectGarbage runs value log GC passes until there is nothing left to rewrite,
+// returning how many passes rewrote a file.
+func (r *RemoteFileCache) CollectGarbage(discardRatio float64) (int, error) {
+	var passes int
+	for {
+		err := r.db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			r.gcRuns.Add(1)
+			return passes, nil
+		}
+		if err != nil {
+			return passes, err
+		}
+		passes++
+	}
+}
+
+// Export writes a backup of the whole database, revisions included, to writer.
+func (r *RemoteFileCache) Export(writer io.Writer) error {
+	_, err := r.db.Backup(writer, 0)
+	return err
+}
+
+// Import loads a backup written by Export, replacing the keys it contains.
+func (r *RemoteFileCache) Import(reader io.Reader) error {
+	return r.db.Load(reader, maxPendingImportWrites)
+}
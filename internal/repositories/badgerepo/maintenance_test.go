@@ -0,0 +1,55 @@
+package badgerepo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+)
+
+func TestRemoteFileCache_DeleteAndWalk(t *testing.T) {
+	cache, err := NewRemoteFileCache(createTempDir(t), WithHistory(cacheproxy.HistoryPolicy{MaxVersions: 3}))
+	if err != nil {
+		t.Fatalf("Failed to create RemoteFileCache: %v", err)
+	}
+	defer cache.Close()
+
+	keys := []string{"site-a/1", "site-a/2", "site-b/1"}
+	for _, key := range keys {
+		if err = cache.Set(key, cacheproxy.FileInformation{Content: []byte(key), Checksum: []byte(key)}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	var walked []string
+	err = cache.Walk("site-a/", func(key string, info cacheproxy.FileInformation, decodeErr error) error {
+		if decodeErr != nil || string(info.Content) != key {
+			t.Errorf("Expected %s to decode with its content, got `%s` (%v)", key, info.Content, decodeErr)
+		}
+		walked = append(walked, key)
+		return nil
+	})
+	if err != nil || len(walked) != 2 {
+		t.Errorf("Expected to walk the 2 site-a keys, got %v (%v)", walked, err)
+	}
+
+	if err = cache.Delete("site-b/1"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err = cache.Get("site-b/1"); !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("Expected the key to be deleted, got %v", err)
+	}
+	if versions, _ := cache.Versions("site-b/1"); len(versions) != 0 {
+		t.Errorf("Expected the revisions to be deleted too, got %d", len(versions))
+	}
+
+	removed, err := cache.DeletePrefix("site-a/")
+	if err != nil || removed != 2 {
+		t.Errorf("Expected 2 keys removed, got %d (%v)", removed, err)
+	}
+	if remaining, _ := cache.Keys(); len(remaining) != 0 {
+		t.Errorf("Expected an empty cache, got %v", remaining)
+	}
+}
@@ -2,17 +2,28 @@ package dsrest
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
 )
 
+const tracerName = "github.com/jictyvoo/radadar_crawlsdk/internal/repositories/datasources/dsrest"
+
 type HTTPDatasource struct {
 	client *http.Client
 }
 
+// NewHTTPDatasource sends the requests through the given transport, like a
+// cacheproxy.Transport to read them from the cache in process.
 func NewHTTPDatasource(roundTripper http.RoundTripper) *HTTPDatasource {
 	if roundTripper == nil {
 		roundTripper = httptransport.DefaultTransport
@@ -24,26 +35,44 @@ func NewHTTPDatasource(roundTripper http.RoundTripper) *HTTPDatasource {
 	return &HTTPDatasource{client: client}
 }
 
+// attemptRequest sends the request within the context, so it is canceled along with it
+// and traced as its child. The span reaches the cache proxy through the transport.
 func (d HTTPDatasource) attemptRequest(
-	method HTTPMethod, url string, headers http.Header, data []byte,
+	ctx context.Context, method HTTPMethod, url string, headers http.Header, data []byte,
 ) (HTTPResponse, error) {
+	ctx, span := otel.Tracer(tracerName).Start(
+		ctx, "HTTPDatasource.attemptRequest",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", string(method)),
+			attribute.String("url.full", url),
+		),
+	)
+	defer span.End()
+
 	var bodyBuffer io.Reader
 	if len(data) > 0 {
 		bodyBuffer = bytes.NewReader(data)
 	}
 
 	// Creating request
-	req, err := http.NewRequest(string(method), url, bodyBuffer)
-	req.Header = headers
-
+	req, err := http.NewRequestWithContext(ctx, string(method), url, bodyBuffer)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return HTTPResponse{}, err
 	}
+	if headers != nil {
+		// The caller may share its headers across requests, so the transports change a copy
+		req.Header = headers.Clone()
+	}
 
 	var resp *http.Response
 	if resp, err = d.client.Do(req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return HTTPResponse{}, err
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	respStruct := HTTPResponse{
 		StatusCode: resp.StatusCode,
@@ -54,19 +83,46 @@ func (d HTTPDatasource) attemptRequest(
 	return respStruct, nil
 }
 
+// DownloadPage fetches the page body with a GET request sent within the context,
+// failing on error statuses.
+func (d HTTPDatasource) DownloadPage(ctx context.Context, url string) (string, error) {
+	resp, err := d.attemptRequest(ctx, MethodGet, url, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := resp.Body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var body []byte
+	if body, err = io.ReadAll(resp.Body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return string(body), nil
+}
+
+// Close releases the idle connections kept by the datasource client.
+func (d HTTPDatasource) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
 // Get performs an HTTP GET request to the specified URL.
 func (d HTTPDatasource) Get(url string, headers map[string][]string) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, nil)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, nil)
 }
 
 // Head performs an HTTP HEAD request to the specified URL.
 func (d HTTPDatasource) Head(url string, headers map[string][]string) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, nil)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, nil)
 }
 
 // Delete performs an HTTP DELETE request to the specified URL.
 func (d HTTPDatasource) Delete(url string, headers map[string][]string) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, nil)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, nil)
 }
 
 // Post performs an HTTP POST request to the specified URL with the given body.
@@ -75,7 +131,7 @@ func (d HTTPDatasource) Post(
 	headers map[string][]string,
 	data []byte,
 ) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, data)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, data)
 }
 
 // Put performs an HTTP PUT request to the specified URL with the given body.
@@ -84,7 +140,7 @@ func (d HTTPDatasource) Put(
 	headers map[string][]string,
 	data []byte,
 ) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, data)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, data)
 }
 
 // Patch performs an HTTP PATCH request to the specified URL with the given body.
@@ -93,5 +149,5 @@ func (d HTTPDatasource) Patch(
 	headers map[string][]string,
 	data []byte,
 ) (HTTPResponse, error) {
-	return d.attemptRequest(MethodGet, url, headers, data)
+	return d.attemptRequest(context.Background(), MethodGet, url, headers, data)
 }
@@ -116,3 +116,22 @@ func TestHTTPDatasource(t *testing.T) {
 		)
 	}
 }
+
+func TestHTTPDatasource_SharedHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	headers := http.Header{"Accept": {"text/html"}}
+	if _, err := NewHTTPDatasource(nil).Get(server.URL, headers); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if received.Get("Accept") != "text/html" {
+		t.Errorf("expected the Accept header to be sent, got %v", received)
+	}
+	if len(headers) != 1 {
+		t.Errorf("expected the caller headers to be left untouched, got %v", headers)
+	}
+}
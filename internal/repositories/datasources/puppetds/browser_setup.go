@@ -38,6 +38,7 @@ func newBrowser(roundTripper http.RoundTripper) (rodBrowser, error) {
 		// SlowMotion(2 * time.Second).
 		MustConnect()
 
+	// Every request of the browser goes through the round tripper, so a cache transport serves the pages and assets
 	rb.router = rb.browser.HijackRequests()
 	err = rb.router.Add("*", "", func(ctx *rod.Hijack) {
 		client := &http.Client{Transport: roundTripper, Timeout: time.Second << 8}
@@ -1,6 +1,7 @@
 package puppetds
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -10,6 +11,8 @@ type BrowserPuppetDatasource struct {
 	browser      rodBrowser
 }
 
+// NewBrowserDatasource launches a browser whose requests are hijacked and sent
+// through the given transport, like a cacheproxy.Transport to read them from the cache.
 func NewBrowserDatasource(roundTripper http.RoundTripper) (*BrowserPuppetDatasource, error) {
 	browser, err := newBrowser(roundTripper)
 	if err != nil {
@@ -18,12 +21,13 @@ func NewBrowserDatasource(roundTripper http.RoundTripper) (*BrowserPuppetDatasou
 	return &BrowserPuppetDatasource{roundTripper: roundTripper, browser: browser}, nil
 }
 
-func (wpr BrowserPuppetDatasource) DownloadPage(baseURL string) (string, error) {
-	// Create a new page
+func (wpr BrowserPuppetDatasource) DownloadPage(ctx context.Context, baseURL string) (string, error) {
+	// Create a new page, whose actions are canceled along with the context
 	page, err := wpr.browser.Page(baseURL)
 	if err != nil {
 		return "", err
 	}
+	page = page.Context(ctx)
 	if err = page.WaitStable(3 * time.Second); err != nil {
 		return "", err
 	}
@@ -0,0 +1,166 @@
+package cacheproxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type (
+	// AccessPolicy restricts who may use the proxy listener. When AllowedNetworks is given,
+	// clients must connect from one of them, and when any credential is configured,
+	// requests must carry one of them in the Authorization header.
+	AccessPolicy struct {
+		// AllowedNetworks are CIDR ranges, like 10.0.0.0/8, or single addresses
+		AllowedNetworks []string
+		// BasicUsers maps the accepted Basic auth usernames to their password
+		BasicUsers   map[string]string
+		BearerTokens []string
+	}
+	accessControl struct {
+		policy   AccessPolicy
+		networks []netip.Prefix
+	}
+)
+
+// WithBindAddress listens only on the given host or IP, instead of every interface.
+func WithBindAddress(host string) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.bindHost = host
+	}
+}
+
+// WithAccessPolicy requires the clients of the listener to be allowed by the policy.
+// The credentials are removed from the requests, so they never reach upstream.
+// Requests served in process, like the ones from Warm, are not checked.
+func WithAccessPolicy(policy AccessPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.accessPolicy = &policy
+	}
+}
+
+// ListenAddress is the host and port the proxy listener binds to.
+func (proxy *CacheableProxy) ListenAddress() string {
+	return net.JoinHostPort(proxy.bindHost, fmt.Sprint(proxy.port))
+}
+
+// redirectHost is the host clients in this process use to reach the listener.
+func (proxy *CacheableProxy) redirectHost() string {
+	if address, err := netip.ParseAddr(proxy.bindHost); proxy.bindHost == "" || (err == nil && address.IsUnspecified()) {
+		return "localhost"
+	}
+	return proxy.bindHost
+}
+
+// redirectAddress is the host and port clients in this process dial, bracketing IPv6 hosts.
+func (proxy *CacheableProxy) redirectAddress() string {
+	return net.JoinHostPort(proxy.redirectHost(), fmt.Sprint(proxy.port))
+}
+
+func (proxy *CacheableProxy) prepareAccess() error {
+	if proxy.accessPolicy == nil {
+		return nil
+	}
+	access := &accessControl{policy: *proxy.accessPolicy}
+	for _, network := range access.policy.AllowedNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			address, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return fmt.Errorf("invalid allowed network %q: %w", network, err)
+			}
+			prefix = netip.PrefixFrom(address, address.BitLen())
+		}
+		access.networks = append(access.networks, prefix.Masked())
+	}
+	proxy.access = access
+	return nil
+}
+
+// guard rejects the requests not allowed by the access policy.
+func (access *accessControl) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !access.allowsAddress(r.RemoteAddr) {
+			http.Error(w, "client address not allowed", http.StatusForbidden)
+			return
+		}
+		if !access.hasCredentials() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !access.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cacheproxy"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del("Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (access *accessControl) allowsAddress(remoteAddr string) bool {
+	if len(access.networks) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	address := addrPort.Addr().Unmap()
+	for _, network := range access.networks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (access *accessControl) hasCredentials() bool {
+	return len(access.policy.BasicUsers) > 0 || len(access.policy.BearerTokens) > 0
+}
+
+func (access *accessControl) authorized(authorization string) bool {
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return matchesToken(access.policy.BearerTokens, strings.TrimSpace(credentials))
+	case strings.EqualFold(scheme, "Basic"):
+		request := http.Request{Header: http.Header{"Authorization": {authorization}}}
+		username, password, ok := request.BasicAuth()
+		expected, known := access.policy.BasicUsers[username]
+		return ok && known && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	}
+	return false
+}
+
+// matchesToken compares the token with every accepted one, in constant time.
+func matchesToken(accepted []string, token string) bool {
+	matched := 0
+	for _, candidate := range accepted {
+		matched |= subtle.ConstantTimeCompare([]byte(token), []byte(candidate))
+	}
+	return token != "" && matched == 1
+}
+
+// clientAuthorization is the Authorization header RedirectRoundTripper sends to the listener.
+func (proxy *CacheableProxy) clientAuthorization() string {
+	if proxy.access == nil {
+		return ""
+	}
+	if tokens := proxy.access.policy.BearerTokens; len(tokens) > 0 {
+		return "Bearer " + tokens[0]
+	}
+	users := proxy.access.policy.BasicUsers
+	if len(users) == 0 {
+		return ""
+	}
+	username := slices.Min(slices.Collect(maps.Keys(users)))
+	request := http.Request{Header: make(http.Header)}
+	request.SetBasicAuth(username, users[username])
+	return request.Header.Get("Authorization")
+}
@@ -0,0 +1,147 @@
+package cacheproxy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats accepted by WithAccessLog
+const (
+	AccessLogJSON     AccessLogFormat = "json"
+	AccessLogCommon   AccessLogFormat = "common"
+	AccessLogCombined AccessLogFormat = "combined"
+)
+
+// commonLogTime is the timestamp layout used by the Common Log Format
+const commonLogTime = "02/Jan/2006:15:04:05 -0700"
+
+type (
+	AccessLogFormat string
+	// AccessLogEntry describes a single request answered by the proxy.
+	AccessLogEntry struct {
+		Time             time.Time   `json:"time"`
+		RemoteAddr       string      `json:"remote_addr"`
+		Method           string      `json:"method"`
+		URI              string      `json:"uri"`
+		Proto            string      `json:"proto"`
+		Status           int         `json:"status"`
+		Bytes            int64       `json:"bytes"`
+		Duration         float64     `json:"duration_seconds"`
+		UpstreamDuration float64     `json:"upstream_seconds"`
+		CacheStatus      CacheStatus `json:"cache_status"`
+		Key              string      `json:"key"`
+		Referer          string      `json:"referer,omitempty"`
+		UserAgent        string      `json:"user_agent,omitempty"`
+	}
+	accessLogger struct {
+		format AccessLogFormat
+		mutex  sync.Mutex
+		output io.Writer
+	}
+)
+
+// WithAccessLog writes a line to output for every request handled by the proxy.
+// The Common and Combined formats are followed by the cache status, the upstream
+// time in seconds and the quoted cache key.
+func WithAccessLog(output io.Writer, format AccessLogFormat) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		if output == nil {
+			proxy.accessLog = nil
+			return
+		}
+		proxy.accessLog = &accessLogger{format: format, output: output}
+	}
+}
+
+func newAccessLogEntry(
+	r *http.Request, tw *trackedWriter, state *requestState, start time.Time,
+) AccessLogEntry {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	remoteAddr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	return AccessLogEntry{
+		Time:             start,
+		RemoteAddr:       remoteAddr,
+		Method:           r.Method,
+		URI:              uri,
+		Proto:            r.Proto,
+		Status:           tw.status,
+		Bytes:            tw.bytes,
+		Duration:         time.Since(start).Seconds(),
+		UpstreamDuration: state.upstreamDuration.Seconds(),
+		CacheStatus:      state.status,
+		Key:              state.key,
+		Referer:          r.Referer(),
+		UserAgent:        r.UserAgent(),
+	}
+}
+
+func (logger *accessLogger) log(entry AccessLogEntry) {
+	var line []byte
+	switch logger.format {
+	case AccessLogCommon, AccessLogCombined:
+		line = logger.appendCommon(nil, entry)
+	default:
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			slog.Error("[ PROXY SERVER ] Error encoding access log", slog.String("error", err.Error()))
+			return
+		}
+		line = encoded
+	}
+	line = append(line, '\n')
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if _, err := logger.output.Write(line); err != nil {
+		slog.Error("[ PROXY SERVER ] Error writing access log", slog.String("error", err.Error()))
+	}
+}
+
+func (logger *accessLogger) appendCommon(line []byte, entry AccessLogEntry) []byte {
+	line = append(line, orDash(entry.RemoteAddr)...)
+	line = append(line, " - - ["...)
+	line = entry.Time.AppendFormat(line, commonLogTime)
+	line = append(line, "] "...)
+	line = strconv.AppendQuote(line, entry.Method+" "+entry.URI+" "+entry.Proto)
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, int64(entry.Status), 10)
+	line = append(line, ' ')
+	if entry.Bytes > 0 {
+		line = strconv.AppendInt(line, entry.Bytes, 10)
+	} else {
+		line = append(line, '-')
+	}
+
+	if logger.format == AccessLogCombined {
+		line = append(line, ' ')
+		line = strconv.AppendQuote(line, orDash(entry.Referer))
+		line = append(line, ' ')
+		line = strconv.AppendQuote(line, orDash(entry.UserAgent))
+	}
+
+	line = append(line, ' ')
+	line = append(line, entry.CacheStatus...)
+	line = append(line, ' ')
+	line = strconv.AppendFloat(line, entry.UpstreamDuration, 'f', 3, 64)
+	line = append(line, ' ')
+	return strconv.AppendQuote(line, orDash(entry.Key))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCacheableProxy_AccessLogFormats(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html>logged</html>"))
+	}
+
+	tests := []struct {
+		name     string
+		format   AccessLogFormat
+		expected []string
+	}{
+		{
+			name:     "Common",
+			format:   AccessLogCommon,
+			expected: []string{`192.0.2.1 - - [`, `"GET /page HTTP/1.1" 200 19 MISS `, `/page"`},
+		},
+		{
+			name:     "Combined",
+			format:   AccessLogCombined,
+			expected: []string{`"GET /page HTTP/1.1" 200 19 "https://ref.example" "test-agent" MISS `},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			proxy, _ := newTestProxy(t, upstream, WithAccessLog(&output, tt.format))
+
+			req := httptest.NewRequest(http.MethodGet, "/page", nil)
+			req.Header.Set("Referer", "https://ref.example")
+			req.Header.Set("User-Agent", "test-agent")
+			serveProxy(proxy, req)
+
+			line := output.String()
+			for _, fragment := range tt.expected {
+				if !strings.Contains(line, fragment) {
+					t.Errorf("Expected log line to contain `%s`, got `%s`", fragment, line)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_AccessLogJSON(t *testing.T) {
+	var output bytes.Buffer
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html>cached</html>"))
+	}, WithAccessLog(&output, AccessLogJSON))
+
+	expectedStatuses := []CacheStatus{CacheMiss, CacheHit}
+	for _, expected := range expectedStatuses {
+		recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+		if header := recorder.Header().Get(HeaderCache); header != string(expected) {
+			t.Errorf("Expected %s header `%s`, got `%s`", HeaderCache, expected, header)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != len(expectedStatuses) {
+		t.Fatalf("Expected %d log lines, got %d", len(expectedStatuses), len(lines))
+	}
+	for index, line := range lines {
+		var entry AccessLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line `%s`: %v", line, err)
+		}
+		if entry.CacheStatus != expectedStatuses[index] {
+			t.Errorf("Expected cache status %s, got %s", expectedStatuses[index], entry.CacheStatus)
+		}
+		if entry.Status != http.StatusOK || entry.Bytes != 19 || entry.Key == "" {
+			t.Errorf("Expected status 200, 19 bytes and a key, got %+v", entry)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package cacheproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessControl_Guard(t *testing.T) {
+	policy := AccessPolicy{
+		AllowedNetworks: []string{"10.0.0.0/8", "192.168.1.7"},
+		BasicUsers:      map[string]string{"crawler": "secret"},
+		BearerTokens:    []string{"token-1"},
+	}
+	proxy, err := New(newMemoryStorage(), "http://example.com", 0, WithAccessPolicy(policy))
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		authorization string
+		expected      int
+	}{
+		{name: "Bearer token", remoteAddr: "10.1.2.3:4000", authorization: "Bearer token-1", expected: http.StatusOK},
+		{name: "Basic auth", remoteAddr: "192.168.1.7:4000", authorization: basicAuth("crawler", "secret"), expected: http.StatusOK},
+		{name: "Wrong password", remoteAddr: "10.1.2.3:4000", authorization: basicAuth("crawler", "guess"), expected: http.StatusUnauthorized},
+		{name: "Wrong token", remoteAddr: "10.1.2.3:4000", authorization: "Bearer token-2", expected: http.StatusUnauthorized},
+		{name: "No credentials", remoteAddr: "10.1.2.3:4000", expected: http.StatusUnauthorized},
+		{name: "Outside networks", remoteAddr: "192.168.1.8:4000", authorization: "Bearer token-1", expected: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := proxy.access.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "" {
+					t.Errorf("Expected the credentials to be removed before forwarding")
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/page", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+
+	if _, err = New(newMemoryStorage(), "http://example.com", 0, WithAccessPolicy(AccessPolicy{
+		AllowedNetworks: []string{"10.0.0.0/33"},
+	})); err == nil {
+		t.Errorf("Expected an invalid network to be rejected")
+	}
+}
+
+func basicAuth(username, password string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(username, password)
+	return req.Header.Get("Authorization")
+}
+
+func TestCacheableProxy_AuthenticatedListener(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected upstream to never see the proxy credentials")
+		}
+		_, _ = w.Write([]byte("<html>page</html>"))
+	}, WithBindAddress("127.0.0.1"), WithAccessPolicy(AccessPolicy{BearerTokens: []string{"token-1"}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startFeedback := make(chan string, 1)
+	go func() { _ = proxy.Listen(ctx, startFeedback) }()
+	address := <-startFeedback
+
+	resp, err := http.Get("http://" + address + "/page")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous requests to be rejected, got %d", resp.StatusCode)
+	}
+
+	client := &http.Client{Transport: proxy.RedirectRoundTripper(), Timeout: 5 * time.Second}
+	if resp, err = client.Get(proxy.targetURL.String() + "/page"); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "<html>page</html>" {
+		t.Errorf("Expected the redirected request to be authorized, got %d `%s`", resp.StatusCode, body)
+	}
+}
+
+func TestCacheableProxy_RedirectIPv6Bind(t *testing.T) {
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	} else {
+		_ = listener.Close()
+	}
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html>page</html>"))
+	}, WithBindAddress("::1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startFeedback := make(chan string, 1)
+	go func() { _ = proxy.Listen(ctx, startFeedback) }()
+	<-startFeedback
+
+	client := &http.Client{Transport: proxy.RedirectRoundTripper(), Timeout: 5 * time.Second}
+	resp, err := client.Get(proxy.targetURL.String() + "/page")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "<html>page</html>" {
+		t.Errorf("Expected the page through the IPv6 listener, got `%s`", body)
+	}
+}
+
+func TestCacheableProxy_MetricsToken(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html>page</html>"))
+	}, WithAccessPolicy(AccessPolicy{BearerTokens: []string{"client-token"}}),
+		WithMetricsPath("/metrics"), WithMetricsToken("metrics-token"))
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		expected      int
+	}{
+		{name: "Metrics with the token", path: "/metrics", authorization: "Bearer metrics-token", expected: http.StatusOK},
+		{name: "Metrics with client credentials", path: "/metrics", authorization: "Bearer client-token", expected: http.StatusUnauthorized},
+		{name: "Anonymous metrics", path: "/metrics", expected: http.StatusUnauthorized},
+		{name: "Proxied page", path: "/page", authorization: "Bearer client-token", expected: http.StatusOK},
+	}
+	handler := proxy.serveMux()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+}
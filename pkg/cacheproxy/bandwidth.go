@@ -0,0 +1,355 @@
+package cacheproxy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBandwidthWindow = 24 * time.Hour
+	// bandwidthBuckets splits each window, so old bytes leave the rolling window a bucket at a time
+	bandwidthBuckets = 24
+	// BandwidthKeyPrefix is where the storage keeps the counters of each host across restarts
+	BandwidthKeyPrefix = "bandwidth://"
+)
+
+type (
+	// BandwidthPolicy limits how many bytes upstream may send in a rolling accounting window.
+	// Once the budget is spent, the host is served only from the cache, like WithOfflineMode,
+	// until enough bytes leave the window.
+	BandwidthPolicy struct {
+		// DailyBudget is the amount of upstream response bytes allowed per window, unlimited when zero
+		DailyBudget int64
+		// Window is the rolling accounting period, the last 24 hours when zero
+		Window time.Duration
+	}
+	// BandwidthUsage counts the response bytes of an accounting window.
+	BandwidthUsage struct {
+		WindowStart   time.Time
+		WindowEnd     time.Time
+		UpstreamBytes int64
+		CacheBytes    int64
+	}
+	// BandwidthReport describes the bytes fetched from an upstream host versus the ones served from cache.
+	BandwidthReport struct {
+		Host      string
+		Budget    int64
+		CacheOnly bool
+		Current   BandwidthUsage
+		Previous  BandwidthUsage
+		// TotalUpstreamBytes and TotalCacheBytes count every window the storage remembers
+		TotalUpstreamBytes int64
+		TotalCacheBytes    int64
+	}
+	bandwidthMeter struct {
+		mutex  sync.Mutex
+		host   string
+		policy BandwidthPolicy
+		// buckets are sorted by start, covering up to the current and the previous window
+		buckets   []bandwidthBucket
+		cacheOnly bool
+		totals    BandwidthUsage
+		// save persists the counters, once a bucket is complete or the budget is spent
+		save func()
+	}
+	bandwidthBucket struct {
+		Start         time.Time `json:"start"`
+		UpstreamBytes int64     `json:"upstream_bytes"`
+		CacheBytes    int64     `json:"cache_bytes"`
+	}
+	// bandwidthSnapshot is the stored form of the counters.
+	bandwidthSnapshot struct {
+		Buckets            []bandwidthBucket `json:"buckets"`
+		TotalUpstreamBytes int64             `json:"total_upstream_bytes"`
+		TotalCacheBytes    int64             `json:"total_cache_bytes"`
+	}
+	// countingTransport accounts the bodies of every upstream response, including the ones
+	// of robots.txt, sitemaps and retried attempts, which never reach the reverse proxy.
+	countingTransport struct {
+		base  http.RoundTripper
+		meter *bandwidthMeter
+	}
+	// countingBody reports the bytes read from an upstream response body.
+	countingBody struct {
+		io.ReadCloser
+		count func(bytes int64)
+	}
+	// countingConn reports the bytes read from an upgraded upstream connection,
+	// keeping the Write the reverse proxy needs to relay the client side.
+	countingConn struct {
+		io.ReadWriteCloser
+		count func(bytes int64)
+	}
+)
+
+// WithBandwidthBudget switches the upstream host to cache-only mode while its budget is spent.
+// Bytes are accounted on every proxy, the budget only changes how requests are served.
+func WithBandwidthBudget(policy BandwidthPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.bandwidth.policy = policy
+	}
+}
+
+func newBandwidthMeter(host string) *bandwidthMeter {
+	return &bandwidthMeter{host: host}
+}
+
+func (meter *bandwidthMeter) window() time.Duration {
+	if meter.policy.Window <= 0 {
+		return defaultBandwidthWindow
+	}
+	return meter.policy.Window
+}
+
+func (meter *bandwidthMeter) bucketWidth() time.Duration {
+	return max(meter.window()/bandwidthBuckets, time.Nanosecond)
+}
+
+// bucket returns the one counting the bytes at now, dropping the buckets older than the
+// previous window. It reports whether the newest bucket was completed. Callers must hold the mutex.
+func (meter *bandwidthMeter) bucket(now time.Time) (*bandwidthBucket, bool) {
+	start := now.Truncate(meter.bucketWidth())
+	// A clock going backwards keeps counting on the newest bucket
+	if last := len(meter.buckets) - 1; last >= 0 && !meter.buckets[last].Start.Before(start) {
+		return &meter.buckets[last], false
+	}
+
+	completed := len(meter.buckets) > 0
+	horizon := start.Add(meter.bucketWidth() - 2*meter.window())
+	kept := 0
+	for kept < len(meter.buckets) && meter.buckets[kept].Start.Before(horizon) {
+		kept++
+	}
+	meter.buckets = append(meter.buckets[kept:], bandwidthBucket{Start: start})
+	return &meter.buckets[len(meter.buckets)-1], completed
+}
+
+// usage sums the buckets starting within [start, end). Callers must hold the mutex.
+func (meter *bandwidthMeter) usage(start, end time.Time) BandwidthUsage {
+	usage := BandwidthUsage{WindowStart: start, WindowEnd: end}
+	for _, bucket := range meter.buckets {
+		if !bucket.Start.Before(start) && bucket.Start.Before(end) {
+			usage.UpstreamBytes += bucket.UpstreamBytes
+			usage.CacheBytes += bucket.CacheBytes
+		}
+	}
+	return usage
+}
+
+// windows returns the rolling window ending with the bucket of now, and the one before it.
+// Callers must hold the mutex.
+func (meter *bandwidthMeter) windows(now time.Time) (current, previous BandwidthUsage) {
+	end := now.Truncate(meter.bucketWidth()).Add(meter.bucketWidth())
+	start := end.Add(-meter.window())
+	return meter.usage(start, end), meter.usage(start.Add(-meter.window()), start)
+}
+
+// refresh switches the host to cache-only while the rolling window holds the whole budget,
+// returning whether it was just spent. Callers must hold the mutex.
+func (meter *bandwidthMeter) refresh(now time.Time) bool {
+	current, _ := meter.windows(now)
+	budget := meter.policy.DailyBudget
+	spent := budget > 0 && current.UpstreamBytes >= budget
+	switch {
+	case spent && !meter.cacheOnly:
+		meter.cacheOnly = true
+		slog.Warn(
+			"[ PROXY SERVER ] Bandwidth budget exceeded, serving only from cache",
+			slog.String("host", meter.host),
+			slog.Int64("budget", budget),
+			slog.Time("until", meter.resetAt(current)),
+		)
+		return true
+	case !spent && meter.cacheOnly:
+		meter.cacheOnly = false
+		slog.Info("[ PROXY SERVER ] Bandwidth budget available, forwarding to upstream again", slog.String("host", meter.host))
+	}
+	return false
+}
+
+// resetAt is when enough buckets leave the rolling window for its usage to drop below the budget.
+// Callers must hold the mutex.
+func (meter *bandwidthMeter) resetAt(current BandwidthUsage) time.Time {
+	remaining := current.UpstreamBytes
+	for _, bucket := range meter.buckets {
+		if bucket.Start.Before(current.WindowStart) {
+			continue
+		}
+		if remaining -= bucket.UpstreamBytes; remaining < meter.policy.DailyBudget {
+			return bucket.Start.Add(meter.window())
+		}
+	}
+	return current.WindowEnd
+}
+
+func (meter *bandwidthMeter) addUpstream(bytes int64, now time.Time) {
+	meter.mutex.Lock()
+	bucket, completed := meter.bucket(now)
+	bucket.UpstreamBytes += bytes
+	meter.totals.UpstreamBytes += bytes
+	spent := meter.refresh(now)
+	meter.mutex.Unlock()
+	meter.persist(completed || spent)
+}
+
+func (meter *bandwidthMeter) addCache(bytes int64, now time.Time) {
+	meter.mutex.Lock()
+	bucket, completed := meter.bucket(now)
+	bucket.CacheBytes += bytes
+	meter.totals.CacheBytes += bytes
+	meter.mutex.Unlock()
+	meter.persist(completed)
+}
+
+func (meter *bandwidthMeter) persist(due bool) {
+	if due && meter.save != nil {
+		meter.save()
+	}
+}
+
+// exhausted reports whether the budget of the rolling window was spent.
+func (meter *bandwidthMeter) exhausted(now time.Time) bool {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	meter.refresh(now)
+	return meter.cacheOnly
+}
+
+func (meter *bandwidthMeter) report(now time.Time) BandwidthReport {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	meter.refresh(now)
+	current, previous := meter.windows(now)
+	return BandwidthReport{
+		Host:               meter.host,
+		Budget:             meter.policy.DailyBudget,
+		CacheOnly:          meter.cacheOnly,
+		Current:            current,
+		Previous:           previous,
+		TotalUpstreamBytes: meter.totals.UpstreamBytes,
+		TotalCacheBytes:    meter.totals.CacheBytes,
+	}
+}
+
+func (meter *bandwidthMeter) snapshot() bandwidthSnapshot {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	return bandwidthSnapshot{
+		Buckets:            slices.Clone(meter.buckets),
+		TotalUpstreamBytes: meter.totals.UpstreamBytes,
+		TotalCacheBytes:    meter.totals.CacheBytes,
+	}
+}
+
+// restore adds the stored counters to the ones counted since the proxy started.
+func (meter *bandwidthMeter) restore(snapshot bandwidthSnapshot, now time.Time) {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	counted := meter.buckets
+	meter.buckets = nil
+	for _, bucket := range append(snapshot.Buckets, counted...) {
+		current, _ := meter.bucket(bucket.Start)
+		current.UpstreamBytes += bucket.UpstreamBytes
+		current.CacheBytes += bucket.CacheBytes
+	}
+	meter.totals.UpstreamBytes += snapshot.TotalUpstreamBytes
+	meter.totals.CacheBytes += snapshot.TotalCacheBytes
+	meter.refresh(now)
+}
+
+// Bandwidth reports the bytes fetched from upstream and served from cache, by accounting window.
+func (proxy *CacheableProxy) Bandwidth() BandwidthReport {
+	return proxy.bandwidth.report(time.Now())
+}
+
+func bandwidthKey(host string) string {
+	return BandwidthKeyPrefix + host
+}
+
+// loadBandwidth restores the counters saved by a previous run, so a restart keeps a spent budget.
+func (proxy *CacheableProxy) loadBandwidth() {
+	info, err := proxy.storage.Get(bandwidthKey(proxy.bandwidth.host))
+	if err != nil {
+		return
+	}
+	var snapshot bandwidthSnapshot
+	if err = json.Unmarshal(info.Content, &snapshot); err != nil {
+		slog.Warn(
+			"[ PROXY SERVER ] Ignoring the stored bandwidth counters",
+			slog.String("host", proxy.bandwidth.host), slog.String("error", err.Error()),
+		)
+		return
+	}
+	proxy.bandwidth.restore(snapshot, time.Now())
+}
+
+// saveBandwidth stores the counters, serialized so an older snapshot never overwrites a newer one.
+func (proxy *CacheableProxy) saveBandwidth() error {
+	proxy.bandwidthSave.Lock()
+	defer proxy.bandwidthSave.Unlock()
+	content, err := json.Marshal(proxy.bandwidth.snapshot())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return proxy.store(bandwidthKey(proxy.bandwidth.host), FileInformation{
+		FileMIME:   FileMIME{Name: proxy.bandwidth.host, Extension: ".json", MimeType: "application/json"},
+		Envelope:   FileEnvelope{Status: http.StatusOK},
+		Content:    content,
+		Checksum:   checksum(content),
+		CreatedAt:  now,
+		ModifiedAt: now,
+	})
+}
+
+// saveBandwidthLater stores the counters without blocking the response being counted.
+func (proxy *CacheableProxy) saveBandwidthLater() {
+	finish := proxy.lifecycle.writes.begin()
+	go func() {
+		defer finish()
+		if err := proxy.saveBandwidth(); err != nil {
+			slog.Error(
+				"[ PROXY SERVER ] Failed to store the bandwidth counters",
+				slog.String("host", proxy.bandwidth.host), slog.String("error", err.Error()),
+			)
+		}
+	}()
+}
+
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := transport.base.RoundTrip(req)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, err
+	}
+	count := func(bytes int64) {
+		transport.meter.addUpstream(bytes, time.Now())
+	}
+	// The reverse proxy relays upgraded connections only when the body can be written
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &countingConn{ReadWriteCloser: conn, count: count}
+		return resp, nil
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, count: count}
+	return resp, nil
+}
+
+func (conn *countingConn) Read(content []byte) (int, error) {
+	read, err := conn.ReadWriteCloser.Read(content)
+	if read > 0 {
+		conn.count(int64(read))
+	}
+	return read, err
+}
+
+func (body *countingBody) Read(content []byte) (int, error) {
+	read, err := body.ReadCloser.Read(content)
+	if read > 0 {
+		body.count(int64(read))
+	}
+	return read, err
+}
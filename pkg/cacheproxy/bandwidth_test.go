@@ -0,0 +1,175 @@
+package cacheproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBandwidthMeter_Rotate(t *testing.T) {
+	meter := newBandwidthMeter("example.com")
+	meter.policy = BandwidthPolicy{DailyBudget: 100, Window: time.Hour}
+	start := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+
+	meter.addUpstream(60, start)
+	meter.addCache(30, start.Add(time.Minute))
+	if meter.exhausted(start) {
+		t.Errorf("Expected the budget to be available before it is spent")
+	}
+	meter.addUpstream(40, start.Add(2*time.Minute))
+	if !meter.exhausted(start.Add(2 * time.Minute)) {
+		t.Errorf("Expected the budget to be exhausted once spent")
+	}
+
+	testCases := []struct {
+		name             string
+		now              time.Time
+		expectedPrevious int64
+	}{
+		{name: "next window", now: start.Add(time.Hour), expectedPrevious: 100},
+		{name: "after an idle window", now: start.Add(3 * time.Hour), expectedPrevious: 0},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			report := meter.report(tCase.now)
+			if report.CacheOnly {
+				t.Errorf("Expected the budget to reset with the window")
+			}
+			if report.Previous.UpstreamBytes != tCase.expectedPrevious {
+				t.Errorf("Expected %d previous bytes, got %d", tCase.expectedPrevious, report.Previous.UpstreamBytes)
+			}
+			bucketWidth := time.Hour / bandwidthBuckets
+			if expected := tCase.now.Truncate(bucketWidth).Add(bucketWidth - time.Hour); !report.Current.WindowStart.Equal(expected) {
+				t.Errorf("Expected the window to start at %s, got %s", expected, report.Current.WindowStart)
+			}
+			if report.TotalUpstreamBytes != 100 || report.TotalCacheBytes != 30 {
+				t.Errorf(
+					"Expected totals 100 and 30, got %d and %d",
+					report.TotalUpstreamBytes, report.TotalCacheBytes,
+				)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_BandwidthBudget(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html>"+strings.Repeat("x", 64)+"</html>")
+	}, WithCacheTTL(time.Hour), WithBandwidthBudget(BandwidthPolicy{DailyBudget: 64}))
+
+	testCases := []struct {
+		path        string
+		expected    int
+		cacheStatus CacheStatus
+	}{
+		{path: "/first", expected: http.StatusOK, cacheStatus: CacheMiss},
+		{path: "/first", expected: http.StatusOK, cacheStatus: CacheHit},
+		{path: "/second", expected: http.StatusGatewayTimeout, cacheStatus: CacheMiss},
+	}
+	for _, tCase := range testCases {
+		recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, tCase.path, nil))
+		if recorder.Code != tCase.expected {
+			t.Errorf("Expected %d for %s, got %d", tCase.expected, tCase.path, recorder.Code)
+		}
+		if cacheStatus := recorder.Header().Get(HeaderCache); cacheStatus != string(tCase.cacheStatus) {
+			t.Errorf("Expected %s for %s, got %s", tCase.cacheStatus, tCase.path, cacheStatus)
+		}
+	}
+
+	report := proxy.Bandwidth()
+	if upstreamCalls.Load() != 1 || !report.CacheOnly {
+		t.Errorf("Expected a single upstream call before switching to cache-only, got %d", upstreamCalls.Load())
+	}
+	if report.Current.UpstreamBytes != 77 || report.Current.CacheBytes != 77 {
+		t.Errorf(
+			"Expected 77 bytes from upstream and cache, got %d and %d",
+			report.Current.UpstreamBytes, report.Current.CacheBytes,
+		)
+	}
+}
+
+func TestBandwidthMeter_RollingWindow(t *testing.T) {
+	meter := newBandwidthMeter("example.com")
+	meter.policy = BandwidthPolicy{DailyBudget: 100, Window: time.Hour}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	meter.addUpstream(60, start)
+	meter.addUpstream(40, start.Add(30*time.Minute))
+
+	testCases := []struct {
+		name              string
+		now               time.Time
+		expectedCurrent   int64
+		expectedCacheOnly bool
+	}{
+		{name: "both buckets in the window", now: start.Add(59 * time.Minute), expectedCurrent: 100, expectedCacheOnly: true},
+		{name: "first bucket left the window", now: start.Add(time.Hour), expectedCurrent: 40},
+		{name: "every bucket left the window", now: start.Add(90 * time.Minute)},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			report := meter.report(tCase.now)
+			if report.Current.UpstreamBytes != tCase.expectedCurrent {
+				t.Errorf("Expected %d bytes in the window, got %d", tCase.expectedCurrent, report.Current.UpstreamBytes)
+			}
+			if report.CacheOnly != tCase.expectedCacheOnly {
+				t.Errorf("Expected cache-only %t, got %t", tCase.expectedCacheOnly, report.CacheOnly)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_BandwidthSurvivesRestart(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "<html>"+strings.Repeat("x", 64)+"</html>")
+	}))
+	defer upstream.Close()
+	storage := newMemoryStorage()
+	policy := WithBandwidthBudget(BandwidthPolicy{DailyBudget: 64})
+
+	proxy, err := New(storage, upstream.URL, 0, policy)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/first", nil))
+	if err = proxy.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	if proxy, err = New(storage, upstream.URL, 0, policy); err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	report := proxy.Bandwidth()
+	if !report.CacheOnly || report.Current.UpstreamBytes != 77 || report.TotalUpstreamBytes != 77 {
+		t.Errorf("Expected the spent budget to be restored, got %+v", report)
+	}
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/second", nil)); recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected misses to fail after the restart, got %d", recorder.Code)
+	}
+}
+
+func TestCacheableProxy_BandwidthCountsRetries(t *testing.T) {
+	var attempts atomic.Int32
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, strings.Repeat("e", 32))
+			return
+		}
+		_, _ = io.WriteString(w, strings.Repeat("x", 16))
+	}, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the retried request to succeed, got %d", recorder.Code)
+	}
+	if report := proxy.Bandwidth(); report.Current.UpstreamBytes != 48 {
+		t.Errorf("Expected the discarded attempt to be counted, got %d bytes", report.Current.UpstreamBytes)
+	}
+}
@@ -0,0 +1,154 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+type (
+	// KeyBuilder computes the storage key used to cache a request sent to the target.
+	KeyBuilder interface {
+		CacheKey(req *http.Request, target *url.URL) string
+	}
+	KeyBuilderFunc func(req *http.Request, target *url.URL) string
+
+	// StandardKeyBuilder builds keys in the `file://METHOD@target#path+query` format.
+	// Its zero value keeps the query verbatim, and each field enables a normalization.
+	// URL fragments never take part in the key.
+	StandardKeyBuilder struct {
+		SortQuery         bool
+		DropParams        []string // Names to drop from the query, a trailing `*` matches a prefix
+		TrimTrailingSlash bool
+		LowercaseHost     bool // Hosts are case-insensitive, so `Example.com` and `example.com` share keys
+		IncludeHeaders    []string
+		IncludeBodyHash   bool
+	}
+)
+
+func (fn KeyBuilderFunc) CacheKey(req *http.Request, target *url.URL) string {
+	return fn(req, target)
+}
+
+func (builder StandardKeyBuilder) CacheKey(req *http.Request, target *url.URL) string {
+	rawQuery := builder.normalizeQuery(req.URL.RawQuery)
+	query, err := url.QueryUnescape(rawQuery)
+	if err != nil {
+		query = rawQuery
+	}
+
+	reqPath := req.URL.Path
+	if builder.TrimTrailingSlash && len(reqPath) > 1 {
+		// A path made only of slashes still names the root
+		if reqPath = strings.TrimRight(reqPath, "/"); reqPath == "" {
+			reqPath = "/"
+		}
+	}
+	if builder.LowercaseHost {
+		lowered := *target
+		lowered.Host = strings.ToLower(lowered.Host)
+		target = &lowered
+	}
+
+	cacheKey := fmt.Sprintf("file://%s@%s#%s", req.Method, target.String(), reqPath+query)
+	cacheKey += builder.headersSuffix(req.Header)
+	if builder.IncludeBodyHash {
+		if body, bodyErr := requestBody(req); bodyErr == nil && len(body) > 0 {
+			cacheKey += "|body=" + bodyHash(body)
+		}
+	}
+	return strings.TrimSpace(cacheKey)
+}
+
+func (builder StandardKeyBuilder) normalizeQuery(rawQuery string) string {
+	if rawQuery == "" || (!builder.SortQuery && len(builder.DropParams) == 0) {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	pairs = slices.DeleteFunc(pairs, func(pair string) bool {
+		return pair == "" || builder.isDropped(queryParamName(pair))
+	})
+	if builder.SortQuery {
+		slices.SortStableFunc(pairs, func(a, b string) int {
+			return strings.Compare(queryParamName(a), queryParamName(b))
+		})
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (builder StandardKeyBuilder) isDropped(paramName string) bool {
+	for _, dropName := range builder.DropParams {
+		if prefix, isPrefix := strings.CutSuffix(dropName, "*"); isPrefix {
+			if strings.HasPrefix(paramName, prefix) {
+				return true
+			}
+		} else if paramName == dropName {
+			return true
+		}
+	}
+	return false
+}
+
+func (builder StandardKeyBuilder) headersSuffix(header http.Header) string {
+	if len(builder.IncludeHeaders) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(builder.IncludeHeaders))
+	for _, name := range builder.IncludeHeaders {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	slices.Sort(names)
+
+	var suffix strings.Builder
+	for _, name := range slices.Compact(names) {
+		if values := header.Values(name); len(values) > 0 {
+			suffix.WriteString("|" + name + "=" + strings.Join(values, ","))
+		}
+	}
+	return suffix.String()
+}
+
+func queryParamName(pair string) string {
+	name, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// requestBody reads the whole request body, and restores it so it can be sent upstream.
+// GetBody keeps a copy, so the clone sent upstream still yields the body once its own was read.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody != nil {
+		bodyCopy, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer bodyCopy.Close()
+		return io.ReadAll(bodyCopy)
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, err
+}
+
+func bodyHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
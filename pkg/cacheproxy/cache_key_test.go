@@ -0,0 +1,135 @@
+package cacheproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStandardKeyBuilder(t *testing.T) {
+	baseURL, _ := url.Parse("http://Example.com")
+
+	tests := []struct {
+		name        string
+		builder     StandardKeyBuilder
+		method      string
+		target      string
+		headers     http.Header
+		body        string
+		expectedKey string
+	}{
+		{
+			name:        "Zero value keeps query verbatim",
+			method:      http.MethodGet,
+			target:      "/list?b=2&a=1",
+			expectedKey: "file://GET@http://Example.com#/listb=2&a=1",
+		},
+		{
+			name:        "Sort query params",
+			builder:     StandardKeyBuilder{SortQuery: true},
+			method:      http.MethodGet,
+			target:      "/list?b=2&a=1&a=0",
+			expectedKey: "file://GET@http://Example.com#/lista=1&a=0&b=2",
+		},
+		{
+			name:        "Drop tracking params",
+			builder:     StandardKeyBuilder{DropParams: []string{"utm_*", "fbclid"}},
+			method:      http.MethodGet,
+			target:      "/list?utm_source=x&page=2&fbclid=abc&utm_medium=y",
+			expectedKey: "file://GET@http://Example.com#/listpage=2",
+		},
+		{
+			name:        "Trim trailing slash",
+			builder:     StandardKeyBuilder{TrimTrailingSlash: true},
+			method:      http.MethodGet,
+			target:      "/docs/",
+			expectedKey: "file://GET@http://Example.com#/docs",
+		},
+		{
+			name:        "Trailing slash kept on root",
+			builder:     StandardKeyBuilder{TrimTrailingSlash: true},
+			method:      http.MethodGet,
+			target:      "/",
+			expectedKey: "file://GET@http://Example.com#/",
+		},
+		{
+			name:        "Trailing slashes trimmed down to the root",
+			builder:     StandardKeyBuilder{TrimTrailingSlash: true},
+			method:      http.MethodGet,
+			target:      "http://example.com//",
+			expectedKey: "file://GET@http://Example.com#/",
+		},
+		{
+			name:        "Lowercase host",
+			builder:     StandardKeyBuilder{LowercaseHost: true},
+			method:      http.MethodGet,
+			target:      "/Docs?Page=1",
+			expectedKey: "file://GET@http://example.com#/DocsPage=1",
+		},
+		{
+			name:    "Include selected headers",
+			builder: StandardKeyBuilder{IncludeHeaders: []string{"cookie", "Accept-Language"}},
+			method:  http.MethodGet,
+			target:  "/page",
+			headers: http.Header{
+				"Accept-Language": {"pt-BR"},
+				"Cookie":          {"session=1"},
+				"User-Agent":      {"ignored"},
+			},
+			expectedKey: "file://GET@http://Example.com#/page|Accept-Language=pt-BR|Cookie=session=1",
+		},
+		{
+			name:        "Include body hash",
+			builder:     StandardKeyBuilder{IncludeBodyHash: true},
+			method:      http.MethodPost,
+			target:      "/search",
+			body:        "hello",
+			expectedKey: "file://POST@http://Example.com#/search|body=2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(tt.method, tt.target, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.headers != nil {
+				req.Header = tt.headers
+			}
+
+			if actualKey := tt.builder.CacheKey(req, baseURL); actualKey != tt.expectedKey {
+				t.Errorf("Expected %q, got %q", tt.expectedKey, actualKey)
+			}
+
+			// The body must still be available to be sent upstream
+			if tt.body != "" {
+				restored, _ := io.ReadAll(req.Body)
+				if string(restored) != tt.body {
+					t.Errorf("Expected body %q to be restored, got %q", tt.body, restored)
+				}
+			}
+		})
+	}
+}
+
+func TestStandardKeyBuilder_BodyHashAfterForwarding(t *testing.T) {
+	target, _ := url.Parse("http://example.com")
+	builder := StandardKeyBuilder{IncludeBodyHash: true}
+	inbound := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"q":"go"}`))
+	storeKey := builder.CacheKey(inbound, target)
+
+	// The reverse proxy sends a clone upstream, which reads its body before the response is stored
+	outbound := inbound.Clone(inbound.Context())
+	_, _ = io.Copy(io.Discard, outbound.Body)
+	if lookupKey := builder.CacheKey(outbound, target); lookupKey != storeKey {
+		t.Errorf("Expected the forwarded request to keep the key %q, got %q", storeKey, lookupKey)
+	}
+}
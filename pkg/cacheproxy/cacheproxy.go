@@ -2,27 +2,92 @@ package cacheproxy
 
 import (
 	"context"
+	"crypto/tls"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
 )
 
 type (
 	CacheStorage   = KVStorage[FileInformation, string]
+	ProxyOption    func(proxy *CacheableProxy)
 	CacheableProxy struct {
 		storage           CacheStorage
 		cacheTTL          time.Duration
 		targetURL         *url.URL
 		port              uint16
 		trackedExtensions []string
+		keyBuilder        KeyBuilder
+		rules             atomic.Pointer[[]CacheRule]
+		negativeTTL       time.Duration
+		limiter           *upstreamLimiter
+		robots            *robotsCache
+		retry             *RetryPolicy
+		breakers          *breakerSet
+		metrics           *proxyMetrics
+		metricsPath       string
+		metricsToken      string
+		accessLog         *accessLogger
+		tlsPolicy         *TLSPolicy
+		tlsConfig         *tls.Config
+		streamRecording   *StreamRecordPolicy
+		offline           bool
+		urlRewrite        *URLRewritePolicy
+		originPattern     *regexp.Regexp
+		egressPolicy      *httptransport.EgressPolicy
+		egress            *httptransport.EgressTransport
+		bindHost          string
+		accessPolicy      *AccessPolicy
+		access            *accessControl
+		headerRules       *headerRules
+		namespaces        *NamespacePolicy
+		bandwidth         *bandwidthMeter
+		bandwidthSave     sync.Mutex
+		prefetcher        *prefetcher
+		healthPath        string
+		readyPath         string
+		lifecycle         serverLifecycle
 		reverse           *httputil.ReverseProxy
 	}
 )
 
-func New(storage CacheStorage, targetURL string, port uint16) (*CacheableProxy, error) {
+// WithKeyBuilder replaces the StandardKeyBuilder used to compute cache keys.
+func WithKeyBuilder(builder KeyBuilder) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.keyBuilder = builder
+	}
+}
+
+// WithCacheTTL changes for how long stored responses are served without asking upstream.
+func WithCacheTTL(ttl time.Duration) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.cacheTTL = ttl
+	}
+}
+
+// WithTrackedTypes replaces the MIME types and file extensions that are stored in cache.
+func WithTrackedTypes(types ...string) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.trackedExtensions = types
+	}
+}
+
+func New(
+	storage CacheStorage, targetURL string, port uint16, options ...ProxyOption,
+) (*CacheableProxy, error) {
 	target, err := url.ParseRequestURI(targetURL)
 	if err != nil {
 		return nil, err
@@ -35,76 +100,259 @@ func New(storage CacheStorage, targetURL string, port uint16) (*CacheableProxy,
 		cacheTTL:          36 * time.Hour,
 		reverse:           httputil.NewSingleHostReverseProxy(target),
 		trackedExtensions: []string{"text/html", "image/jpeg"},
+		keyBuilder:        StandardKeyBuilder{},
+		metrics:           newProxyMetrics(),
+		bandwidth:         newBandwidthMeter(target.Host),
+		lifecycle:         serverLifecycle{ready: make(chan struct{})},
+	}
+	for _, option := range options {
+		option(cacheableProxy)
+	}
+	if err = cacheableProxy.prepareTLS(); err != nil {
+		return nil, err
+	}
+	if err = cacheableProxy.prepareAccess(); err != nil {
+		return nil, err
+	}
+	if err = cacheableProxy.prepareNamespaces(); err != nil {
+		return nil, err
+	}
+	if cacheableProxy.egressPolicy != nil {
+		if cacheableProxy.egress, err = httptransport.NewEgressTransport(*cacheableProxy.egressPolicy); err != nil {
+			return nil, err
+		}
+		cacheableProxy.reverse.Transport = cacheableProxy.egress
 	}
-	cacheableProxy.reverse.ModifyResponse = cacheableProxy.InterceptFile
+	// Counted below the retries, so discarded attempts, robots.txt and sitemaps spend the budget too
+	cacheableProxy.reverse.Transport = &countingTransport{
+		base: cacheableProxy.upstreamTransport(), meter: cacheableProxy.bandwidth,
+	}
+	cacheableProxy.bandwidth.save = cacheableProxy.saveBandwidthLater
+	cacheableProxy.loadBandwidth()
+	// Crawl-delay needs a limiter, even when no politeness policy was given
+	if cacheableProxy.robots != nil && cacheableProxy.limiter == nil {
+		cacheableProxy.limiter = newUpstreamLimiter(PolitenessPolicy{})
+	}
+	if cacheableProxy.retry != nil || cacheableProxy.breakers != nil {
+		resilient := &resilientTransport{
+			base:     cacheableProxy.upstreamTransport(),
+			breakers: cacheableProxy.breakers,
+			limiter:  cacheableProxy.limiter,
+		}
+		if cacheableProxy.retry != nil {
+			resilient.retry = *cacheableProxy.retry
+		}
+		cacheableProxy.reverse.Transport = resilient
+	}
+	if cacheableProxy.robots != nil {
+		cacheableProxy.robots.transport = cacheableProxy.upstreamTransport()
+		cacheableProxy.robots.limiter = cacheableProxy.limiter
+	}
+	cacheableProxy.reverse.ModifyResponse = cacheableProxy.modifyResponse
 	cacheableProxy.reverse.Director = cacheableProxy.Director
+	cacheableProxy.reverse.ErrorHandler = cacheableProxy.handleUpstreamError
 	return cacheableProxy, nil
 }
 
 func (proxy *CacheableProxy) Handler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	slog.Info(
 		"[ PROXY SERVER ] Request received",
 		slog.String("URL", r.URL.String()), slog.Time("time", time.Now()),
 	)
 
-	cacheKey := proxy.cacheKey(r)
-	fileInfo, err := proxy.storage.Get(cacheKey)
-	if err != nil || len(fileInfo.Checksum) <= 0 {
-		// Finally return reverse
-		proxy.reverse.ServeHTTP(w, r)
+	ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := startSpan(
+		ctx, "CacheableProxy.Handler",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+	defer span.End()
+
+	r, namespaceErr := proxy.selectNamespace(r.WithContext(ctx))
+	state := proxy.newRequestState(r)
+	r = withRequestState(r, state)
+	tracked := &trackedWriter{
+		ResponseWriter: w,
+		beforeHeader: func(header http.Header) {
+			header.Set(HeaderCache, string(state.status))
+			if state.servedNamespace != "" {
+				header.Set(HeaderCacheNamespace, state.servedNamespace)
+			}
+		},
+	}
+	proxy.metrics.inFlight.Add(1)
+	defer func() {
+		proxy.metrics.inFlight.Add(-1)
+		// Upgraded connections are hijacked, so the status never reaches the writer
+		if tracked.status == 0 && (state.stream == streamWebsocket || state.stream == streamUpgrade) {
+			tracked.status = http.StatusSwitchingProtocols
+		}
+		proxy.recordRequest(tracked, state)
+		if proxy.accessLog != nil {
+			proxy.accessLog.log(newAccessLogEntry(r, tracked, state, start))
+		}
+		span.SetAttributes(
+			attribute.String("cache.key", state.key),
+			attribute.String("cache.status", string(state.status)),
+			attribute.Int("http.response.status_code", tracked.status),
+		)
+	}()
+
+	if namespaceErr != nil {
+		state.status = CacheBypass
+		http.Error(tracked, namespaceErr.Error(), http.StatusBadRequest)
 		return
 	}
+	proxy.serve(tracked, r, state)
+}
 
-	// Restore file response
+func (proxy *CacheableProxy) serve(w http.ResponseWriter, r *http.Request, state *requestState) {
+	if proxy.offline {
+		proxy.serveOffline(w, r, state, "request not available offline")
+		return
+	}
+	if proxy.bandwidth.exhausted(time.Now()) {
+		proxy.serveOffline(w, r, state, "bandwidth budget exceeded, request not available in cache")
+		return
+	}
+	if !state.cacheable {
+		if !proxy.robotsAllowed(r) {
+			proxy.denyByRobots(w, state)
+			return
+		}
+		proxy.forward(w, r, state)
+		return
+	}
+
+	_, lookupSpan := startSpan(r.Context(), "cache.lookup")
+	fileInfo, err := proxy.storage.Get(state.key)
+	if err == nil && isStreamRecording(fileInfo) {
+		err = errStreamRecording
+	}
+	lookupSpan.SetAttributes(attribute.Bool("cache.found", err == nil))
+	lookupSpan.End()
+
+	now := time.Now()
+	if err == nil && proxy.isFresh(fileInfo, now) {
+		state.status = CacheHit
+		proxy.writeCached(w, state, fileInfo, now)
+		return
+	}
+
+	if err != nil {
+		if parentInfo, found := proxy.parentEntry(state, func(info FileInformation) bool {
+			return proxy.isFresh(info, now)
+		}); found {
+			state.status = CacheHit
+			proxy.writeCached(w, state, parentInfo, now)
+			return
+		}
+	}
+
+	// While upstream is unhealthy, or robots.txt no longer allows the page,
+	// an expired copy is better than an error
+	robotsAllowed := proxy.robotsAllowed(r)
+	if err == nil && canServeStale(fileInfo) && (!robotsAllowed || proxy.isCircuitOpen()) {
+		state.status = CacheStale
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		proxy.writeCached(w, state, fileInfo, now)
+		return
+	}
+	if !robotsAllowed {
+		proxy.denyByRobots(w, state)
+		return
+	}
+	if err == nil {
+		prepareRevalidation(r, state, fileInfo)
+	}
+
+	// Finally return reverse
+	proxy.forward(w, r, state)
+}
+
+// writeCached restores the stored file response.
+func (proxy *CacheableProxy) writeCached(
+	w http.ResponseWriter, state *requestState, fileInfo FileInformation, now time.Time,
+) {
 	for key, values := range fileInfo.Envelope.Headers {
-		w.Header().Set(key, strings.Join(values, ","))
+		if !isRedacted(values) {
+			w.Header().Set(key, strings.Join(values, ","))
+		}
+	}
+	content := fileInfo.Content
+	if state.rewriter != nil {
+		content = state.rewriter.rewrite(w.Header(), content)
 	}
+	w.Header().Set("Age", strconv.FormatInt(int64(max(now.Sub(fileInfo.ModifiedAt), 0)/time.Second), 10))
 	w.WriteHeader(int(fileInfo.Envelope.Status))
-	_, err = w.Write(fileInfo.Content)
-	if err != nil {
+	if _, err := w.Write(content); err != nil {
 		slog.Error("[ PROXY SERVER ] Error writing response", slog.String("error", err.Error()))
 	}
 }
 
-func (proxy *CacheableProxy) Listen(
-	ctx context.Context, startFeedback chan string,
-) error { // Create a new server instance
+// modifyResponse stores the upstream response, then adapts the copy sent to the client.
+func (proxy *CacheableProxy) modifyResponse(resp *http.Response) error {
+	if err := proxy.InterceptFile(resp); err != nil {
+		return err
+	}
+	return proxy.rewriteResponse(resp)
+}
 
-	serveMux := http.NewServeMux()
-	serveMux.HandleFunc("/", proxy.Handler)
-	server := &http.Server{
-		Addr:    proxy.ServeHost(),
-		Handler: serveMux,
+// forward sends the request to upstream, respecting the politeness limits.
+func (proxy *CacheableProxy) forward(w http.ResponseWriter, r *http.Request, state *requestState) {
+	release, ok := proxy.acquireUpstream(w, r)
+	if !ok {
+		return
 	}
+	release = sync.OnceFunc(release)
+	state.releaseUpstream = release
+	defer release()
+	// Recordings keep the messages readable by not negotiating compression
+	if state.stream == streamWebsocket && proxy.streamRecording != nil {
+		r.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	host := proxy.targetURL.Host
+	proxy.metrics.upstreamInFlight.Add(1, host)
+	defer proxy.metrics.upstreamInFlight.Add(-1, host)
 
-	listener, err := proxy.prepareListener()
+	ctx, span := startSpan(
+		r.Context(), "upstream.fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", host)),
+	)
+	defer span.End()
+
+	state.upstreamStart = time.Now()
+	proxy.reverse.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Listen serves the proxy until ctx is done, sending the bound address to startFeedback, when given.
+// Start, Ready and Shutdown give finer control over the listener.
+func (proxy *CacheableProxy) Listen(ctx context.Context, startFeedback chan string) error {
+	address, err := proxy.Start()
 	if err != nil {
 		return err
 	}
+	if startFeedback != nil {
+		startFeedback <- address
+	}
 
-	// Create a channel to listen for errors from the server
-	errChan := make(chan error, 1)
-	defer listener.Close()
-
-	startFeedback <- proxy.ServeHost()
-	go func() {
-		errChan <- server.Serve(listener)
-	}()
-
-	// Listen for the context cancellation (graceful shutdown trigger)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- proxy.Wait() }()
 	select {
 	case <-ctx.Done():
-		// Shutdown the server gracefully
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute>>1)
 		defer cancel()
-
-		shutdownErr := server.Shutdown(shutdownCtx)
-		if shutdownErr != nil {
-			return shutdownErr
+		if err = proxy.Shutdown(shutdownCtx); err != nil {
+			return err
 		}
-		return ctx.Err() // Return the cancellation error
-	case err = <-errChan:
-		// If an error occurred while starting the server
+		return ctx.Err()
+	case err = <-serveErr:
 		return err
 	}
 }
@@ -0,0 +1,171 @@
+package cacheproxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of an upstream host.
+type CircuitState uint8
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+type (
+	// BreakerPolicy opens the circuit of a host after consecutive failures, failing
+	// fast until OpenDuration passes and a single probe request succeeds.
+	BreakerPolicy struct {
+		FailureThreshold int
+		OpenDuration     time.Duration
+	}
+	breakerSet struct {
+		policy BreakerPolicy
+		mutex  sync.Mutex
+		hosts  map[string]*circuitBreaker
+	}
+	circuitBreaker struct {
+		mutex         sync.Mutex
+		state         CircuitState
+		failures      int
+		openedAt      time.Time
+		probeInFlight bool
+	}
+)
+
+// WithCircuitBreaker fails fast, or serves stale cache, while an upstream host is unhealthy.
+func WithCircuitBreaker(policy BreakerPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		if policy.FailureThreshold <= 0 {
+			policy.FailureThreshold = 1
+		}
+		proxy.breakers = &breakerSet{policy: policy, hosts: make(map[string]*circuitBreaker)}
+	}
+}
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+func (set *breakerSet) host(name string) *circuitBreaker {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	breaker, ok := set.hosts[name]
+	if !ok {
+		breaker = &circuitBreaker{}
+		set.hosts[name] = breaker
+	}
+	return breaker
+}
+
+// states returns the current circuit state of every known host.
+func (set *breakerSet) states() map[string]CircuitState {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	states := make(map[string]CircuitState, len(set.hosts))
+	for name, breaker := range set.hosts {
+		states[name] = breaker.currentState(set.policy, time.Now())
+	}
+	return states
+}
+
+func (breaker *circuitBreaker) currentState(policy BreakerPolicy, now time.Time) CircuitState {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state == CircuitOpen && now.Sub(breaker.openedAt) >= policy.OpenDuration {
+		return CircuitHalfOpen
+	}
+	return breaker.state
+}
+
+// allow reports whether a request may be sent to the host. Once the open period is over,
+// a single probe request is allowed, so it can decide if the circuit closes again.
+func (breaker *circuitBreaker) allow(policy BreakerPolicy, now time.Time) bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		if now.Sub(breaker.openedAt) < policy.OpenDuration {
+			return false
+		}
+		breaker.state = CircuitHalfOpen
+		breaker.probeInFlight = true
+		return true
+	case CircuitHalfOpen:
+		if breaker.probeInFlight {
+			return false
+		}
+		breaker.probeInFlight = true
+	}
+	return true
+}
+
+// refuses reports whether allow would deny a request now: while open, and while
+// half-open with the probe request already sent.
+func (breaker *circuitBreaker) refuses(policy BreakerPolicy, now time.Time) bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		return now.Sub(breaker.openedAt) < policy.OpenDuration
+	case CircuitHalfOpen:
+		return breaker.probeInFlight
+	}
+	return false
+}
+
+// abandon frees the probe of a request that was canceled before upstream answered,
+// as it tells nothing about the host health.
+func (breaker *circuitBreaker) abandon() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.probeInFlight = false
+}
+
+func (breaker *circuitBreaker) record(policy BreakerPolicy, success bool, now time.Time) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.probeInFlight = false
+	if success {
+		breaker.state, breaker.failures = CircuitClosed, 0
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == CircuitHalfOpen || breaker.failures >= policy.FailureThreshold {
+		breaker.state, breaker.openedAt = CircuitOpen, now
+	}
+}
+
+// CircuitStates reports the circuit state of each upstream host the proxy has contacted.
+func (proxy *CacheableProxy) CircuitStates() map[string]CircuitState {
+	if proxy.breakers == nil {
+		return map[string]CircuitState{}
+	}
+	return proxy.breakers.states()
+}
+
+// isCircuitOpen reports whether a request to upstream would fail fast, so a stale copy
+// is served instead. Half-open circuits refuse every request but their probe.
+func (proxy *CacheableProxy) isCircuitOpen() bool {
+	if proxy.breakers == nil {
+		return false
+	}
+	return proxy.breakers.host(proxy.targetURL.Host).refuses(proxy.breakers.policy, time.Now())
+}
@@ -0,0 +1,119 @@
+package cacheproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_Transitions(t *testing.T) {
+	policy := BreakerPolicy{FailureThreshold: 2, OpenDuration: time.Minute}
+	breaker := &circuitBreaker{}
+	now := time.Now()
+
+	breaker.record(policy, false, now)
+	if state := breaker.currentState(policy, now); state != CircuitClosed {
+		t.Fatalf("Expected closed circuit after one failure, got %s", state)
+	}
+
+	breaker.record(policy, false, now)
+	if state := breaker.currentState(policy, now); state != CircuitOpen {
+		t.Fatalf("Expected open circuit after threshold, got %s", state)
+	}
+	if breaker.allow(policy, now.Add(time.Second)) {
+		t.Error("Expected requests to be denied while open")
+	}
+
+	// After the open duration, only a single probe is allowed
+	later := now.Add(2 * time.Minute)
+	if state := breaker.currentState(policy, later); state != CircuitHalfOpen {
+		t.Errorf("Expected half-open circuit, got %s", state)
+	}
+	if !breaker.allow(policy, later) {
+		t.Error("Expected probe request to be allowed")
+	}
+	if breaker.allow(policy, later) {
+		t.Error("Expected a second concurrent probe to be denied")
+	}
+	if !breaker.refuses(policy, later) {
+		t.Error("Expected the half-open circuit to refuse requests while probing")
+	}
+
+	// A canceled probe lets the next request probe again
+	breaker.abandon()
+	if breaker.refuses(policy, later) || !breaker.allow(policy, later) {
+		t.Error("Expected a new probe after the canceled one")
+	}
+
+	// A failed probe opens the circuit again, a successful one closes it
+	breaker.record(policy, false, later)
+	if state := breaker.currentState(policy, later); state != CircuitOpen {
+		t.Errorf("Expected circuit to open after failed probe, got %s", state)
+	}
+	evenLater := later.Add(2 * time.Minute)
+	if !breaker.allow(policy, evenLater) {
+		t.Fatal("Expected probe request to be allowed")
+	}
+	breaker.record(policy, true, evenLater)
+	if state := breaker.currentState(policy, evenLater); state != CircuitClosed {
+		t.Errorf("Expected closed circuit after successful probe, got %s", state)
+	}
+}
+
+func TestCacheableProxy_CircuitOpen(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	proxy, storage := newTestProxy(t, upstream, WithCircuitBreaker(BreakerPolicy{
+		FailureThreshold: 1, OpenDuration: time.Minute,
+	}))
+
+	// Store an expired copy of a page
+	staleReq := httptest.NewRequest(http.MethodGet, "/page", nil)
+	expired := time.Now().Add(-48 * time.Hour)
+	_ = storage.Set(proxy.cacheKey(staleReq), FileInformation{
+		Envelope: FileEnvelope{Status: http.StatusOK}, Content: []byte("stale"),
+		Checksum: checksum([]byte("stale")), CreatedAt: expired, ModifiedAt: expired,
+	})
+
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/fail", nil)); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected upstream failure status, got %d", recorder.Code)
+	}
+	if state := proxy.CircuitStates()[proxy.targetURL.Host]; state != CircuitOpen {
+		t.Fatalf("Expected open circuit, got %s", state)
+	}
+
+	recorder := serveProxy(proxy, staleReq)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "stale" {
+		t.Errorf("Expected stale copy to be served, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Warning") == "" {
+		t.Error("Expected stale response to have a Warning header")
+	}
+
+	recorder = serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected fast failure status 503, got %d", recorder.Code)
+	}
+	if header := recorder.Header().Get(HeaderCacheError); header != UpstreamErrorCircuit {
+		t.Errorf("Expected %s header `%s`, got `%s`", HeaderCacheError, UpstreamErrorCircuit, header)
+	}
+}
+
+func TestCacheableProxy_CanceledRequestKeepsCircuitClosed(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}
+	proxy, _ := newTestProxy(t, upstream, WithCircuitBreaker(BreakerPolicy{
+		FailureThreshold: 1, OpenDuration: time.Minute,
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+	if state := proxy.CircuitStates()[proxy.targetURL.Host]; state != CircuitClosed {
+		t.Errorf("Expected the canceled request to be ignored, got %s circuit", state)
+	}
+}
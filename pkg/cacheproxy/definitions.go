@@ -7,7 +7,37 @@ type KVStorage[T any, K comparable] interface {
 	Get(key K) (T, error)
 }
 
+// VersionedStorage is implemented by storages that keep older revisions of a key.
+type VersionedStorage interface {
+	Versions(key string) ([]FileVersion, error)
+	Version(key string, versionID string) (FileInformation, error)
+}
+
+// PrefixDeleter is implemented by storages able to remove every key starting with a prefix.
+type PrefixDeleter interface {
+	DeletePrefix(prefix string) (int, error)
+}
+
+// HealthReporter is implemented by storages able to tell whether they can serve requests.
+type HealthReporter interface {
+	Healthy() error
+}
+
+// StatsReporter is implemented by storages able to describe their resource usage.
+type StatsReporter interface {
+	Stats() StorageStats
+}
+
 type (
+	// StorageStats describes the disk usage and maintenance of a storage.
+	StorageStats struct {
+		LSMSize      int64
+		ValueLogSize int64
+		GCRuns       uint64
+		// CorruptEntries counts the entries that failed their checksum and were quarantined
+		CorruptEntries uint64
+		ScrubPasses    uint64
+	}
 	FileMIME struct {
 		Name      string
 		Extension string
@@ -26,4 +56,16 @@ type (
 		ModifiedAt    time.Time
 		ExtraMetadata map[string]string
 	}
+	// FileVersion identifies a single stored revision of a cached file.
+	FileVersion struct {
+		ID        string
+		Checksum  []byte
+		CreatedAt time.Time
+	}
+	// HistoryPolicy limits how many revisions are kept for each key.
+	// A zero value in any field means that limit is not applied.
+	HistoryPolicy struct {
+		MaxVersions int
+		MaxAge      time.Duration
+	}
 )
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"go.opentelemetry.io/otel/propagation"
+
 	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
 )
 
@@ -13,13 +15,35 @@ func (proxy *CacheableProxy) Director(req *http.Request) {
 	req.URL.Scheme = proxy.targetURL.Scheme
 	req.URL.Host = proxy.targetURL.Host
 	req.Host = proxy.targetURL.Host
+	proxy.applyUpstreamHeaders(req.Header)
+	// Upstream sees the proxy fetch as the parent of its own spans
+	traceContext.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
 	return
 }
 
+// upstreamTransport returns the transport used by the reverse proxy to reach upstream.
+func (proxy *CacheableProxy) upstreamTransport() http.RoundTripper {
+	if proxy.reverse.Transport != nil {
+		return proxy.reverse.Transport
+	}
+	return http.DefaultTransport
+}
+
+// RedirectRoundTripper sends the requests for the target to the proxy listener,
+// along with the credentials the access policy requires.
 func (proxy *CacheableProxy) RedirectRoundTripper() http.RoundTripper {
-	return httptransport.NewTransportRewrite(
-		proxy.targetURL, "localhost"+proxy.ServeHost(),
-	)
+	var rewrite *httptransport.TransportRewrite
+	if proxy.tlsConfig != nil {
+		rewrite = httptransport.NewTLSTransportRewrite(
+			proxy.targetURL, proxy.redirectAddress(), proxy.CertificatePool(),
+		)
+	} else {
+		rewrite = httptransport.NewTransportRewrite(proxy.targetURL, proxy.redirectAddress())
+	}
+	if authorization := proxy.clientAuthorization(); authorization != "" {
+		rewrite.Header = http.Header{"Authorization": {authorization}}
+	}
+	return rewrite
 }
 
 func (proxy *CacheableProxy) ServeHost() string {
@@ -27,7 +51,7 @@ func (proxy *CacheableProxy) ServeHost() string {
 }
 
 func (proxy *CacheableProxy) prepareListener() (net.Listener, error) {
-	listener, err := net.Listen("tcp", proxy.ServeHost())
+	listener, err := net.Listen("tcp", proxy.ListenAddress())
 	if err != nil {
 		return nil, err
 	}
@@ -54,3 +78,19 @@ func (proxy *CacheableProxy) prepareListener() (net.Listener, error) {
 	proxy.port = uint16(port)
 	return listener, nil
 }
+
+// WithEgress sends the requests to upstream through a pool of HTTP or SOCKS5 proxies,
+// instead of connecting directly.
+func WithEgress(policy httptransport.EgressPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.egressPolicy = &policy
+	}
+}
+
+// EgressStatus reports the health of the egress proxies, nil when none are configured.
+func (proxy *CacheableProxy) EgressStatus() []httptransport.EgressStatus {
+	if proxy.egress == nil {
+		return nil
+	}
+	return proxy.egress.Status()
+}
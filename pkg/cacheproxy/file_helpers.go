@@ -1,11 +1,16 @@
 package cacheproxy
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"io"
 	"net/http"
 )
 
+// ErrChecksumMismatch tells a stored entry no longer matches its checksum, it was corrupted on disk.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 func fileMIME(respBody []byte, header http.Header) string {
 	if contentType := header.Get("Content-Type"); contentType != "" &&
 		contentType != "application/octet-stream" {
@@ -23,3 +28,8 @@ func checksum(body []byte) []byte {
 func bodyReader(respBody io.Reader) ([]byte, error) {
 	return io.ReadAll(respBody)
 }
+
+// ChecksumMatches reports whether the stored checksum still describes the content.
+func (info FileInformation) ChecksumMatches() bool {
+	return bytes.Equal(info.Checksum, checksum(info.Content))
+}
@@ -0,0 +1,45 @@
+package cacheproxy
+
+import "time"
+
+// Keys used on FileInformation.ExtraMetadata by the proxy
+const (
+	MetadataExpiresAt  = "expires-at"
+	MetadataCacheError = "cache-error"
+)
+
+// expiresAt reports when the cached file stops being fresh.
+// Entries stored without an explicit expiration use the TTL of their namespace, or the proxy one.
+func (proxy *CacheableProxy) expiresAt(info FileInformation) time.Time {
+	if rawExpiry, ok := info.ExtraMetadata[MetadataExpiresAt]; ok {
+		if expiry, err := time.Parse(time.RFC3339Nano, rawExpiry); err == nil {
+			return expiry
+		}
+	}
+	if ttl := proxy.namespaceTTL(info.ExtraMetadata[MetadataNamespace]); ttl > 0 {
+		return info.ModifiedAt.Add(ttl)
+	}
+	return info.ModifiedAt.Add(proxy.cacheTTL)
+}
+
+func (proxy *CacheableProxy) isFresh(info FileInformation, now time.Time) bool {
+	return len(info.Checksum) > 0 && now.Before(proxy.expiresAt(info))
+}
+
+// isNegative reports whether the entry records an upstream failure instead of a page.
+func isNegative(info FileInformation) bool {
+	return info.ExtraMetadata[MetadataCacheError] != ""
+}
+
+// canServeStale reports whether an expired entry is a page worth replaying while upstream
+// is unavailable. Stored failures are not, they only spare upstream while fresh.
+func canServeStale(info FileInformation) bool {
+	return len(info.Checksum) > 0 && !isNegative(info)
+}
+
+func setExpiration(info *FileInformation, expiry time.Time) {
+	if info.ExtraMetadata == nil {
+		info.ExtraMetadata = make(map[string]string, 1)
+	}
+	info.ExtraMetadata[MetadataExpiresAt] = expiry.UTC().Format(time.RFC3339Nano)
+}
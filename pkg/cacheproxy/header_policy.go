@@ -0,0 +1,103 @@
+package cacheproxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RedactedValue replaces the values of the headers redacted before storing.
+// Cached responses are served without the headers holding it.
+const RedactedValue = "[redacted]"
+
+type (
+	// HeaderPolicy changes the headers sent upstream and the ones persisted with cached responses.
+	// Names are case-insensitive, and a trailing * matches every header with the prefix, like X-Amz-*.
+	HeaderPolicy struct {
+		// Inject is set on every request sent upstream, replacing the client values
+		Inject map[string]string
+		// StripRequest is removed from the requests before they are sent upstream
+		StripRequest []string
+		// DropStored is removed from the responses before they are stored
+		DropStored []string
+		// RedactStored keeps its name on stored responses, but with RedactedValue as value
+		RedactStored []string
+	}
+	headerMatcher []string
+	headerRules   struct {
+		inject              map[string]string
+		strip, drop, redact headerMatcher
+	}
+)
+
+// WithHeaderPolicy applies the policy to the requests sent upstream and to the stored responses.
+// Clients still receive the upstream headers on the response that filled the cache.
+func WithHeaderPolicy(policy HeaderPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.headerRules = &headerRules{
+			inject: policy.Inject,
+			strip:  newHeaderMatcher(policy.StripRequest),
+			drop:   newHeaderMatcher(policy.DropStored),
+			redact: newHeaderMatcher(policy.RedactStored),
+		}
+	}
+}
+
+func newHeaderMatcher(patterns []string) headerMatcher {
+	matcher := make(headerMatcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		matcher = append(matcher, strings.ToLower(strings.TrimSpace(pattern)))
+	}
+	return matcher
+}
+
+func (matcher headerMatcher) matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range matcher {
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard && strings.HasPrefix(name, prefix) {
+			return true
+		}
+		if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUpstreamHeaders strips and injects the request headers sent upstream.
+func (proxy *CacheableProxy) applyUpstreamHeaders(header http.Header) {
+	rules := proxy.headerRules
+	if rules == nil {
+		return
+	}
+	for name := range header {
+		if rules.strip.matches(name) {
+			header.Del(name)
+		}
+	}
+	for name, value := range rules.inject {
+		header.Set(name, value)
+	}
+}
+
+// storedHeaders returns the copy of the response headers that may be persisted.
+func (proxy *CacheableProxy) storedHeaders(header http.Header) http.Header {
+	stored := header.Clone()
+	rules := proxy.headerRules
+	if rules == nil {
+		return stored
+	}
+	for name := range stored {
+		switch {
+		case rules.drop.matches(name):
+			delete(stored, name)
+		case rules.redact.matches(name):
+			stored[name] = []string{RedactedValue}
+		}
+	}
+	return stored
+}
+
+// isRedacted reports whether the stored header values were replaced by RedactedValue.
+func isRedacted(values []string) bool {
+	return len(values) == 1 && values[0] == RedactedValue
+}
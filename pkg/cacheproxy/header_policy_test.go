@@ -0,0 +1,88 @@
+package cacheproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderMatcher_Matches(t *testing.T) {
+	matcher := newHeaderMatcher([]string{"Set-Cookie", "x-amz-*"})
+	testCases := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "set-cookie", expected: true},
+		{name: "X-Amz-Request-Id", expected: true},
+		{name: "X-Amz", expected: false},
+		{name: "Set-Cookie2", expected: false},
+		{name: "Content-Type", expected: false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			if matched := matcher.matches(tCase.name); matched != tCase.expected {
+				t.Errorf("Expected %v, got %v", tCase.expected, matched)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_HeaderPolicy(t *testing.T) {
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "crawler/1.0" || r.Header.Get("Cookie") != "" {
+			t.Errorf("Expected the injected agent and no cookie, got %v", r.Header)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Amz-Request-Id", "abc")
+		w.Header().Set("X-Signed-Url", "https://bucket.test/file?signature=secret")
+		_, _ = w.Write([]byte("<html>page</html>"))
+	}, WithHeaderPolicy(HeaderPolicy{
+		Inject:       map[string]string{"User-Agent": "crawler/1.0"},
+		StripRequest: []string{"Cookie"},
+		DropStored:   []string{"Set-Cookie", "X-Amz-*"},
+		RedactStored: []string{"X-Signed-Url"},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set("Cookie", "client=1")
+	miss := serveProxy(proxy, req)
+	if miss.Header().Get("Set-Cookie") != "session=secret" {
+		t.Errorf("Expected the client to get the upstream cookie, got %v", miss.Header())
+	}
+
+	stored, err := storage.Get(proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/page", nil)))
+	if err != nil {
+		t.Fatalf("Expected the page to be stored: %v", err)
+	}
+	headers := http.Header(stored.Envelope.Headers)
+	if headers.Get("Set-Cookie") != "" || headers.Get("X-Amz-Request-Id") != "" {
+		t.Errorf("Expected the dropped headers to not be stored, got %v", headers)
+	}
+	if headers.Get("X-Signed-Url") != RedactedValue || headers.Get("Content-Type") != "text/html" {
+		t.Errorf("Expected the redacted header and the content type, got %v", headers)
+	}
+
+	hit := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if hit.Header().Get(HeaderCache) != "HIT" {
+		t.Fatalf("Expected a cache hit, got %v", hit.Header())
+	}
+	if _, ok := hit.Header()["X-Signed-Url"]; ok || hit.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Expected the scrubbed headers to not be served, got %v", hit.Header())
+	}
+
+	proxy.cacheTTL = time.Nanosecond
+	revalidated := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if revalidated.Header().Get(HeaderCache) != string(CacheRevalidated) {
+		t.Fatalf("Expected a revalidated copy, got %v", revalidated.Header())
+	}
+	if _, ok := revalidated.Header()["X-Signed-Url"]; ok || revalidated.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("Expected the revalidated copy without the redacted header, got %v", revalidated.Header())
+	}
+}
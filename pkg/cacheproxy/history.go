@@ -0,0 +1,277 @@
+package cacheproxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	diffContextLines = 3
+	// maxDiffEdits bounds the time spent by the line diff, as bigger edit scripts
+	// are reported as a full replacement of the file. Its memory stays linear on the lines.
+	maxDiffEdits = 4096
+)
+
+type diffOp struct {
+	kind byte // one of ' ', '-', '+'
+	line string
+}
+
+// DiffStoredVersions loads two revisions of the same key and returns their unified diff.
+func DiffStoredVersions(storage VersionedStorage, key, fromID, toID string) (string, error) {
+	fromInfo, err := storage.Version(key, fromID)
+	if err != nil {
+		return "", err
+	}
+
+	var toInfo FileInformation
+	if toInfo, err = storage.Version(key, toID); err != nil {
+		return "", err
+	}
+	return DiffVersions(fromInfo, toInfo), nil
+}
+
+// DiffVersions produces a unified diff between two revisions of a file.
+// HTML content is split on tag boundaries, so markup changes show up on their own lines.
+func DiffVersions(from, to FileInformation) string {
+	fromLines, toLines := diffLines(from), diffLines(to)
+	ops := myersDiff(fromLines, toLines)
+
+	var builder strings.Builder
+	builder.WriteString("--- " + diffLabel(from) + "\n")
+	builder.WriteString("+++ " + diffLabel(to) + "\n")
+	writeHunks(&builder, ops)
+	return builder.String()
+}
+
+func diffLabel(info FileInformation) string {
+	return info.Name + "\t" + info.ModifiedAt.UTC().Format(time.RFC3339Nano)
+}
+
+func diffLines(info FileInformation) []string {
+	content := string(info.Content)
+	if !strings.Contains(strings.ToLower(info.MimeType), "html") {
+		return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	content = strings.ReplaceAll(content, "<", "\n<")
+	content = strings.ReplaceAll(content, ">", ">\n")
+	lines := make([]string, 0, strings.Count(content, "\n")+1)
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// myersDiff computes the shortest edit script between the two line lists.
+// It uses the linear space variant, splitting the lists around the middle of the edit path.
+func myersDiff(from, to []string) []diffOp {
+	diff := lineDiff{ops: make([]diffOp, 0, len(from)+len(to))}
+	if !diff.compare(from, to, maxDiffEdits) {
+		// Too many differences, so report the whole content as replaced
+		diff.ops = diff.ops[:0]
+		diff.replace(from, to)
+	}
+	return diff.ops
+}
+
+type lineDiff struct {
+	ops []diffOp
+}
+
+// compare appends the edit script between the lists, unless it needs more than limit edits.
+func (diff *lineDiff) compare(from, to []string, limit int) bool {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	diff.keep(from[:prefix])
+	from, to = from[prefix:], to[prefix:]
+
+	suffix := 0
+	for suffix < len(from) && suffix < len(to) && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	common := from[len(from)-suffix:]
+	from, to = from[:len(from)-suffix], to[:len(to)-suffix]
+
+	if len(from) == 0 || len(to) == 0 {
+		diff.replace(from, to)
+	} else {
+		x, y, ok := middleSnake(from, to, limit)
+		if !ok {
+			return false
+		}
+		diff.compare(from[:x], to[:y], len(from)+len(to))
+		diff.compare(from[x:], to[y:], len(from)+len(to))
+	}
+	diff.keep(common)
+	return true
+}
+
+func (diff *lineDiff) keep(lines []string) {
+	for _, line := range lines {
+		diff.ops = append(diff.ops, diffOp{kind: ' ', line: line})
+	}
+}
+
+func (diff *lineDiff) replace(from, to []string) {
+	for _, line := range from {
+		diff.ops = append(diff.ops, diffOp{kind: '-', line: line})
+	}
+	for _, line := range to {
+		diff.ops = append(diff.ops, diffOp{kind: '+', line: line})
+	}
+}
+
+// middleSnake walks the edit paths from both ends of the lists until they overlap, and returns
+// the point where the shortest path can be split. Lists sharing no line split into a full replace.
+// ok is false when the paths need more than limit edits to meet.
+func middleSnake(from, to []string, limit int) (x, y int, ok bool) {
+	n, m := len(from), len(to)
+	maxEdits := (n + m + 1) / 2
+	offset := maxEdits
+	forward, backward := make([]int, 2*maxEdits+2), make([]int, 2*maxEdits+2)
+	for index := range forward {
+		forward[index], backward[index] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// With an odd delta the forward path reaches the overlap first, otherwise the backward one
+	forwardMeets := delta%2 != 0
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+	for edits := 0; edits < maxEdits; edits++ {
+		if edits > (limit+1)/2 {
+			return 0, 0, false
+		}
+
+		for k := -edits + forwardStart; k <= edits-forwardEnd; k += 2 {
+			var x1 int
+			if k == -edits || (k != edits && forward[offset+k-1] < forward[offset+k+1]) {
+				x1 = forward[offset+k+1]
+			} else {
+				x1 = forward[offset+k-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && from[x1] == to[y1] {
+				x1, y1 = x1+1, y1+1
+			}
+			forward[offset+k] = x1
+
+			switch {
+			case x1 > n:
+				forwardEnd += 2
+			case y1 > m:
+				forwardStart += 2
+			case forwardMeets:
+				if reverse := offset + delta - k; reverse >= 0 && reverse < len(backward) && backward[reverse] != -1 {
+					if x1 >= n-backward[reverse] {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+
+		for k := -edits + backwardStart; k <= edits-backwardEnd; k += 2 {
+			var x2 int
+			if k == -edits || (k != edits && backward[offset+k-1] < backward[offset+k+1]) {
+				x2 = backward[offset+k+1]
+			} else {
+				x2 = backward[offset+k-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && from[n-x2-1] == to[m-y2-1] {
+				x2, y2 = x2+1, y2+1
+			}
+			backward[offset+k] = x2
+
+			switch {
+			case x2 > n:
+				backwardEnd += 2
+			case y2 > m:
+				backwardStart += 2
+			case !forwardMeets:
+				if ahead := offset + delta - k; ahead >= 0 && ahead < len(forward) && forward[ahead] != -1 {
+					x1 := forward[ahead]
+					if y1 := offset + x1 - ahead; x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return n, 0, true
+}
+
+func writeHunks(builder *strings.Builder, ops []diffOp) {
+	for start := 0; start < len(ops); {
+		// Skip to the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start >= len(ops) {
+			return
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		end, unchanged := start, 0
+		for index := start; index < len(ops) && unchanged <= 2*diffContextLines; index++ {
+			if ops[index].kind == ' ' {
+				unchanged++
+				continue
+			}
+			unchanged, end = 0, index+1
+		}
+
+		hunkStart := max(start-diffContextLines, 0)
+		hunkEnd := min(end+diffContextLines, len(ops))
+		writeHunk(builder, ops, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+}
+
+func writeHunk(builder *strings.Builder, ops []diffOp, start, end int) {
+	var fromLine, toLine int
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+
+	var fromCount, toCount int
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+
+	_, _ = fmt.Fprintf(
+		builder, "@@ -%s +%s @@\n",
+		hunkRange(fromLine, fromCount), hunkRange(toLine, toCount),
+	)
+	for _, op := range ops[start:end] {
+		builder.WriteByte(op.kind)
+		builder.WriteString(op.line)
+		builder.WriteByte('\n')
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
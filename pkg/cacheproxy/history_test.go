@@ -0,0 +1,137 @@
+package cacheproxy
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		mimeType      string
+		from, to      string
+		expectedHunks string
+	}{
+		{
+			name:          "Identical content",
+			mimeType:      "text/plain",
+			from:          "a\nb\nc",
+			to:            "a\nb\nc",
+			expectedHunks: "",
+		},
+		{
+			name:          "Changed line in plain text",
+			mimeType:      "text/plain",
+			from:          "a\nb\nc",
+			to:            "a\nB\nc",
+			expectedHunks: "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:          "Appended line",
+			mimeType:      "text/plain",
+			from:          "a",
+			to:            "a\nb",
+			expectedHunks: "@@ -1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			name:     "HTML split on tags",
+			mimeType: "text/html; charset=utf-8",
+			from:     "<html><body><p>old</p></body></html>",
+			to:       "<html><body><p>new</p></body></html>",
+			expectedHunks: "@@ -1,7 +1,7 @@\n <html>\n <body>\n <p>\n" +
+				"-old\n+new\n </p>\n </body>\n </html>\n",
+		},
+		{
+			name:     "Distant changes create separate hunks",
+			mimeType: "text/plain",
+			from:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			to:       "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve",
+			expectedHunks: "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := FileInformation{FileMIME: FileMIME{Name: "/page", MimeType: tt.mimeType}}
+			to := from
+			from.Content, to.Content = []byte(tt.from), []byte(tt.to)
+
+			diff := DiffVersions(from, to)
+			lines := strings.SplitN(diff, "\n", 3)
+			if !strings.HasPrefix(lines[0], "--- /page") || !strings.HasPrefix(lines[1], "+++ /page") {
+				t.Errorf("Expected unified diff headers, got %q", diff)
+			}
+			if hunks := lines[2]; hunks != tt.expectedHunks {
+				t.Errorf("Expected hunks %q, got %q", tt.expectedHunks, hunks)
+			}
+		})
+	}
+}
+
+func TestMyersDiff_ShortestScript(t *testing.T) {
+	random := rand.New(rand.NewPCG(26, 1))
+	randomLines := func() []string {
+		lines := make([]string, random.IntN(12))
+		for index := range lines {
+			lines[index] = string(rune('a' + random.IntN(4)))
+		}
+		return lines
+	}
+
+	for range 500 {
+		from, to := randomLines(), randomLines()
+		ops := myersDiff(from, to)
+
+		var rebuiltFrom, rebuiltTo []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				rebuiltFrom = append(rebuiltFrom, op.line)
+			}
+			if op.kind != '-' {
+				rebuiltTo = append(rebuiltTo, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if !slices.Equal(rebuiltFrom, from) || !slices.Equal(rebuiltTo, to) {
+			t.Fatalf("Expected the script to rebuild %q into %q, got %v", from, to, ops)
+		}
+
+		// The shortest script only keeps the longest common subsequence
+		common := make([][]int, len(from)+1)
+		for index := range common {
+			common[index] = make([]int, len(to)+1)
+		}
+		for i := len(from) - 1; i >= 0; i-- {
+			for j := len(to) - 1; j >= 0; j-- {
+				if from[i] == to[j] {
+					common[i][j] = common[i+1][j+1] + 1
+				} else {
+					common[i][j] = max(common[i+1][j], common[i][j+1])
+				}
+			}
+		}
+		if expected := len(from) + len(to) - 2*common[0][0]; edits != expected {
+			t.Fatalf("Expected %d edits from %q to %q, got %d: %v", expected, from, to, edits, ops)
+		}
+	}
+}
+
+func TestMyersDiff_TooManyEdits(t *testing.T) {
+	from, to := make([]string, 3*maxDiffEdits), make([]string, 3*maxDiffEdits)
+	for index := range from {
+		from[index], to[index] = "old "+strconv.Itoa(index), "new "+strconv.Itoa(index)
+	}
+	from[len(from)/2] = to[len(to)/2]
+
+	ops := myersDiff(from, to)
+	if len(ops) != len(from)+len(to) || ops[0].kind != '-' || ops[len(ops)-1].kind != '+' {
+		t.Errorf("Expected the whole content replaced, got %d operations", len(ops))
+	}
+}
@@ -0,0 +1,231 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("cache proxy listener already started")
+	ErrNotReady       = errors.New("cache proxy listener is not ready")
+	ErrShuttingDown   = errors.New("cache proxy is shutting down")
+)
+
+type (
+	// serverLifecycle holds the listener state shared by Start, Shutdown and the health endpoints.
+	serverLifecycle struct {
+		mutex    sync.Mutex
+		server   *http.Server
+		ready    chan struct{}
+		done     chan struct{}
+		serveErr error
+		stopping atomic.Bool
+		writes   writeTracker
+	}
+	// writeTracker counts the cache writes in progress, so Shutdown can wait for them.
+	writeTracker struct {
+		mutex   sync.Mutex
+		pending int
+		// idle is closed once the pending writes finished
+		idle chan struct{}
+	}
+)
+
+// WithHealthPaths serves the health and readiness endpoints on the proxy listener, to anyone even
+// with an access policy, shadowing the same upstream routes. An empty path disables it, as by default.
+func WithHealthPaths(healthPath, readyPath string) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.healthPath, proxy.readyPath = healthPath, readyPath
+	}
+}
+
+// Start binds the listener and serves it in background, returning the bound address.
+// The Ready channel is closed once it returns, and Shutdown stops it.
+func (proxy *CacheableProxy) Start() (string, error) {
+	lifecycle := &proxy.lifecycle
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+	if lifecycle.server != nil {
+		return "", ErrAlreadyStarted
+	}
+
+	listener, err := proxy.prepareListener()
+	if err != nil {
+		return "", err
+	}
+	server := &http.Server{
+		Addr:      proxy.ListenAddress(),
+		Handler:   proxy.serveMux(),
+		TLSConfig: proxy.tlsConfig,
+	}
+	lifecycle.server, lifecycle.done = server, make(chan struct{})
+	go func() {
+		defer close(lifecycle.done)
+		var serveErr error
+		if server.TLSConfig != nil {
+			// Certificates come from the TLSConfig, ServeTLS also enables HTTP/2
+			serveErr = server.ServeTLS(listener, "", "")
+		} else {
+			serveErr = server.Serve(listener)
+		}
+		if !errors.Is(serveErr, http.ErrServerClosed) {
+			lifecycle.serveErr = serveErr
+		}
+	}()
+	close(lifecycle.ready)
+	return listener.Addr().String(), nil
+}
+
+// Ready is closed once the listener started by Start accepts connections.
+func (proxy *CacheableProxy) Ready() <-chan struct{} {
+	return proxy.lifecycle.ready
+}
+
+// Wait blocks until the listener stops, returning why it failed, or nil after Shutdown.
+func (proxy *CacheableProxy) Wait() error {
+	proxy.lifecycle.mutex.Lock()
+	done := proxy.lifecycle.done
+	proxy.lifecycle.mutex.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	return proxy.lifecycle.serveErr
+}
+
+// Shutdown stops accepting connections and the prefetching, then waits for the requests
+// in flight and for the cache writes they started, until ctx is done, and saves the bandwidth counters.
+func (proxy *CacheableProxy) Shutdown(ctx context.Context) error {
+	lifecycle := &proxy.lifecycle
+	lifecycle.stopping.Store(true)
+	lifecycle.mutex.Lock()
+	server := lifecycle.server
+	lifecycle.mutex.Unlock()
+
+	var errs []error
+	if server != nil {
+		errs = append(errs, server.Shutdown(ctx))
+	}
+	if proxy.prefetcher != nil {
+		errs = append(errs, proxy.prefetcher.stop(ctx))
+	}
+	errs = append(errs, lifecycle.writes.wait(ctx))
+	// Saved last, once the requests in flight counted their bytes
+	errs = append(errs, proxy.saveBandwidth())
+	return errors.Join(errs...)
+}
+
+// serveMux routes the listener requests, with the health endpoints outside of the access policy.
+func (proxy *CacheableProxy) serveMux() http.Handler {
+	proxyMux := http.NewServeMux()
+	proxyMux.HandleFunc("/", proxy.Handler)
+	if proxy.metricsPath != "" && proxy.metricsToken == "" {
+		proxyMux.Handle(proxy.metricsPath, proxy.MetricsHandler())
+	}
+	var handler http.Handler = proxyMux
+	if proxy.access != nil {
+		handler = proxy.access.guard(proxyMux)
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/", handler)
+	if proxy.metricsPath != "" && proxy.metricsToken != "" {
+		metricsAccess := &accessControl{policy: AccessPolicy{BearerTokens: []string{proxy.metricsToken}}}
+		if proxy.access != nil {
+			metricsAccess.networks = proxy.access.networks
+		}
+		serveMux.Handle(proxy.metricsPath, metricsAccess.guard(proxy.MetricsHandler()))
+	}
+	if proxy.healthPath != "" {
+		serveMux.Handle("GET "+proxy.healthPath, proxy.HealthHandler())
+	}
+	if proxy.readyPath != "" {
+		serveMux.Handle("GET "+proxy.readyPath, proxy.ReadyHandler())
+	}
+	return serveMux
+}
+
+// HealthHandler answers 200 while the storage is available, and 503 otherwise.
+func (proxy *CacheableProxy) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, proxy.storageHealth())
+	})
+}
+
+// ReadyHandler answers 200 while the listener accepts requests and the storage is available.
+func (proxy *CacheableProxy) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, proxy.readiness())
+	})
+}
+
+func (proxy *CacheableProxy) readiness() error {
+	select {
+	case <-proxy.lifecycle.ready:
+	default:
+		return ErrNotReady
+	}
+	if proxy.lifecycle.stopping.Load() {
+		return ErrShuttingDown
+	}
+	return proxy.storageHealth()
+}
+
+func (proxy *CacheableProxy) storageHealth() error {
+	if reporter, ok := proxy.storage.(HealthReporter); ok {
+		return reporter.Healthy()
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// store writes the entry, letting Shutdown wait until it is flushed.
+func (proxy *CacheableProxy) store(key string, info FileInformation) error {
+	finish := proxy.lifecycle.writes.begin()
+	defer finish()
+	return proxy.storage.Set(key, info)
+}
+
+func (tracker *writeTracker) begin() func() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.pending == 0 {
+		tracker.idle = make(chan struct{})
+	}
+	tracker.pending++
+	return func() {
+		tracker.mutex.Lock()
+		defer tracker.mutex.Unlock()
+		if tracker.pending--; tracker.pending == 0 {
+			close(tracker.idle)
+		}
+	}
+}
+
+func (tracker *writeTracker) wait(ctx context.Context) error {
+	tracker.mutex.Lock()
+	idle := tracker.idle
+	tracker.mutex.Unlock()
+	if idle == nil {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
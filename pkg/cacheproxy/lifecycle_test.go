@@ -0,0 +1,151 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unhealthyStorage is a memoryStorage reporting the given health.
+type unhealthyStorage struct {
+	*memoryStorage
+	err error
+}
+
+func (storage unhealthyStorage) Healthy() error {
+	return storage.err
+}
+
+func TestCacheableProxy_StartAndShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html>page</html>")
+	}, WithBindAddress("127.0.0.1"), WithAccessPolicy(AccessPolicy{BearerTokens: []string{"token-1"}}),
+		WithHealthPaths("/healthz", "/readyz"))
+
+	address, err := proxy.Start()
+	if err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	select {
+	case <-proxy.Ready():
+	default:
+		t.Fatal("Expected the proxy to be ready once started")
+	}
+	if _, err = proxy.Start(); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("Expected a second start to fail, got %v", err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, getErr := http.Get("http://" + address + path)
+		if getErr != nil {
+			t.Fatalf("Request to %s failed: %v", path, getErr)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected %s to answer without credentials, got %d", path, resp.StatusCode)
+		}
+	}
+
+	client := &http.Client{Transport: proxy.RedirectRoundTripper(), Timeout: 5 * time.Second}
+	responded := make(chan error, 1)
+	go func() {
+		resp, getErr := client.Get(proxy.targetURL.String() + "/page")
+		if getErr == nil {
+			_, _ = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		responded <- getErr
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- proxy.Shutdown(context.Background()) }()
+	for proxy.readiness() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Expected shutdown to wait for the request in flight, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err = <-shutdown; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if err = <-responded; err != nil {
+		t.Errorf("Expected the request in flight to complete, got %v", err)
+	}
+	if storage.Len() != 1 {
+		t.Errorf("Expected the response to be stored before shutdown returned")
+	}
+	if err = proxy.Wait(); err != nil {
+		t.Errorf("Expected Wait to return nil after shutdown, got %v", err)
+	}
+}
+
+func TestCacheableProxy_OperationalPathsReachUpstream(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "upstream "+r.URL.Path)
+	})
+
+	handler := proxy.serveMux()
+	for _, path := range []string{"/metrics", "/healthz", "/readyz"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if body := recorder.Body.String(); body != "upstream "+path {
+			t.Errorf("Expected %s to be proxied by default, got %d `%s`", path, recorder.Code, body)
+		}
+	}
+}
+
+func TestCacheableProxy_HealthHandlers(t *testing.T) {
+	storageErr := errors.New("storage closed")
+	testCases := []struct {
+		name          string
+		storage       CacheStorage
+		start         bool
+		expectedReady int
+		expectedAlive int
+	}{
+		{name: "not started", storage: newMemoryStorage(), expectedReady: 503, expectedAlive: 200},
+		{name: "started", storage: newMemoryStorage(), start: true, expectedReady: 200, expectedAlive: 200},
+		{
+			name:    "storage unavailable",
+			storage: unhealthyStorage{memoryStorage: newMemoryStorage(), err: storageErr},
+			start:   true, expectedReady: 503, expectedAlive: 503,
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			proxy, err := New(tCase.storage, "http://example.com", 0, WithBindAddress("127.0.0.1"))
+			if err != nil {
+				t.Fatalf("Failed to create proxy: %v", err)
+			}
+			if tCase.start {
+				if _, err = proxy.Start(); err != nil {
+					t.Fatalf("Failed to start: %v", err)
+				}
+				defer proxy.Shutdown(context.Background())
+			}
+
+			ready := httptest.NewRecorder()
+			proxy.ReadyHandler().ServeHTTP(ready, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			alive := httptest.NewRecorder()
+			proxy.HealthHandler().ServeHTTP(alive, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if ready.Code != tCase.expectedReady || alive.Code != tCase.expectedAlive {
+				t.Errorf(
+					"Expected ready %d and alive %d, got %d and %d",
+					tCase.expectedReady, tCase.expectedAlive, ready.Code, alive.Code,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package cacheproxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultLatencyBuckets are the upper bounds, in seconds, used by latency histograms
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	metricKind string
+	// metricVec holds the values of a metric for every combination of its labels.
+	metricVec struct {
+		name    string
+		help    string
+		kind    metricKind
+		labels  []string
+		buckets []float64
+		mutex   sync.Mutex
+		series  map[string]*metricSeries
+	}
+	metricSeries struct {
+		labelValues  []string
+		value        float64
+		bucketCounts []uint64
+		count        uint64
+		// lastTotal is the cumulative total seen by AddTotal
+		lastTotal float64
+	}
+)
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+)
+
+func newMetricVec(kind metricKind, name, help string, labels ...string) *metricVec {
+	vec := &metricVec{
+		name: name, help: help, kind: kind, labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+	if kind == metricHistogram {
+		vec.buckets = defaultLatencyBuckets
+	}
+	return vec
+}
+
+func (vec *metricVec) seriesFor(labelValues []string) *metricSeries {
+	seriesKey := strings.Join(labelValues, "\xff")
+	series, ok := vec.series[seriesKey]
+	if !ok {
+		series = &metricSeries{labelValues: slices.Clone(labelValues)}
+		if vec.kind == metricHistogram {
+			series.bucketCounts = make([]uint64, len(vec.buckets))
+		}
+		vec.series[seriesKey] = series
+	}
+	return series
+}
+
+// Add increments the counter or gauge identified by the label values.
+// Counters only go up, so a negative delta on them panics.
+func (vec *metricVec) Add(delta float64, labelValues ...string) {
+	if vec.kind == metricCounter && delta < 0 {
+		panic("cacheproxy: counter " + vec.name + " cannot decrease")
+	}
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	vec.seriesFor(labelValues).value += delta
+}
+
+// AddTotal increments the counter by how much a cumulative total, counted elsewhere,
+// grew since the previous call. A lower total means its source restarted, so it is added whole.
+func (vec *metricVec) AddTotal(total float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	series := vec.seriesFor(labelValues)
+	if total >= series.lastTotal {
+		series.value += total - series.lastTotal
+	} else {
+		series.value += total
+	}
+	series.lastTotal = total
+}
+
+// Set replaces the gauge value identified by the label values.
+// Counters are only incremented, so setting them panics.
+func (vec *metricVec) Set(value float64, labelValues ...string) {
+	if vec.kind != metricGauge {
+		panic("cacheproxy: " + string(vec.kind) + " " + vec.name + " cannot be set")
+	}
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	vec.seriesFor(labelValues).value = value
+}
+
+// Observe records a value on the histogram identified by the label values.
+func (vec *metricVec) Observe(value float64, labelValues ...string) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	series := vec.seriesFor(labelValues)
+	series.value += value
+	series.count++
+	for index, upperBound := range vec.buckets {
+		if value <= upperBound {
+			series.bucketCounts[index]++
+		}
+	}
+}
+
+// Value returns the current value of a counter or gauge.
+func (vec *metricVec) Value(labelValues ...string) float64 {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	if series, ok := vec.series[strings.Join(labelValues, "\xff")]; ok {
+		return series.value
+	}
+	return 0
+}
+
+// WriteTo writes the metric using the Prometheus text exposition format.
+func (vec *metricVec) WriteTo(writer io.Writer) (int64, error) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n", vec.name, vec.help, vec.name, vec.kind)
+
+	seriesKeys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		seriesKeys = append(seriesKeys, key)
+	}
+	slices.Sort(seriesKeys)
+
+	for _, key := range seriesKeys {
+		series := vec.series[key]
+		if vec.kind != metricHistogram {
+			writeSample(&builder, vec.name, vec.labels, series.labelValues, "", "", series.value)
+			continue
+		}
+
+		for index, upperBound := range vec.buckets {
+			writeSample(
+				&builder, vec.name+"_bucket", vec.labels, series.labelValues,
+				"le", formatFloat(upperBound), float64(series.bucketCounts[index]),
+			)
+		}
+		writeSample(
+			&builder, vec.name+"_bucket", vec.labels, series.labelValues,
+			"le", "+Inf", float64(series.count),
+		)
+		writeSample(&builder, vec.name+"_sum", vec.labels, series.labelValues, "", "", series.value)
+		writeSample(&builder, vec.name+"_count", vec.labels, series.labelValues, "", "", float64(series.count))
+	}
+
+	written, err := io.WriteString(writer, builder.String())
+	return int64(written), err
+}
+
+func writeSample(
+	builder *strings.Builder, name string, labels, labelValues []string,
+	extraLabel, extraValue string, value float64,
+) {
+	builder.WriteString(name)
+	pairs := make([]string, 0, len(labels)+1)
+	for index, label := range labels {
+		pairs = append(pairs, label+`="`+escapeLabelValue(labelValues[index])+`"`)
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+	}
+	if len(pairs) > 0 {
+		builder.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	builder.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
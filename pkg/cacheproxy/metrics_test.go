@@ -0,0 +1,125 @@
+package cacheproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricVec_WriteTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		vec      *metricVec
+		update   func(vec *metricVec)
+		expected string
+	}{
+		{
+			name: "Counter with labels",
+			vec:  newMetricVec(metricCounter, "test_total", "Test counter.", "host"),
+			update: func(vec *metricVec) {
+				vec.Add(2, "b.com")
+				vec.Add(1, `a"\.com`)
+				vec.Add(1, "b.com")
+			},
+			expected: "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+				"test_total{host=\"a\\\"\\\\.com\"} 1\ntest_total{host=\"b.com\"} 3\n",
+		},
+		{
+			name: "Counter following a cumulative total",
+			vec:  newMetricVec(metricCounter, "test_runs_total", "Test total."),
+			update: func(vec *metricVec) {
+				vec.AddTotal(3)
+				vec.AddTotal(5)
+				vec.AddTotal(5)
+				vec.AddTotal(2) // The source restarted counting
+			},
+			expected: "# HELP test_runs_total Test total.\n# TYPE test_runs_total counter\ntest_runs_total 7\n",
+		},
+		{
+			name:     "Gauge without labels",
+			vec:      newMetricVec(metricGauge, "test_gauge", "Test gauge."),
+			update:   func(vec *metricVec) { vec.Set(42) },
+			expected: "# HELP test_gauge Test gauge.\n# TYPE test_gauge gauge\ntest_gauge 42\n",
+		},
+		{
+			name: "Histogram",
+			vec: func() *metricVec {
+				vec := newMetricVec(metricHistogram, "test_seconds", "Test histogram.", "host")
+				vec.buckets = []float64{0.1, 1}
+				return vec
+			}(),
+			update: func(vec *metricVec) {
+				vec.Observe(0.05, "a")
+				vec.Observe(0.5, "a")
+				vec.Observe(3, "a")
+			},
+			expected: "# HELP test_seconds Test histogram.\n# TYPE test_seconds histogram\n" +
+				"test_seconds_bucket{host=\"a\",le=\"0.1\"} 1\n" +
+				"test_seconds_bucket{host=\"a\",le=\"1\"} 2\n" +
+				"test_seconds_bucket{host=\"a\",le=\"+Inf\"} 3\n" +
+				"test_seconds_sum{host=\"a\"} 3.55\n" +
+				"test_seconds_count{host=\"a\"} 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update(tt.vec)
+			var builder strings.Builder
+			if _, err := tt.vec.WriteTo(&builder); err != nil {
+				t.Fatal(err)
+			}
+			if builder.String() != tt.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, builder.String())
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_MetricsHandler(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html></html>"))
+	}
+	proxy, _ := newTestProxy(t, upstream)
+
+	for range 2 {
+		serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	proxy.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	host := proxy.targetURL.Host
+	expectedLines := []string{
+		`radadar_proxy_requests_total{host="` + host + `",mime="text/html",cache_status="HIT"} 1`,
+		`radadar_proxy_requests_total{host="` + host + `",mime="text/html",cache_status="MISS"} 1`,
+		`radadar_proxy_served_bytes_total{host="` + host + `",source="cache"} 13`,
+		`radadar_proxy_served_bytes_total{host="` + host + `",source="upstream"} 13`,
+		`radadar_proxy_upstream_duration_seconds_count{host="` + host + `"} 1`,
+		`radadar_proxy_upstream_inflight_requests{host="` + host + `"} 0`,
+		`radadar_proxy_inflight_requests 0`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(recorder.Body.String(), line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, recorder.Body.String())
+		}
+	}
+}
+
+func TestMetricVec_CountersOnlyIncrease(t *testing.T) {
+	counter := newMetricVec(metricCounter, "test_total", "Test counter.")
+	for name, update := range map[string]func(){
+		"Set":            func() { counter.Set(1) },
+		"Negative delta": func() { counter.Add(-1) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the counter to refuse decreasing")
+				}
+			}()
+			update()
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HeaderCacheNamespace selects the namespace of a request, and tells clients the namespace of the response.
+const HeaderCacheNamespace = "X-Cache-Namespace"
+
+// MetadataNamespace keeps on FileInformation.ExtraMetadata the namespace that stored the file.
+const MetadataNamespace = "namespace"
+
+const maxNamespaceLength = 64
+
+var (
+	ErrInvalidNamespace = errors.New("invalid cache namespace")
+	ErrPurgeUnsupported = errors.New("storage can not delete keys by prefix")
+)
+
+type (
+	// NamespacePolicy splits the cache into namespaces, so overlapping crawl sessions do not share entries.
+	// A request selects its namespace with the X-Cache-Namespace header, then with the path prefix,
+	// and finally falls back to Default. The shared keyspace, the one without namespaces, is named "".
+	NamespacePolicy struct {
+		Default string
+		// PathPrefix, like /_ns/, selects the namespace from the next path segment, removed before forwarding
+		PathPrefix string
+		Namespaces map[string]Namespace
+	}
+	// Namespace configures a single namespace. Namespaces not declared use the proxy TTL, without fallthrough.
+	Namespace struct {
+		// TTL replaces the proxy TTL for the entries of the namespace, when positive
+		TTL time.Duration
+		// Parent is looked up on misses when Fallthrough is set, the shared keyspace when empty
+		Parent      string
+		Fallthrough bool
+	}
+	namespaceContextKey struct{}
+)
+
+// WithNamespaces keeps the entries of each namespace under its own key prefix.
+func WithNamespaces(policy NamespacePolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.namespaces = &policy
+	}
+}
+
+// NamespaceKeyPrefix is prepended to the cache keys of the namespace.
+// The shared keyspace has no prefix, keeping the keys stored before namespaces existed.
+func NamespaceKeyPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "ns:" + name + "|"
+}
+
+// ValidNamespace reports whether the name only has letters, digits, '.', '_' and '-'.
+func ValidNamespace(name string) bool {
+	if name == "" || len(name) > maxNamespaceLength {
+		return false
+	}
+	for _, char := range name {
+		alphanumeric := ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+		if !alphanumeric && !strings.ContainsRune("._-", char) {
+			return false
+		}
+	}
+	return true
+}
+
+func (proxy *CacheableProxy) prepareNamespaces() error {
+	policy := proxy.namespaces
+	if policy == nil {
+		return nil
+	}
+	if policy.Default != "" && !ValidNamespace(policy.Default) {
+		return fmt.Errorf("%w: default %q", ErrInvalidNamespace, policy.Default)
+	}
+	if prefix := policy.PathPrefix; prefix != "" && (!strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/")) {
+		return fmt.Errorf("namespace path prefix must start and end with /, got %q", prefix)
+	}
+	for name, namespace := range policy.Namespaces {
+		if !ValidNamespace(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+		}
+		if namespace.Parent != "" && !ValidNamespace(namespace.Parent) {
+			return fmt.Errorf("%w: parent %q of %q", ErrInvalidNamespace, namespace.Parent, name)
+		}
+		visited := map[string]bool{name: true}
+		for current := namespace; current.Fallthrough && current.Parent != ""; {
+			if visited[current.Parent] {
+				return fmt.Errorf("namespace %q falls through to itself, by %q", name, current.Parent)
+			}
+			visited[current.Parent] = true
+			current = policy.Namespaces[current.Parent]
+		}
+	}
+	return nil
+}
+
+// selectNamespace attaches the namespace of the request to its context, removing
+// the namespace header and path prefix, so upstream never sees them.
+func (proxy *CacheableProxy) selectNamespace(req *http.Request) (*http.Request, error) {
+	policy := proxy.namespaces
+	if policy == nil {
+		return req, nil
+	}
+
+	var fromPath string
+	fromHeader := req.Header.Get(HeaderCacheNamespace)
+	rest, hasPrefix := strings.CutPrefix(req.URL.Path, policy.PathPrefix)
+	hasPrefix = hasPrefix && policy.PathPrefix != ""
+	if hasPrefix || fromHeader != "" {
+		req = req.Clone(req.Context())
+		req.Header.Del(HeaderCacheNamespace)
+	}
+	if hasPrefix {
+		var path string
+		fromPath, path, _ = strings.Cut(rest, "/")
+		req.URL.Path, req.URL.RawPath = "/"+path, ""
+		req.RequestURI = req.URL.RequestURI()
+	}
+
+	name := policy.Default
+	for _, selected := range []string{fromHeader, fromPath} {
+		if selected != "" {
+			name = selected
+			break
+		}
+	}
+	if name != "" && !ValidNamespace(name) {
+		return req, fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+	}
+	return req.WithContext(context.WithValue(req.Context(), namespaceContextKey{}, name)), nil
+}
+
+// namespaceOf returns the namespace selected for the request, or the default one.
+func (proxy *CacheableProxy) namespaceOf(req *http.Request) string {
+	if name, ok := req.Context().Value(namespaceContextKey{}).(string); ok {
+		return name
+	}
+	if proxy.namespaces != nil {
+		return proxy.namespaces.Default
+	}
+	return ""
+}
+
+// namespaceTTL is the TTL configured for the namespace, zero when it uses the proxy one.
+func (proxy *CacheableProxy) namespaceTTL(name string) time.Duration {
+	if proxy.namespaces == nil || name == "" {
+		return 0
+	}
+	return proxy.namespaces.Namespaces[name].TTL
+}
+
+// parentEntry looks the request up on the parents of its namespace, returning the first accepted entry.
+func (proxy *CacheableProxy) parentEntry(
+	state *requestState, accept func(info FileInformation) bool,
+) (FileInformation, bool) {
+	if proxy.namespaces == nil || state.namespace == "" || state.key == "" {
+		return FileInformation{}, false
+	}
+	baseKey := strings.TrimPrefix(state.key, NamespaceKeyPrefix(state.namespace))
+	for current := proxy.namespaces.Namespaces[state.namespace]; current.Fallthrough; {
+		parent := current.Parent
+		info, err := proxy.storage.Get(NamespaceKeyPrefix(parent) + baseKey)
+		if err == nil && !isStreamRecording(info) && accept(info) {
+			state.servedNamespace = parent
+			return info, true
+		}
+		if parent == "" {
+			break
+		}
+		current = proxy.namespaces.Namespaces[parent]
+	}
+	return FileInformation{}, false
+}
+
+// PurgeNamespace removes every entry of the namespace, from every target sharing the storage.
+// The storage must implement PrefixDeleter.
+func (proxy *CacheableProxy) PurgeNamespace(name string) (int, error) {
+	if !ValidNamespace(name) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+	}
+	deleter, ok := proxy.storage.(PrefixDeleter)
+	if !ok {
+		return 0, ErrPurgeUnsupported
+	}
+	return deleter.DeletePrefix(NamespaceKeyPrefix(name))
+}
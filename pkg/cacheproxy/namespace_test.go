@@ -0,0 +1,129 @@
+package cacheproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheableProxy_NamespaceSelection(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if r.Header.Get(HeaderCacheNamespace) != "" || strings.HasPrefix(r.URL.Path, "/_ns/") {
+			t.Errorf("Expected upstream to never see the namespace, got %s %v", r.URL.Path, r.Header)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+	}, WithNamespaces(NamespacePolicy{Default: "main", PathPrefix: "/_ns/"}))
+
+	testCases := []struct {
+		name        string
+		path        string
+		header      string
+		expectedKey string
+		status      int
+	}{
+		{name: "default", path: "/page", expectedKey: "ns:main|"},
+		{name: "header", path: "/page", header: "login-a", expectedKey: "ns:login-a|"},
+		{name: "path prefix", path: "/_ns/variant-b/page", expectedKey: "ns:variant-b|"},
+		{name: "header over path", path: "/_ns/variant-b/page", header: "login-a", expectedKey: "ns:login-a|"},
+		{name: "invalid", path: "/page", header: "../other", status: http.StatusBadRequest},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tCase.path, nil)
+			if tCase.header != "" {
+				req.Header.Set(HeaderCacheNamespace, tCase.header)
+			}
+			recorder := serveProxy(proxy, req)
+			if tCase.status != 0 {
+				if recorder.Code != tCase.status {
+					t.Errorf("Expected status %d, got %d", tCase.status, recorder.Code)
+				}
+				return
+			}
+			if body := recorder.Body.String(); body != "<html>/page</html>" {
+				t.Errorf("Expected the page without the namespace prefix, got `%s`", body)
+			}
+			key := tCase.expectedKey + proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/page", nil))
+			if _, err := storage.Get(key); err != nil {
+				t.Errorf("Expected the response stored under %s", key)
+			}
+		})
+	}
+	if upstreamCalls.Load() != 3 {
+		t.Errorf("Expected each namespace to be fetched once, got %d calls", upstreamCalls.Load())
+	}
+}
+
+func TestCacheableProxy_NamespaceFallthroughAndTTL(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html>live</html>"))
+	}, WithNamespaces(NamespacePolicy{Namespaces: map[string]Namespace{
+		"snapshot":   {TTL: time.Minute},
+		"experiment": {Parent: "snapshot", Fallthrough: true},
+	}}))
+
+	baseKey := proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/page", nil))
+	_ = storage.Set(NamespaceKeyPrefix("snapshot")+baseKey, FileInformation{
+		Envelope:      FileEnvelope{Status: http.StatusOK},
+		Content:       []byte("<html>snapshot</html>"),
+		Checksum:      checksum([]byte("<html>snapshot</html>")),
+		ModifiedAt:    time.Now().Add(-30 * time.Second),
+		ExtraMetadata: map[string]string{MetadataNamespace: "snapshot"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set(HeaderCacheNamespace, "experiment")
+	recorder := serveProxy(proxy, req)
+	if recorder.Body.String() != "<html>snapshot</html>" || recorder.Header().Get(HeaderCache) != "HIT" {
+		t.Errorf("Expected the parent entry to be served, got %s `%s`", recorder.Header(), recorder.Body)
+	}
+	if served := recorder.Header().Get(HeaderCacheNamespace); served != "snapshot" || upstreamCalls.Load() != 0 {
+		t.Errorf("Expected the snapshot namespace without upstream calls, got %q and %d", served, upstreamCalls.Load())
+	}
+
+	// Older than the namespace TTL, the snapshot expires and the experiment gets its own copy
+	snapshot, _ := storage.Get(NamespaceKeyPrefix("snapshot") + baseKey)
+	snapshot.ModifiedAt = time.Now().Add(-2 * time.Minute)
+	_ = storage.Set(NamespaceKeyPrefix("snapshot")+baseKey, snapshot)
+	req = httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set(HeaderCacheNamespace, "experiment")
+	if recorder = serveProxy(proxy, req); recorder.Body.String() != "<html>live</html>" {
+		t.Errorf("Expected the expired parent to be skipped, got `%s`", recorder.Body)
+	}
+	if _, err := storage.Get(NamespaceKeyPrefix("experiment") + baseKey); err != nil {
+		t.Errorf("Expected the experiment namespace to store the response")
+	}
+
+	removed, err := proxy.PurgeNamespace("experiment")
+	if err != nil || removed != 1 {
+		t.Errorf("Expected a single entry purged, got %d and %v", removed, err)
+	}
+	if _, err = storage.Get(NamespaceKeyPrefix("snapshot") + baseKey); err != nil {
+		t.Errorf("Expected the purge to keep the other namespaces")
+	}
+}
+
+func TestNew_InvalidNamespaces(t *testing.T) {
+	policies := []NamespacePolicy{
+		{Default: "with space"},
+		{PathPrefix: "_ns"},
+		{Namespaces: map[string]Namespace{
+			"a": {Parent: "b", Fallthrough: true},
+			"b": {Parent: "a", Fallthrough: true},
+		}},
+	}
+	for _, policy := range policies {
+		if _, err := New(newMemoryStorage(), "http://example.com", 0, WithNamespaces(policy)); err == nil {
+			t.Errorf("Expected %+v to be rejected", policy)
+		}
+	}
+}
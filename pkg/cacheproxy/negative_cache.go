@@ -0,0 +1,137 @@
+package cacheproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeaderCacheError tells clients the response is a known failure, cached or not.
+const HeaderCacheError = "X-Cache-Error"
+
+// Classes of upstream errors, as sent on the HeaderCacheError header
+const (
+	UpstreamErrorDNS      = "dns"
+	UpstreamErrorTimeout  = "timeout"
+	UpstreamErrorRefused  = "connection-refused"
+	UpstreamErrorReset    = "connection-reset"
+	UpstreamErrorTLS      = "tls"
+	UpstreamErrorCanceled = "canceled"
+	UpstreamErrorCircuit  = "circuit-open"
+	UpstreamErrorUnknown  = "upstream"
+)
+
+// WithNegativeCache caches error responses (404, 410 and 5xx) and upstream transport
+// failures for the given TTL. Without it, those responses are never stored.
+func WithNegativeCache(ttl time.Duration) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.negativeTTL = ttl
+	}
+}
+
+func isNegativeStatus(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone ||
+		(status >= http.StatusInternalServerError && status <= 599)
+}
+
+func statusErrorClass(status int) string {
+	return "status-" + strconv.Itoa(status)
+}
+
+// ClassifyUpstreamError names the kind of failure that happened while reaching upstream.
+func ClassifyUpstreamError(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		certErr    *tls.CertificateVerificationError
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		recordErr  tls.RecordHeaderError
+		invalidErr x509.CertificateInvalidError
+	)
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return UpstreamErrorCircuit
+	case errors.As(err, &dnsErr):
+		return UpstreamErrorDNS
+	case errors.Is(err, context.Canceled):
+		return UpstreamErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return UpstreamErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return UpstreamErrorRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return UpstreamErrorReset
+	case errors.As(err, &certErr), errors.As(err, &authErr), errors.As(err, &hostErr),
+		errors.As(err, &recordErr), errors.As(err, &invalidErr):
+		return UpstreamErrorTLS
+	}
+	return UpstreamErrorUnknown
+}
+
+// storeNegative stores an upstream failure, unless the key already holds a page:
+// a transient failure must not replace a copy that can still be served stale.
+func (proxy *CacheableProxy) storeNegative(key string, info FileInformation) error {
+	if stored, err := proxy.storage.Get(key); err == nil && canServeStale(stored) {
+		return nil
+	}
+	return proxy.store(key, info)
+}
+
+// handleUpstreamError is used as the reverse proxy ErrorHandler, answering with a
+// synthetic response that is also stored when negative caching is enabled.
+func (proxy *CacheableProxy) handleUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	state := proxy.stateOf(r)
+	state.upstreamResponded()
+	errorClass := ClassifyUpstreamError(err)
+	recordSpanError(trace.SpanFromContext(r.Context()), err)
+	slog.Error(
+		"[ PROXY SERVER ] Upstream request failed",
+		slog.String("URL", r.URL.String()),
+		slog.String("class", errorClass),
+		slog.String("error", err.Error()),
+	)
+
+	status := http.StatusBadGateway
+	switch errorClass {
+	case UpstreamErrorTimeout:
+		status = http.StatusGatewayTimeout
+	case UpstreamErrorCircuit:
+		status = http.StatusServiceUnavailable
+	}
+
+	// Canceled requests and open circuits say nothing about the requested URL
+	cacheFailure := errorClass != UpstreamErrorCanceled && errorClass != UpstreamErrorCircuit
+	if cacheFailure && state.cacheable && proxy.negativeTTL > 0 {
+		now := time.Now()
+		fileInfo := FileInformation{
+			FileMIME: FileMIME{Name: r.RequestURI},
+			Envelope: FileEnvelope{
+				Headers: map[string][]string{HeaderCacheError: {errorClass}},
+				Status:  uint16(status),
+			},
+			Checksum:      checksum(nil),
+			CreatedAt:     now,
+			ModifiedAt:    now,
+			ExtraMetadata: map[string]string{MetadataCacheError: errorClass},
+		}
+		setExpiration(&fileInfo, now.Add(proxy.negativeTTL))
+		if storeErr := proxy.storeNegative(state.key, fileInfo); storeErr != nil {
+			slog.Error("[ PROXY SERVER ] Error storing upstream failure", slog.String("error", storeErr.Error()))
+		}
+	}
+
+	w.Header().Set(HeaderCacheError, errorClass)
+	w.WriteHeader(status)
+}
@@ -0,0 +1,160 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "DNS", err: &net.DNSError{Err: "no such host", Name: "x.invalid"}, expected: UpstreamErrorDNS},
+		{name: "Deadline", err: fmt.Errorf("dial: %w", context.DeadlineExceeded), expected: UpstreamErrorTimeout},
+		{name: "Net timeout", err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, expected: UpstreamErrorTimeout},
+		{
+			name:     "Connection refused",
+			err:      &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			expected: UpstreamErrorRefused,
+		},
+		{
+			name:     "Connection reset",
+			err:      &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			expected: UpstreamErrorReset,
+		},
+		{name: "Canceled", err: context.Canceled, expected: UpstreamErrorCanceled},
+		{name: "Unknown", err: errors.New("boom"), expected: UpstreamErrorUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := ClassifyUpstreamError(tt.err); result != tt.expected {
+				t.Errorf("Expected class %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_NegativeStatus(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	tests := []struct {
+		name          string
+		options       []ProxyOption
+		expectedCalls int32
+	}{
+		{name: "Disabled negative cache", expectedCalls: 2},
+		{name: "Enabled negative cache", options: []ProxyOption{WithNegativeCache(time.Minute)}, expectedCalls: 1},
+		{name: "Expired negative entry", options: []ProxyOption{WithNegativeCache(time.Nanosecond)}, expectedCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamCalls.Store(0)
+			proxy, _ := newTestProxy(t, upstream, tt.options...)
+			for range 2 {
+				recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/missing", nil))
+				if recorder.Code != http.StatusNotFound {
+					t.Errorf("Expected status %d, got %d", http.StatusNotFound, recorder.Code)
+				}
+				if header := recorder.Header().Get(HeaderCacheError); header != "status-404" {
+					t.Errorf("Expected %s header `status-404`, got `%s`", HeaderCacheError, header)
+				}
+			}
+
+			if calls := upstreamCalls.Load(); calls != tt.expectedCalls {
+				t.Errorf("Expected %d upstream calls, got %d", tt.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_NegativeTransportError(t *testing.T) {
+	// Reserve an address and release it, so connections to it are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetURL := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	storage := newMemoryStorage()
+	var proxy *CacheableProxy
+	if proxy, err = New(storage, targetURL, 0, WithNegativeCache(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+		if recorder.Code != http.StatusBadGateway {
+			t.Errorf("Expected status %d, got %d", http.StatusBadGateway, recorder.Code)
+		}
+		if header := recorder.Header().Get(HeaderCacheError); header != UpstreamErrorRefused {
+			t.Errorf("Expected %s header `%s`, got `%s`", HeaderCacheError, UpstreamErrorRefused, header)
+		}
+	}
+
+	if storage.Len() != 1 {
+		t.Errorf("Expected the failure to be stored, got %d entries", storage.Len())
+	}
+}
+
+func TestCacheableProxy_NegativeKeepsPage(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	proxy, storage := newTestProxy(t, upstream, WithNegativeCache(time.Minute))
+
+	// Store an expired copy of the page, then fail to refresh it
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	expired := time.Now().Add(-48 * time.Hour)
+	_ = storage.Set(proxy.cacheKey(req), FileInformation{
+		Envelope: FileEnvelope{Status: http.StatusOK}, Content: []byte("page"),
+		Checksum: checksum([]byte("page")), CreatedAt: expired, ModifiedAt: expired,
+	})
+	if recorder := serveProxy(proxy, req); recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the upstream status, got %d", recorder.Code)
+	}
+
+	stored, err := storage.Get(proxy.cacheKey(req))
+	if err != nil || stored.Envelope.Status != http.StatusOK || isNegative(stored) {
+		t.Errorf("Expected the page to be kept, got %d %v", stored.Envelope.Status, stored.ExtraMetadata)
+	}
+}
+
+func TestCacheableProxy_OfflineSkipsExpiredNegative(t *testing.T) {
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {}, WithOfflineMode())
+
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	expired := time.Now().Add(-time.Hour)
+	fileInfo := FileInformation{
+		Envelope:      FileEnvelope{Status: http.StatusBadGateway},
+		Checksum:      checksum(nil),
+		CreatedAt:     expired,
+		ModifiedAt:    expired,
+		ExtraMetadata: map[string]string{MetadataCacheError: UpstreamErrorRefused},
+	}
+	setExpiration(&fileInfo, expired.Add(time.Minute))
+	_ = storage.Set(proxy.cacheKey(req), fileInfo)
+
+	recorder := serveProxy(proxy, req)
+	if recorder.Code != http.StatusGatewayTimeout || recorder.Header().Get("Warning") != "" {
+		t.Errorf("Expected a miss instead of the stale failure, got %d %v", recorder.Code, recorder.Header())
+	}
+}
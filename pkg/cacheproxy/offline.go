@@ -0,0 +1,226 @@
+package cacheproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithOfflineMode serves every request from the cache, never contacting upstream.
+// Expired entries are served as STALE, streams recorded with WithStreamRecording are
+// replayed, and requests missing from the cache fail with 504 Gateway Timeout.
+func WithOfflineMode() ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.offline = true
+	}
+}
+
+// serveOffline answers from the cache only, failing the misses with the reason.
+func (proxy *CacheableProxy) serveOffline(
+	w http.ResponseWriter, r *http.Request, state *requestState, reason string,
+) {
+	state.status = CacheMiss
+	if state.key == "" {
+		http.Error(w, reason, http.StatusGatewayTimeout)
+		return
+	}
+	now := time.Now()
+	servable := func(info FileInformation) bool {
+		return proxy.isFresh(info, now) || canServeStale(info)
+	}
+	fileInfo, err := proxy.storage.Get(state.key)
+	if err != nil || !servable(fileInfo) {
+		var found bool
+		fileInfo, found = proxy.parentEntry(state, servable)
+		if !found {
+			http.Error(w, reason, http.StatusGatewayTimeout)
+			return
+		}
+	}
+
+	if !isStreamRecording(fileInfo) {
+		state.status = CacheHit
+		if !proxy.isFresh(fileInfo, now) {
+			state.status = CacheStale
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+		proxy.writeCached(w, state, fileInfo, now)
+		return
+	}
+
+	var frames []streamFrame
+	if err = json.Unmarshal(fileInfo.Content, &frames); err != nil {
+		http.Error(w, "stream recording is corrupted", http.StatusInternalServerError)
+		return
+	}
+	kind := streamKind(fileInfo.ExtraMetadata[MetadataStream])
+	switch {
+	case kind == streamEvents:
+		state.status = CacheHit
+		replayEvents(w, r, fileInfo, frames)
+	case kind == streamWebsocket && state.stream == streamWebsocket:
+		state.status = CacheHit
+		replayWebsocket(w, r, fileInfo, frames)
+	default:
+		http.Error(w, reason, http.StatusGatewayTimeout)
+	}
+}
+
+// replayEvents sends the recorded events with the same delays seen while recording.
+func replayEvents(w http.ResponseWriter, r *http.Request, fileInfo FileInformation, frames []streamFrame) {
+	for key, values := range fileInfo.Envelope.Headers {
+		if !strings.EqualFold(key, "Content-Length") && !isRedacted(values) {
+			w.Header().Set(key, strings.Join(values, ","))
+		}
+	}
+	w.WriteHeader(int(fileInfo.Envelope.Status))
+	controller := http.NewResponseController(w)
+	_ = controller.Flush()
+
+	start := time.Now()
+	for _, frame := range frames {
+		if !waitUntil(r.Context(), nil, start.Add(frame.Offset)) {
+			return
+		}
+		if _, err := w.Write(append(frame.Data, "\n\n"...)); err != nil {
+			return
+		}
+		_ = controller.Flush()
+	}
+}
+
+// replayWebsocket accepts the upgrade itself and sends the recorded server messages.
+// A message that followed client messages while recording is only sent once the same
+// amount of client messages arrived, keeping the delay it had after the last of them.
+func replayWebsocket(w http.ResponseWriter, r *http.Request, fileInfo FileInformation, frames []streamFrame) {
+	clientKey := r.Header.Get("Sec-WebSocket-Key")
+	if clientKey == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	_, _ = fmt.Fprintf(
+		buffered, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n"+
+			"Connection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n", websocketAcceptKey(clientKey),
+	)
+	if protocol := http.Header(fileInfo.Envelope.Headers).Get("Sec-WebSocket-Protocol"); protocol != "" {
+		_, _ = fmt.Fprintf(buffered, "Sec-WebSocket-Protocol: %s\r\n", protocol)
+	}
+	_, _ = buffered.WriteString("\r\n")
+	if err = buffered.Flush(); err != nil {
+		return
+	}
+
+	var (
+		mutex    sync.Mutex
+		start    = time.Now()
+		arrivals = []time.Time{start} // arrivals[n] is when the n-th client message arrived
+		changed  = make(chan struct{}, 1)
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		reader := wsMessageReader{maxBuffer: 1 << 20}
+		chunk := make([]byte, 4096)
+		for {
+			read, readErr := buffered.Read(chunk)
+			for _, message := range reader.feed(chunk[:read]) {
+				switch message.opcode {
+				case wsOpClose:
+					return
+				case wsOpText, wsOpBinary:
+					mutex.Lock()
+					arrivals = append(arrivals, time.Now())
+					mutex.Unlock()
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+			if readErr != nil {
+				return
+			}
+		}
+	}()
+
+	recordedClient := []time.Duration{0}
+	for _, frame := range frames {
+		if frame.FromClient {
+			recordedClient = append(recordedClient, frame.Offset)
+		}
+	}
+	for _, frame := range frames {
+		if frame.FromClient {
+			continue
+		}
+		after := min(frame.AfterClientFrames, len(recordedClient)-1)
+		var arrival time.Time
+		for {
+			mutex.Lock()
+			if after < len(arrivals) {
+				arrival = arrivals[after]
+			}
+			mutex.Unlock()
+			if !arrival.IsZero() {
+				break
+			}
+			select {
+			case <-changed:
+			case <-done:
+				_ = writeWebsocketFrame(conn, wsOpClose, nil)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if !waitUntil(r.Context(), done, arrival.Add(frame.Offset-recordedClient[after])) {
+			break
+		}
+		opcode := wsOpText
+		if frame.Binary {
+			opcode = wsOpBinary
+		}
+		if err = writeWebsocketFrame(conn, opcode, frame.Data); err != nil {
+			slog.Debug("[ PROXY SERVER ] WebSocket replay stopped", slog.String("error", err.Error()))
+			return
+		}
+	}
+
+	// Keep the connection open until the client leaves, as upstream may have done
+	select {
+	case <-done:
+	case <-r.Context().Done():
+	}
+	_ = writeWebsocketFrame(conn, wsOpClose, nil)
+}
+
+// waitUntil sleeps until the given moment, reporting false when ctx or stop ended first.
+func waitUntil(ctx context.Context, stop <-chan struct{}, moment time.Time) bool {
+	wait := time.Until(moment)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,292 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OverflowBehavior defines what happens to a request when the upstream queue is full.
+type OverflowBehavior uint8
+
+const (
+	// OverflowWait keeps the request waiting until the upstream host has capacity
+	OverflowWait OverflowBehavior = iota
+	// OverflowTooManyRequests answers with 429 and a Retry-After header
+	OverflowTooManyRequests
+	// OverflowUnavailable answers with 503 and a Retry-After header
+	OverflowUnavailable
+)
+
+const (
+	// defaultUpstreamPause is used when upstream asks to slow down without a Retry-After
+	defaultUpstreamPause = time.Second
+	// defaultMaxUpstreamPause bounds the Retry-After honored when the policy sets no MaxPause
+	defaultMaxUpstreamPause = 5 * time.Minute
+)
+
+var ErrUpstreamBusy = errors.New("upstream host is over its politeness limits")
+
+type (
+	// PolitenessPolicy limits the traffic sent to each upstream host.
+	// Only requests that reach upstream are limited, cache hits are always served.
+	PolitenessPolicy struct {
+		RequestsPerSecond float64 // Zero disables the rate limit
+		Burst             int
+		MaxInFlight       int // Zero means unlimited
+		MaxQueue          int // Requests allowed to wait before the overflow behavior applies
+		Overflow          OverflowBehavior
+		MaxPause          time.Duration // Longer Retry-After values are clamped to it
+	}
+	upstreamLimiter struct {
+		policy PolitenessPolicy
+		mutex  sync.Mutex
+		hosts  map[string]*hostLimiter
+	}
+	hostLimiter struct {
+		mutex       sync.Mutex
+		rate        float64
+		burst       float64
+		maxInFlight int
+		tokens      float64
+		lastRefill  time.Time
+		pausedUntil time.Time
+		inFlight    int
+		waiting     int
+		changed     chan struct{}
+	}
+)
+
+// WithPoliteness limits the requests forwarded to upstream hosts.
+func WithPoliteness(policy PolitenessPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.limiter = newUpstreamLimiter(policy)
+	}
+}
+
+func newUpstreamLimiter(policy PolitenessPolicy) *upstreamLimiter {
+	if policy.MaxPause <= 0 {
+		policy.MaxPause = defaultMaxUpstreamPause
+	}
+	return &upstreamLimiter{policy: policy, hosts: make(map[string]*hostLimiter)}
+}
+
+func (limiter *upstreamLimiter) host(name string) *hostLimiter {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if host, ok := limiter.hosts[name]; ok {
+		return host
+	}
+	host := &hostLimiter{
+		rate:        limiter.policy.RequestsPerSecond,
+		burst:       math.Max(float64(limiter.policy.Burst), 1),
+		maxInFlight: limiter.policy.MaxInFlight,
+		changed:     make(chan struct{}),
+	}
+	host.tokens = host.burst
+	limiter.hosts[name] = host
+	return host
+}
+
+// Acquire waits until the host accepts a new request, returning the function that must
+// be called once the request finishes. When the request is rejected, ErrUpstreamBusy is
+// returned along with how long the client should wait before retrying.
+func (limiter *upstreamLimiter) Acquire(
+	ctx context.Context, hostName string,
+) (release func(), retryAfter time.Duration, err error) {
+	host := limiter.host(hostName)
+	host.mutex.Lock()
+	acquired, wait, changed := host.tryAcquire(time.Now(), true)
+	if acquired {
+		host.mutex.Unlock()
+		return host.release, 0, nil
+	}
+	if limiter.policy.Overflow != OverflowWait && host.waiting >= limiter.policy.MaxQueue {
+		host.mutex.Unlock()
+		return nil, wait, ErrUpstreamBusy
+	}
+	host.waiting++
+	host.mutex.Unlock()
+
+	defer func() {
+		host.mutex.Lock()
+		host.waiting--
+		host.mutex.Unlock()
+	}()
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		host.mutex.Lock()
+		acquired, wait, changed = host.tryAcquire(time.Now(), true)
+		host.mutex.Unlock()
+		if acquired {
+			return host.release, 0, nil
+		}
+	}
+}
+
+// Wait blocks until the host rate limit and pause allow one more request, for callers
+// already holding an in-flight slot, such as the retries of a forwarded request.
+func (limiter *upstreamLimiter) Wait(ctx context.Context, hostName string) error {
+	host := limiter.host(hostName)
+	for {
+		host.mutex.Lock()
+		acquired, wait, changed := host.tryAcquire(time.Now(), false)
+		host.mutex.Unlock()
+		if acquired {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryAcquire must be called with the mutex held. When it fails, it reports how long to
+// wait before trying again, and a channel closed whenever the host state changes.
+// Only a request taking an in-flight slot is limited by MaxInFlight.
+func (host *hostLimiter) tryAcquire(now time.Time, inFlight bool) (bool, time.Duration, <-chan struct{}) {
+	if now.Before(host.pausedUntil) {
+		return false, host.pausedUntil.Sub(now), host.changed
+	}
+	if inFlight && host.maxInFlight > 0 && host.inFlight >= host.maxInFlight {
+		return false, time.Second, host.changed
+	}
+
+	if host.rate > 0 {
+		if !host.lastRefill.IsZero() {
+			elapsed := now.Sub(host.lastRefill).Seconds()
+			host.tokens = math.Min(host.burst, host.tokens+elapsed*host.rate)
+		}
+		host.lastRefill = now
+		if host.tokens < 1 {
+			missing := (1 - host.tokens) / host.rate
+			return false, time.Duration(missing * float64(time.Second)), host.changed
+		}
+		host.tokens--
+	}
+
+	if inFlight {
+		host.inFlight++
+	}
+	return true, 0, nil
+}
+
+func (host *hostLimiter) release() {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	host.inFlight--
+	host.notify()
+}
+
+// limitInterval keeps at least the given interval between requests sent to the host.
+func (host *hostLimiter) limitInterval(interval time.Duration) {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	if rate := 1 / interval.Seconds(); host.rate <= 0 || rate < host.rate {
+		host.rate, host.burst = rate, 1
+		host.tokens = math.Min(host.tokens, host.burst)
+	}
+}
+
+// pause stops sending requests to the host until the given duration has passed.
+func (host *hostLimiter) pause(duration time.Duration) {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	if until := time.Now().Add(duration); until.After(host.pausedUntil) {
+		host.pausedUntil = until
+		host.notify()
+	}
+}
+
+func (host *hostLimiter) notify() {
+	close(host.changed)
+	host.changed = make(chan struct{})
+}
+
+func (host *hostLimiter) waitingCount() int {
+	host.mutex.Lock()
+	defer host.mutex.Unlock()
+	return host.waiting
+}
+
+// observeUpstreamStatus pauses the upstream host when it asks clients to slow down.
+func (proxy *CacheableProxy) observeUpstreamStatus(resp *http.Response) {
+	if proxy.limiter == nil || resp.Request == nil ||
+		(resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return
+	}
+
+	pauseFor, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		pauseFor = defaultUpstreamPause
+	}
+	pauseFor = min(pauseFor, proxy.limiter.policy.MaxPause)
+	proxy.limiter.host(resp.Request.URL.Host).pause(pauseFor)
+}
+
+// acquireUpstream applies the politeness limits before the request is forwarded upstream.
+// When the request cannot proceed, the overflow response is written and ok is false.
+func (proxy *CacheableProxy) acquireUpstream(
+	w http.ResponseWriter, r *http.Request,
+) (release func(), ok bool) {
+	if proxy.limiter == nil {
+		return func() {}, true
+	}
+
+	release, retryAfter, err := proxy.limiter.Acquire(r.Context(), proxy.targetURL.Host)
+	if err == nil {
+		return release, true
+	}
+	// The client gave up while queued, so nobody is waiting for the overflow response
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, false
+	}
+
+	status := http.StatusServiceUnavailable
+	if proxy.limiter.policy.Overflow == OverflowTooManyRequests {
+		status = http.StatusTooManyRequests
+	}
+	if retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	w.WriteHeader(status)
+	return nil, false
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Huge values would overflow the duration
+		seconds = min(max(seconds, 0), math.MaxInt64/int64(time.Second))
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
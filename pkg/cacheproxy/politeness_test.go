@@ -0,0 +1,182 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.October, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "Empty", value: "", ok: false},
+		{name: "Seconds", value: "120", expected: 2 * time.Minute, ok: true},
+		{
+			name:     "Overflowing seconds",
+			value:    "99999999999999999",
+			expected: time.Duration(math.MaxInt64/int64(time.Second)) * time.Second,
+			ok:       true,
+		},
+		{name: "Negative seconds", value: "-3", expected: 0, ok: true},
+		{name: "HTTP date", value: "Thu, 10 Oct 2024 12:00:30 GMT", expected: 30 * time.Second, ok: true},
+		{name: "Past HTTP date", value: "Thu, 10 Oct 2024 11:00:00 GMT", expected: 0, ok: true},
+		{name: "Invalid", value: "soon", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.ok || result != tt.expected {
+				t.Errorf("Expected (%v, %v), got (%v, %v)", tt.expected, tt.ok, result, ok)
+			}
+		})
+	}
+}
+
+func TestUpstreamLimiter_RateLimit(t *testing.T) {
+	limiter := newUpstreamLimiter(PolitenessPolicy{RequestsPerSecond: 20, Burst: 1})
+
+	start := time.Now()
+	for range 3 {
+		release, _, err := limiter.Acquire(context.Background(), "example.com")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		release()
+	}
+
+	// The first request uses the burst, the other two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected requests to be spaced, but took only %v", elapsed)
+	}
+}
+
+func TestUpstreamLimiter_Overflow(t *testing.T) {
+	limiter := newUpstreamLimiter(PolitenessPolicy{
+		MaxInFlight: 1, MaxQueue: 1, Overflow: OverflowTooManyRequests,
+	})
+	ctx := context.Background()
+
+	release, _, err := limiter.Acquire(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("Expected first request to be accepted, got %v", err)
+	}
+
+	// The second request waits on the queue, until the first one finishes
+	queued := make(chan error, 1)
+	go func() {
+		queuedRelease, _, queuedErr := limiter.Acquire(ctx, "example.com")
+		if queuedErr == nil {
+			queuedRelease()
+		}
+		queued <- queuedErr
+	}()
+	for limiter.host("example.com").waitingCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Queue is full, so the third request must be rejected
+	var retryAfter time.Duration
+	if _, retryAfter, err = limiter.Acquire(ctx, "example.com"); !errors.Is(err, ErrUpstreamBusy) {
+		t.Errorf("Expected ErrUpstreamBusy, got %v", err)
+	}
+	if retryAfter <= 0 {
+		t.Errorf("Expected a positive retry after, got %v", retryAfter)
+	}
+
+	// Other hosts are not affected
+	var otherRelease func()
+	if otherRelease, _, err = limiter.Acquire(ctx, "other.com"); err != nil {
+		t.Errorf("Expected request to another host to be accepted, got %v", err)
+	} else {
+		otherRelease()
+	}
+
+	release()
+	select {
+	case err = <-queued:
+		if err != nil {
+			t.Errorf("Expected queued request to be accepted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Queued request was not released")
+	}
+}
+
+func TestCacheableProxy_PolitenessCanceledWhileQueued(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the canceled request to not reach upstream")
+	}, WithPoliteness(PolitenessPolicy{MaxInFlight: 1, MaxQueue: 1, Overflow: OverflowUnavailable}))
+
+	release, _, err := proxy.limiter.Acquire(context.Background(), proxy.targetURL.Host)
+	if err != nil {
+		t.Fatalf("Failed to take the in-flight slot: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil).WithContext(ctx))
+	if recorder.Code == http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no overflow response for the canceled client, got %d %v", recorder.Code, recorder.Header())
+	}
+}
+
+func TestCacheableProxy_PolitenessAdaptsToUpstream(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	}
+	proxy, _ := newTestProxy(t, upstream, WithPoliteness(PolitenessPolicy{Overflow: OverflowUnavailable}))
+
+	// Cache a page before upstream starts to refuse requests
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/busy", nil)); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", recorder.Code)
+	}
+
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while upstream is paused, got %d", recorder.Code)
+	}
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", retryAfter)
+	}
+
+	// Cache hits are still served
+	if recorder = serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected cached page to be served, got status %d", recorder.Code)
+	}
+}
+
+func TestCacheableProxy_PolitenessClampsPause(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	proxy, _ := newTestProxy(t, upstream, WithPoliteness(PolitenessPolicy{
+		Overflow: OverflowUnavailable, MaxPause: 2 * time.Second,
+	}))
+
+	_ = serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/busy", nil))
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected the pause to be clamped to 2 seconds, got %q", retryAfter)
+	}
+}
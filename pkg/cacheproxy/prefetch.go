@@ -0,0 +1,285 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultPrefetchConcurrency = 2
+	defaultPrefetchQueueSize   = 256
+	defaultPrefetchPerPage     = 64
+)
+
+// prefetchedRelations are the link relations naming a subresource of the page
+var prefetchedRelations = []string{"stylesheet", "icon", "shortcut", "apple-touch-icon", "preload", "modulepreload"}
+
+type (
+	// PrefetchPolicy fetches the stylesheets, scripts and images referenced by the HTML pages
+	// stored in cache, so a page replayed offline is complete even when only its HTML was requested.
+	PrefetchPolicy struct {
+		// AllowedHosts are other hosts whose assets are prefetched too, like a static subdomain,
+		// each one through the proxy ProxyFor returns, so it is fetched and cached under that host
+		AllowedHosts []string
+		// ProxyFor returns the proxy serving an allowed host, the assets of the host are skipped when it is nil
+		ProxyFor func(host string) *CacheableProxy
+		// Concurrency is the amount of assets fetched at the same time, the politeness limits still apply
+		Concurrency int
+		// QueueSize is the amount of assets waiting to be fetched, the new ones are dropped once it is full
+		QueueSize int
+		// MaxAssetsPerPage limits the assets taken from a single page
+		MaxAssetsPerPage int
+	}
+	// prefetcher runs the background workers fetching the assets through the proxy Handler.
+	prefetcher struct {
+		policy  PrefetchPolicy
+		queue   chan prefetchJob
+		ctx     context.Context
+		cancel  context.CancelFunc
+		workers sync.WaitGroup
+		// mutex guards started and pending, and orders the workers startup before stop waits for them
+		mutex   sync.Mutex
+		started bool
+		// pending holds the jobs queued or running, so an asset shared by pages is fetched once
+		pending map[prefetchJob]struct{}
+	}
+	prefetchJob struct {
+		// proxy serves the host of the asset
+		proxy      *CacheableProxy
+		requestURI string
+		namespace  string
+	}
+	// prefetchContextKey marks the requests made by the prefetcher, whose pages are not prefetched again
+	prefetchContextKey struct{}
+)
+
+// WithPrefetch fetches in background the same-origin assets of every HTML page stored in cache.
+func WithPrefetch(policy PrefetchPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		if policy.Concurrency <= 0 {
+			policy.Concurrency = defaultPrefetchConcurrency
+		}
+		if policy.QueueSize <= 0 {
+			policy.QueueSize = defaultPrefetchQueueSize
+		}
+		if policy.MaxAssetsPerPage <= 0 {
+			policy.MaxAssetsPerPage = defaultPrefetchPerPage
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		proxy.prefetcher = &prefetcher{
+			policy:  policy,
+			queue:   make(chan prefetchJob, policy.QueueSize),
+			ctx:     ctx,
+			cancel:  cancel,
+			pending: make(map[prefetchJob]struct{}),
+		}
+	}
+}
+
+// schedulePrefetch queues the assets of the HTML page that was just stored.
+func (proxy *CacheableProxy) schedulePrefetch(resp *http.Response, info FileInformation, namespace string) {
+	if proxy.prefetcher == nil || resp.StatusCode != http.StatusOK || !isHTMLType(info.MimeType) {
+		return
+	}
+	if prefetched, _ := resp.Request.Context().Value(prefetchContextKey{}).(bool); prefetched {
+		return
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	content, ok := decodeBody(encoding, info.Content)
+	if !ok {
+		return
+	}
+
+	assets := pageAssets(content, resp.Request.URL)
+	if len(assets) > proxy.prefetcher.policy.MaxAssetsPerPage {
+		assets = assets[:proxy.prefetcher.policy.MaxAssetsPerPage]
+	}
+	for _, asset := range assets {
+		if hostProxy := proxy.prefetchProxy(asset.Host); hostProxy != nil {
+			proxy.prefetcher.enqueue(prefetchJob{
+				proxy: hostProxy, requestURI: asset.RequestURI(), namespace: namespace,
+			})
+		}
+	}
+}
+
+// prefetchProxy returns the proxy serving the assets of the host, nil when they are not prefetched.
+func (proxy *CacheableProxy) prefetchProxy(host string) *CacheableProxy {
+	if strings.EqualFold(host, proxy.targetURL.Host) {
+		return proxy
+	}
+	policy := proxy.prefetcher.policy
+	allowed := slices.ContainsFunc(policy.AllowedHosts, func(allowed string) bool {
+		return strings.EqualFold(host, allowed)
+	})
+	if !allowed || policy.ProxyFor == nil {
+		return nil
+	}
+	return policy.ProxyFor(host)
+}
+
+// enqueue adds the job unless it is already pending, starting the workers on the first call.
+func (pf *prefetcher) enqueue(job prefetchJob) {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+	if _, ok := pf.pending[job]; ok || pf.ctx.Err() != nil {
+		return
+	}
+	if !pf.started {
+		pf.started = true
+		for range pf.policy.Concurrency {
+			pf.workers.Add(1)
+			go pf.work()
+		}
+	}
+	select {
+	case pf.queue <- job:
+		pf.pending[job] = struct{}{}
+	default:
+		slog.Debug("[ PROXY SERVER ] Prefetch queue is full, dropping asset", slog.String("uri", job.requestURI))
+	}
+}
+
+func (pf *prefetcher) work() {
+	defer pf.workers.Done()
+	ctx := context.WithValue(pf.ctx, prefetchContextKey{}, true)
+	for {
+		select {
+		case <-pf.ctx.Done():
+			return
+		case job := <-pf.queue:
+			job.proxy.prefetch(ctx, job)
+			pf.mutex.Lock()
+			delete(pf.pending, job)
+			pf.mutex.Unlock()
+		}
+	}
+}
+
+// prefetch requests the asset through the Handler, a fresh cached copy is served as a hit.
+func (proxy *CacheableProxy) prefetch(ctx context.Context, job prefetchJob) {
+	req, err := proxy.newInProcessRequest(ctx, job.requestURI)
+	if err != nil {
+		return
+	}
+	if job.namespace != "" {
+		req.Header.Set(HeaderCacheNamespace, job.namespace)
+	}
+	writer := newInProcessWriter(false)
+	proxy.Handler(writer, req)
+	if writer.status >= http.StatusBadRequest {
+		slog.Debug(
+			"[ PROXY SERVER ] Failed to prefetch asset",
+			slog.String("uri", job.requestURI), slog.Int("status", writer.status),
+		)
+	}
+}
+
+// stop cancels the queued jobs and waits for the running ones, until ctx is done.
+func (pf *prefetcher) stop(ctx context.Context) error {
+	// Canceled under the mutex, so no enqueue starts a worker once Wait may be running
+	pf.mutex.Lock()
+	pf.cancel()
+	pf.mutex.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		pf.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pageAssets returns the absolute URLs of the stylesheets, scripts and images referenced
+// by the page, resolved against its URL or its <base> element, in document order.
+func pageAssets(content []byte, pageURL *url.URL) []*url.URL {
+	var (
+		base      = pageURL
+		hasBase   bool
+		assets    []*url.URL
+		seen      = make(map[string]bool)
+		tokenizer = html.NewTokenizer(bytes.NewReader(content))
+	)
+	add := func(reference string) {
+		reference = strings.TrimSpace(reference)
+		if reference == "" {
+			return
+		}
+		parsed, err := base.Parse(reference)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return
+		}
+		parsed.Fragment = ""
+		if key := parsed.String(); !seen[key] {
+			seen[key] = true
+			assets = append(assets, parsed)
+		}
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return assets
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		token := tokenizer.Token()
+		attributes := make(map[string]string, len(token.Attr))
+		for _, attribute := range token.Attr {
+			attributes[attribute.Key] = attribute.Val
+		}
+		switch token.Data {
+		case "base":
+			if href, ok := attributes["href"]; ok && !hasBase {
+				if parsed, err := pageURL.Parse(href); err == nil {
+					base, hasBase = parsed, true
+				}
+			}
+		case "link":
+			if isPrefetchedRelation(attributes["rel"]) {
+				add(attributes["href"])
+			}
+		case "script":
+			add(attributes["src"])
+		case "img", "source":
+			add(attributes["src"])
+			for _, candidate := range strings.Split(attributes["srcset"], ",") {
+				if fields := strings.Fields(candidate); len(fields) > 0 {
+					add(fields[0])
+				}
+			}
+		case "video":
+			add(attributes["poster"])
+		}
+	}
+}
+
+func isPrefetchedRelation(rel string) bool {
+	for _, relation := range strings.Fields(rel) {
+		if slices.ContainsFunc(prefetchedRelations, func(prefetched string) bool {
+			return strings.EqualFold(relation, prefetched)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTMLType(mimeType string) bool {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.EqualFold(mediaType, "text/html") || strings.EqualFold(mediaType, "application/xhtml+xml")
+}
@@ -0,0 +1,159 @@
+package cacheproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageAssets(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post.html")
+	testCases := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "stylesheets scripts and images",
+			content: `<link rel="stylesheet" href="/style.css"><link rel="alternate" href="/feed.xml">` +
+				`<script src="app.js"></script><img src="//example.com/a.png#top" srcset="b.png 2x, c.png 3x">` +
+				`<video poster="/poster.jpg"></video><a href="/other.html">other</a>`,
+			expected: []string{
+				"https://example.com/style.css", "https://example.com/blog/app.js", "https://example.com/a.png",
+				"https://example.com/blog/b.png", "https://example.com/blog/c.png", "https://example.com/poster.jpg",
+			},
+		},
+		{
+			name:     "base element",
+			content:  `<base href="https://static.example.com/v2/"><img src="logo.png"><img src="logo.png">`,
+			expected: []string{"https://static.example.com/v2/logo.png"},
+		},
+		{
+			name:     "inline data",
+			content:  `<img src="data:image/png;base64,AAAA"><link rel="icon" href="javascript:void(0)">`,
+			expected: nil,
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			var assets []string
+			for _, asset := range pageAssets([]byte(tCase.content), pageURL) {
+				assets = append(assets, asset.String())
+			}
+			if !slices.Equal(assets, tCase.expected) {
+				t.Errorf("Expected %v, got %v", tCase.expected, assets)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_Prefetch(t *testing.T) {
+	var (
+		mutex          sync.Mutex
+		requested      []string
+		staticRequests []string
+	)
+	staticProxy, staticStorage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		staticRequests = append(staticRequests, r.URL.Path)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/javascript")
+		_, _ = io.WriteString(w, "console.log('app')")
+	}, WithTrackedTypes("text/javascript"))
+	proxyFor := func(host string) *CacheableProxy {
+		if host == "static.example.com" {
+			return staticProxy
+		}
+		return nil
+	}
+
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, `<html><link rel="stylesheet" href="/style.css">`+
+				`<script src="https://static.example.com/app.js"></script>`+
+				`<script src="https://assets.example.com/lib.js"></script>`+
+				`<img src="https://cdn.other.com/ad.png"></html>`)
+		default:
+			w.Header().Set("Content-Type", "text/css")
+			_, _ = io.WriteString(w, "body { color: red; }")
+		}
+	},
+		WithTrackedTypes("text/html", "text/css", "text/javascript"),
+		WithPrefetch(PrefetchPolicy{
+			AllowedHosts: []string{"static.example.com", "assets.example.com"}, ProxyFor: proxyFor,
+		}),
+	)
+	defer proxy.Shutdown(context.Background())
+
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the page, got %d", recorder.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for (storage.Len() < 2 || staticStorage.Len() < 1) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if storage.Len() != 2 || staticStorage.Len() != 1 {
+		t.Errorf(
+			"Expected the page and stylesheet on the target, and the script on its host, got %d and %d entries",
+			storage.Len(), staticStorage.Len(),
+		)
+	}
+
+	// Assets served from the cache are not prefetched again, nor are the assets of hosts without a proxy
+	recorder = serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/style.css", nil))
+	if cacheStatus := CacheStatus(recorder.Header().Get(HeaderCache)); cacheStatus != CacheHit {
+		t.Errorf("Expected the prefetched stylesheet to be a hit, got %s", cacheStatus)
+	}
+	recorder = serveProxy(staticProxy, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	if cacheStatus := CacheStatus(recorder.Header().Get(HeaderCache)); cacheStatus != CacheHit {
+		t.Errorf("Expected the prefetched script to be a hit on its host, got %s", cacheStatus)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	slices.Sort(requested)
+	if expected := []string{"/page", "/style.css"}; !slices.Equal(requested, expected) {
+		t.Errorf("Expected the target to receive %v, got %v", expected, requested)
+	}
+	if expected := []string{"/app.js"}; !slices.Equal(staticRequests, expected) {
+		t.Errorf("Expected the static host to receive %v, got %v", expected, staticRequests)
+	}
+}
+
+func TestPrefetcher_StopWhileEnqueuing(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "asset")
+	}, WithPrefetch(PrefetchPolicy{Concurrency: 4}))
+
+	var enqueued sync.WaitGroup
+	for index := range 8 {
+		enqueued.Add(1)
+		go func() {
+			defer enqueued.Done()
+			proxy.prefetcher.enqueue(prefetchJob{proxy: proxy, requestURI: fmt.Sprintf("/asset-%d.css", index)})
+		}()
+	}
+	if err := proxy.prefetcher.stop(context.Background()); err != nil {
+		t.Errorf("Expected the prefetcher to stop, got %v", err)
+	}
+	enqueued.Wait()
+
+	proxy.prefetcher.enqueue(prefetchJob{proxy: proxy, requestURI: "/late.css"})
+	proxy.prefetcher.mutex.Lock()
+	defer proxy.prefetcher.mutex.Unlock()
+	if _, ok := proxy.prefetcher.pending[prefetchJob{proxy: proxy, requestURI: "/late.css"}]; ok {
+		t.Errorf("Expected the jobs enqueued after stop to be dropped")
+	}
+}
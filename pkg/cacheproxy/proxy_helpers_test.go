@@ -0,0 +1,87 @@
+package cacheproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var errNotFound = errors.New("key not found")
+
+// memoryStorage is a CacheStorage kept in memory, used to test the proxy behavior.
+type memoryStorage struct {
+	mutex   sync.Mutex
+	entries map[string]FileInformation
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{entries: make(map[string]FileInformation)}
+}
+
+func (m *memoryStorage) Set(key string, value FileInformation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries[key] = value
+	return nil
+}
+
+func (m *memoryStorage) Get(key string) (FileInformation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, ok := m.entries[key]
+	if !ok {
+		return FileInformation{}, errNotFound
+	}
+	return value, nil
+}
+
+func (m *memoryStorage) DeletePrefix(prefix string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var removed int
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.entries, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Len counts the cached entries, leaving the bandwidth counters out like the storage key listings.
+func (m *memoryStorage) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var count int
+	for key := range m.entries {
+		if !strings.HasPrefix(key, BandwidthKeyPrefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// newTestProxy creates a proxy in front of the given handler, returning it with its storage.
+func newTestProxy(
+	t *testing.T, upstream http.HandlerFunc, options ...ProxyOption,
+) (*CacheableProxy, *memoryStorage) {
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	storage := newMemoryStorage()
+	proxy, err := New(storage, server.URL, 0, options...)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	return proxy, storage
+}
+
+// serveProxy sends the request through the proxy Handler and returns the recorded response.
+func serveProxy(proxy *CacheableProxy, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	proxy.Handler(recorder, req)
+	return recorder
+}
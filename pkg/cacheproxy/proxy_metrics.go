@@ -0,0 +1,141 @@
+package cacheproxy
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+)
+
+type proxyMetrics struct {
+	requests         *metricVec
+	bytesServed      *metricVec
+	upstreamLatency  *metricVec
+	inFlight         *metricVec
+	upstreamInFlight *metricVec
+	circuitState     *metricVec
+	storageSize      *metricVec
+	storageGCRuns    *metricVec
+	storageCorrupt   *metricVec
+	storageScrubs    *metricVec
+}
+
+// WithMetricsPath also serves the Prometheus metrics on the proxy listener, where the path
+// shadows the same upstream route. They are not served there by default, as MetricsHandler
+// can be mounted on a separate listener instead.
+func WithMetricsPath(path string) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.metricsPath = path
+	}
+}
+
+// WithMetricsToken requires the given Bearer token on the metrics path, instead of
+// the client credentials of the access policy. Its allowed networks still apply.
+func WithMetricsToken(token string) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.metricsToken = token
+	}
+}
+
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		requests: newMetricVec(
+			metricCounter, "radadar_proxy_requests_total",
+			"Requests received by the proxy, by cache status.",
+			"host", "mime", "cache_status",
+		),
+		bytesServed: newMetricVec(
+			metricCounter, "radadar_proxy_served_bytes_total",
+			"Response bytes sent to clients, by where they came from.",
+			"host", "source",
+		),
+		upstreamLatency: newMetricVec(
+			metricHistogram, "radadar_proxy_upstream_duration_seconds",
+			"Time until upstream answered the forwarded requests.",
+			"host",
+		),
+		inFlight: newMetricVec(
+			metricGauge, "radadar_proxy_inflight_requests",
+			"Requests currently being handled by the proxy.",
+		),
+		upstreamInFlight: newMetricVec(
+			metricGauge, "radadar_proxy_upstream_inflight_requests",
+			"Requests currently forwarded to upstream.",
+			"host",
+		),
+		circuitState: newMetricVec(
+			metricGauge, "radadar_proxy_upstream_circuit_state",
+			"Circuit breaker state by upstream host (0 closed, 1 open, 2 half-open).",
+			"host",
+		),
+		storageSize: newMetricVec(
+			metricGauge, "radadar_storage_size_bytes",
+			"Size of the cache storage on disk, by component.",
+			"component",
+		),
+		storageGCRuns: newMetricVec(
+			metricCounter, "radadar_storage_gc_runs_total",
+			"Garbage collection passes run on the cache storage.",
+		),
+		storageCorrupt: newMetricVec(
+			metricCounter, "radadar_storage_corrupt_entries_total",
+			"Cache entries that failed their checksum and were quarantined.",
+		),
+		storageScrubs: newMetricVec(
+			metricCounter, "radadar_storage_scrub_passes_total",
+			"Integrity verification passes run over the cache storage.",
+		),
+	}
+}
+
+// recordRequest accounts a finished request on the proxy metrics.
+func (proxy *CacheableProxy) recordRequest(tw *trackedWriter, state *requestState) {
+	host := proxy.targetURL.Host
+	mimeType, _, _ := mime.ParseMediaType(tw.Header().Get("Content-Type"))
+	proxy.metrics.requests.Add(1, host, mimeType, string(state.status))
+
+	source := "upstream"
+	if state.status == CacheHit || state.status == CacheStale || state.status == CacheRevalidated {
+		source = "cache"
+	}
+	proxy.metrics.bytesServed.Add(float64(tw.bytes), host, source)
+	if source == "cache" {
+		proxy.bandwidth.addCache(tw.bytes, time.Now())
+	}
+	if state.upstreamDuration > 0 {
+		proxy.metrics.upstreamLatency.Observe(state.upstreamDuration.Seconds(), host)
+	}
+}
+
+// MetricsHandler serves the proxy and storage metrics in the Prometheus text format.
+func (proxy *CacheableProxy) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics := proxy.metrics
+		for host, state := range proxy.CircuitStates() {
+			metrics.circuitState.Set(float64(state), host)
+		}
+		if reporter, ok := proxy.storage.(StatsReporter); ok {
+			stats := reporter.Stats()
+			metrics.storageSize.Set(float64(stats.LSMSize), "lsm")
+			metrics.storageSize.Set(float64(stats.ValueLogSize), "value_log")
+			// The storage counts its totals since it was opened
+			metrics.storageGCRuns.AddTotal(float64(stats.GCRuns))
+			metrics.storageCorrupt.AddTotal(float64(stats.CorruptEntries))
+			metrics.storageScrubs.AddTotal(float64(stats.ScrubPasses))
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		vectors := []io.WriterTo{
+			metrics.requests, metrics.bytesServed, metrics.upstreamLatency,
+			metrics.inFlight, metrics.upstreamInFlight, metrics.circuitState,
+			metrics.storageSize, metrics.storageGCRuns, metrics.storageCorrupt, metrics.storageScrubs,
+		}
+		for _, vec := range vectors {
+			if _, err := vec.WriteTo(w); err != nil {
+				slog.Error("[ PROXY SERVER ] Error writing metrics", slog.String("error", err.Error()))
+				return
+			}
+		}
+	})
+}
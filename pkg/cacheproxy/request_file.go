@@ -2,10 +2,8 @@ package cacheproxy
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -13,30 +11,40 @@ import (
 )
 
 func (proxy *CacheableProxy) cacheKey(req *http.Request) string {
-	query, err := url.QueryUnescape(req.URL.RawQuery)
-	if err != nil {
-		query = req.URL.RawQuery
+	if proxy.keyBuilder == nil {
+		return StandardKeyBuilder{}.CacheKey(req, proxy.targetURL)
 	}
-	cacheKey := fmt.Sprintf(
-		"file://%s@%s#%s",
-		req.Method, proxy.targetURL.String(),
-		req.URL.Path+query,
-	)
-	return strings.TrimSpace(cacheKey)
+	return proxy.keyBuilder.CacheKey(req, proxy.targetURL)
 }
 
 func (proxy *CacheableProxy) InterceptFile(resp *http.Response) error {
 	// Get the requested file URL from the request
 	fileURL := resp.Request.RequestURI
-	cacheKey := proxy.cacheKey(resp.Request)
+	proxy.observeUpstreamStatus(resp)
+	state := proxy.stateOf(resp.Request)
+	state.upstreamResponded()
+	if kind := responseStream(resp); kind != streamNone {
+		proxy.passStream(resp, state, kind)
+		return nil
+	}
+	if !state.cacheable {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotModified && state.stale != nil {
+		return proxy.refreshRevalidated(resp, state)
+	}
 	now := time.Now()
 
-	cachedFile, err := proxy.storage.Get(cacheKey)
-	if err == nil && len(cachedFile.Checksum) > 0 &&
-		cachedFile.ModifiedAt.Sub(now) < proxy.cacheTTL {
+	cachedFile, err := proxy.storage.Get(state.key)
+	if err == nil && proxy.isFresh(cachedFile, now) {
 		return nil
 	}
 
+	negative := isNegativeStatus(resp.StatusCode)
+	if negative {
+		resp.Header.Set(HeaderCacheError, statusErrorClass(resp.StatusCode))
+	}
+
 	// Not cached, make a request to the target site and store the result in the cache
 	var respBody []byte
 	if respBody, err = bodyReader(resp.Body); err != nil {
@@ -50,7 +58,7 @@ func (proxy *CacheableProxy) InterceptFile(resp *http.Response) error {
 			MimeType:  fileMIME(respBody, resp.Header),
 		},
 		Envelope: FileEnvelope{
-			Headers: resp.Header,
+			Headers: proxy.storedHeaders(resp.Header),
 			Status:  uint16(resp.StatusCode),
 		},
 		Content:       respBody,
@@ -59,13 +67,36 @@ func (proxy *CacheableProxy) InterceptFile(resp *http.Response) error {
 		ModifiedAt:    now,
 		ExtraMetadata: make(map[string]string),
 	}
+	if state.namespace != "" {
+		fileInfo.ExtraMetadata[MetadataNamespace] = state.namespace
+	}
 
 	// Reassign the body so that it can be sent to the client
 	resp.Body = io.NopCloser(bytes.NewReader(respBody))
-	if proxy.isFileTracked(fileInfo) {
-		return proxy.storage.Set(cacheKey, fileInfo)
+	switch {
+	case negative:
+		// Error responses are kept only for the short negative TTL, whatever their MIME
+		if proxy.negativeTTL <= 0 {
+			return nil
+		}
+		fileInfo.ExtraMetadata[MetadataCacheError] = statusErrorClass(resp.StatusCode)
+		setExpiration(&fileInfo, now.Add(proxy.negativeTTL))
+	case !proxy.isFileTracked(fileInfo):
+		return nil
 	}
 
+	_, storeSpan := startSpan(resp.Request.Context(), "cache.store")
+	defer storeSpan.End()
+	if negative {
+		err = proxy.storeNegative(state.key, fileInfo)
+	} else {
+		err = proxy.store(state.key, fileInfo)
+	}
+	if err != nil {
+		recordSpanError(storeSpan, err)
+		return err
+	}
+	proxy.schedulePrefetch(resp, fileInfo, state.namespace)
 	return nil
 }
 
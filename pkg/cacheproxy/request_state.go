@@ -0,0 +1,89 @@
+package cacheproxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// CacheStatus tells where the response given to a request came from.
+type CacheStatus string
+
+const (
+	CacheHit    CacheStatus = "HIT"
+	CacheMiss   CacheStatus = "MISS"
+	CacheStale  CacheStatus = "STALE"
+	CacheBypass CacheStatus = "BYPASS"
+	// CacheRevalidated is a stale copy that upstream confirmed to be unchanged
+	CacheRevalidated CacheStatus = "REVALIDATED"
+)
+
+// HeaderCache tells clients the CacheStatus of the response.
+const HeaderCache = "X-Cache"
+
+type (
+	requestStateKey struct{}
+	// requestState holds the cache decisions taken for a request, so they can be
+	// reused once the upstream response arrives, after the request body was consumed.
+	requestState struct {
+		key       string
+		namespace string
+		// servedNamespace is the namespace of the response, a parent one when the request fell through
+		servedNamespace  string
+		cacheable        bool
+		status           CacheStatus
+		upstreamStart    time.Time
+		upstreamDuration time.Duration
+		// stale is the expired copy being revalidated with a conditional request
+		stale *FileInformation
+		// stream is set for upgrades and event streams, passed through without being cached
+		stream streamKind
+		// releaseUpstream frees the politeness slot of a stream as soon as upstream answers
+		releaseUpstream func()
+		// rewriter points origin URLs to the proxy address the request was sent to
+		rewriter *urlRewriter
+	}
+)
+
+func (proxy *CacheableProxy) newRequestState(req *http.Request) *requestState {
+	state := &requestState{cacheable: true, status: CacheMiss, rewriter: proxy.newURLRewriter(req)}
+	state.namespace = proxy.namespaceOf(req)
+	state.servedNamespace = state.namespace
+	keyPrefix := NamespaceKeyPrefix(state.namespace)
+	if state.stream = requestStream(req); state.stream != streamNone {
+		// The key still identifies the recording of the stream
+		state.cacheable, state.status = false, CacheBypass
+		state.key = keyPrefix + proxy.cacheKey(req)
+		return state
+	}
+	rule, hasRule := proxy.matchRule(req)
+	if !isSafeMethod(req.Method) && !hasRule {
+		state.cacheable, state.status = false, CacheBypass
+		return state
+	}
+
+	state.key = keyPrefix + proxy.cacheKey(req)
+	if hasRule && !isSafeMethod(req.Method) {
+		state.key += rule.bodyKeySuffix(req)
+	}
+	return state
+}
+
+func withRequestState(req *http.Request, state *requestState) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestStateKey{}, state))
+}
+
+// stateOf returns the state attached by the Handler, computing it when absent.
+func (proxy *CacheableProxy) stateOf(req *http.Request) *requestState {
+	if state, ok := req.Context().Value(requestStateKey{}).(*requestState); ok {
+		return state
+	}
+	return proxy.newRequestState(req)
+}
+
+// upstreamResponded marks the moment upstream answered the request, or failed to.
+func (state *requestState) upstreamResponded() {
+	if !state.upstreamStart.IsZero() && state.upstreamDuration == 0 {
+		state.upstreamDuration = time.Since(state.upstreamStart)
+	}
+}
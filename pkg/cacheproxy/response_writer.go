@@ -0,0 +1,72 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// trackedWriter records the status and amount of bytes written on a response.
+type trackedWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// beforeHeader is called once, right before the response headers are sent
+	beforeHeader func(header http.Header)
+}
+
+func (tw *trackedWriter) WriteHeader(statusCode int) {
+	if tw.status == 0 {
+		tw.status = statusCode
+		if tw.beforeHeader != nil && statusCode >= http.StatusOK {
+			tw.beforeHeader(tw.Header())
+		}
+	}
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *trackedWriter) Write(content []byte) (int, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	written, err := tw.ResponseWriter.Write(content)
+	tw.bytes += int64(written)
+	return written, err
+}
+
+// Unwrap allows http.ResponseController to reach the original writer.
+func (tw *trackedWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// inProcessWriter keeps the response of a request served without a network connection.
+type inProcessWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// keepBody must be set for the content to be kept, otherwise it is discarded
+	keepBody bool
+}
+
+func newInProcessWriter(keepBody bool) *inProcessWriter {
+	return &inProcessWriter{header: make(http.Header), keepBody: keepBody}
+}
+
+func (iw *inProcessWriter) Header() http.Header {
+	return iw.header
+}
+
+func (iw *inProcessWriter) WriteHeader(statusCode int) {
+	if iw.status == 0 {
+		iw.status = statusCode
+	}
+}
+
+func (iw *inProcessWriter) Write(content []byte) (int, error) {
+	if iw.status == 0 {
+		iw.status = http.StatusOK
+	}
+	if !iw.keepBody {
+		return len(content), nil
+	}
+	return iw.body.Write(content)
+}
@@ -0,0 +1,138 @@
+package cacheproxy
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+type (
+	// RetryPolicy retries idempotent requests that failed with a connection reset,
+	// or that got a 429, 502, 503 or 504 response, using exponential backoff with jitter.
+	RetryPolicy struct {
+		MaxAttempts int // Includes the first attempt
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration // Longer Retry-After values are not waited for
+	}
+	// resilientTransport applies the retry policy and the circuit breaker to upstream requests.
+	resilientTransport struct {
+		base     http.RoundTripper
+		retry    RetryPolicy
+		breakers *breakerSet
+		limiter  *upstreamLimiter
+	}
+)
+
+// WithRetryPolicy retries failed idempotent requests sent to upstream.
+func WithRetryPolicy(policy RetryPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		if policy.BaseDelay <= 0 {
+			policy.BaseDelay = 100 * time.Millisecond
+		}
+		if policy.MaxDelay <= 0 {
+			policy.MaxDelay = 10 * time.Second
+		}
+		proxy.retry = &policy
+	}
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (rt *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var breaker *circuitBreaker
+	if rt.breakers != nil {
+		breaker = rt.breakers.host(req.URL.Host)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if breaker != nil && !breaker.allow(rt.breakers.policy, time.Now()) {
+			return nil, ErrCircuitOpen
+		}
+
+		if attempt > 1 && rt.limiter != nil {
+			// Retries are new requests for upstream, so they wait for the rate limit too
+			if err := rt.limiter.Wait(req.Context(), req.URL.Host); err != nil {
+				// The retry may hold the half-open probe, which must be freed for the next request
+				if breaker != nil {
+					breaker.abandon()
+				}
+				return nil, err
+			}
+		}
+
+		resp, err := rt.base.RoundTrip(req)
+		switch {
+		case breaker == nil:
+		case err != nil && req.Context().Err() != nil:
+			breaker.abandon()
+		default:
+			healthy := err == nil && resp.StatusCode < http.StatusInternalServerError
+			breaker.record(rt.breakers.policy, healthy, time.Now())
+		}
+
+		delay, retry := rt.retryDelay(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay decides whether the request must be sent again, and how long to wait before it.
+func (rt *resilientTransport) retryDelay(
+	req *http.Request, resp *http.Response, err error, attempt int,
+) (time.Duration, bool) {
+	if attempt >= rt.retry.MaxAttempts || !isIdempotentMethod(req.Method) ||
+		(req.Body != nil && req.Body != http.NoBody) {
+		return 0, false
+	}
+
+	if err != nil {
+		return rt.backoff(attempt), ClassifyUpstreamError(err) == UpstreamErrorReset
+	}
+	if !isRetryableStatus(resp.StatusCode) {
+		return 0, false
+	}
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return retryAfter, retryAfter <= rt.retry.MaxDelay
+	}
+	return rt.backoff(attempt), true
+}
+
+// backoff doubles the delay on each attempt, randomizing half of it to spread retries.
+func (rt *resilientTransport) backoff(attempt int) time.Duration {
+	delay := min(rt.retry.BaseDelay<<(attempt-1), rt.retry.MaxDelay)
+	if delay <= 0 { // Shift overflow
+		delay = rt.retry.MaxDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
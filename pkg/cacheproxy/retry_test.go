@@ -0,0 +1,169 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheableProxy_Retry(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		failures       int32
+		failWith       func(w http.ResponseWriter)
+		expectedStatus int
+		expectedCalls  int32
+	}{
+		{
+			name: "Retry on 503", method: http.MethodGet, failures: 2,
+			failWith:       func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			expectedStatus: http.StatusOK, expectedCalls: 3,
+		},
+		{
+			name: "Give up after max attempts", method: http.MethodGet, failures: 5,
+			failWith:       func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			expectedStatus: http.StatusBadGateway, expectedCalls: 3,
+		},
+		{
+			name: "Retry on connection reset", method: http.MethodGet, failures: 1,
+			failWith: func(w http.ResponseWriter) {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+			},
+			expectedStatus: http.StatusOK, expectedCalls: 2,
+		},
+		{
+			name: "Honor short Retry-After", method: http.MethodGet, failures: 1,
+			failWith: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			expectedStatus: http.StatusOK, expectedCalls: 2,
+		},
+		{
+			name: "Do not wait for long Retry-After", method: http.MethodGet, failures: 1,
+			failWith: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			expectedStatus: http.StatusTooManyRequests, expectedCalls: 1,
+		},
+		{
+			name: "Non idempotent requests are not retried", method: http.MethodPost, failures: 1,
+			failWith:       func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			expectedStatus: http.StatusServiceUnavailable, expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			upstream := func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) <= tt.failures {
+					tt.failWith(w)
+					return
+				}
+				_, _ = w.Write([]byte("ok"))
+			}
+			proxy, _ := newTestProxy(t, upstream, WithRetryPolicy(RetryPolicy{
+				MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second,
+			}))
+
+			var body io.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader("payload")
+			}
+			req := httptest.NewRequest(tt.method, "/page", body)
+			if recorder := serveProxy(proxy, req); recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if result := calls.Load(); result != tt.expectedCalls {
+				t.Errorf("Expected %d upstream calls, got %d", tt.expectedCalls, result)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_RetryRespectsRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+	proxy, _ := newTestProxy(t, upstream,
+		WithPoliteness(PolitenessPolicy{RequestsPerSecond: 10, Burst: 1, MaxInFlight: 1}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}),
+	)
+
+	start := time.Now()
+	if recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+	// Each of the two retries waits for a new token, one every 100ms
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("Expected the retries to wait for the rate limit, took %v", elapsed)
+	}
+}
+
+func TestResilientTransport_Backoff(t *testing.T) {
+	rt := resilientTransport{retry: RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}}
+	tests := []struct {
+		attempt  int
+		minDelay time.Duration
+		maxDelay time.Duration
+	}{
+		{attempt: 1, minDelay: 50 * time.Millisecond, maxDelay: 100 * time.Millisecond},
+		{attempt: 3, minDelay: 200 * time.Millisecond, maxDelay: 400 * time.Millisecond},
+		{attempt: 10, minDelay: 500 * time.Millisecond, maxDelay: time.Second},
+		{attempt: 80, minDelay: 500 * time.Millisecond, maxDelay: time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			if delay := rt.backoff(tt.attempt); delay < tt.minDelay || delay > tt.maxDelay {
+				t.Errorf("Attempt %d: expected delay in [%v, %v], got %v", tt.attempt, tt.minDelay, tt.maxDelay, delay)
+			}
+		}
+	}
+}
+
+func TestResilientTransport_CanceledRetryFreesProbe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	host := upstream.Listener.Addr().String()
+
+	policy := BreakerPolicy{FailureThreshold: 1, OpenDuration: time.Millisecond}
+	limiter := newUpstreamLimiter(PolitenessPolicy{RequestsPerSecond: 0.01, Burst: 1})
+	rt := &resilientTransport{
+		base:     http.DefaultTransport,
+		retry:    RetryPolicy{MaxAttempts: 2, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second},
+		breakers: &breakerSet{policy: policy, hosts: make(map[string]*circuitBreaker)},
+		limiter:  limiter,
+	}
+	// Spend the only token, so the retry waits for the rate limit until the client gives up
+	if err := limiter.Wait(context.Background(), host); err != nil {
+		t.Fatalf("Failed to take the token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, upstream.URL+"/page", nil).WithContext(ctx)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the retry to stop on the deadline, got %v", err)
+	}
+	if !rt.breakers.host(host).allow(policy, time.Now()) {
+		t.Error("Expected the canceled retry to free the half-open probe")
+	}
+}
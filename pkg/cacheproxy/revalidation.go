@@ -0,0 +1,75 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// prepareRevalidation turns the request into a conditional one when the expired copy
+// carries validators, so upstream can confirm it with a 304 instead of resending it.
+func prepareRevalidation(r *http.Request, state *requestState, stale FileInformation) {
+	if !canServeStale(stale) {
+		return
+	}
+	// Conditional requests from the client are answered by upstream itself
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+
+	headers := http.Header(stale.Envelope.Headers)
+	etag, lastModified := headers.Get("ETag"), headers.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+	state.stale = &stale
+}
+
+// refreshRevalidated answers a 304 from upstream with the stored copy, storing it again
+// with the headers upstream sent along, which restarts its freshness.
+func (proxy *CacheableProxy) refreshRevalidated(resp *http.Response, state *requestState) error {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	now := time.Now()
+	fileInfo := *state.stale
+	headers := http.Header(fileInfo.Envelope.Headers).Clone()
+	for key, values := range resp.Header {
+		if key != "Content-Length" {
+			headers[key] = values
+		}
+	}
+	headers.Set("Content-Length", strconv.Itoa(len(fileInfo.Content)))
+	fileInfo.Envelope.Headers = proxy.storedHeaders(headers)
+	fileInfo.ModifiedAt = now
+
+	status := int(fileInfo.Envelope.Status)
+	resp.StatusCode = status
+	resp.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	resp.Header = make(http.Header, len(headers))
+	for key, values := range headers {
+		if !isRedacted(values) {
+			resp.Header[key] = slices.Clone(values)
+		}
+	}
+	resp.ContentLength = int64(len(fileInfo.Content))
+	resp.Body = io.NopCloser(bytes.NewReader(fileInfo.Content))
+	state.status = CacheRevalidated
+
+	_, storeSpan := startSpan(resp.Request.Context(), "cache.store")
+	defer storeSpan.End()
+	err := proxy.store(state.key, fileInfo)
+	if err != nil {
+		recordSpanError(storeSpan, err)
+	}
+	return err
+}
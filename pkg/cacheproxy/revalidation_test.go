@@ -0,0 +1,82 @@
+package cacheproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheableProxy_Revalidation(t *testing.T) {
+	const etag = `"v1"`
+	tests := []struct {
+		name           string
+		changed        bool
+		expectedStatus CacheStatus
+		expectedBody   string
+	}{
+		{name: "Unchanged upstream", expectedStatus: CacheRevalidated, expectedBody: "<html>v1</html>"},
+		{name: "Changed upstream", changed: true, expectedStatus: CacheMiss, expectedBody: "<html>v2</html>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conditionalCalls atomic.Int32
+			body := "<html>v1</html>"
+			proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") == etag {
+					conditionalCalls.Add(1)
+					if !tt.changed {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("ETag", etag)
+				_, _ = w.Write([]byte(body))
+			})
+
+			serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+			proxy.cacheTTL = time.Nanosecond
+			body = "<html>v2</html>"
+
+			recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/page", nil))
+			if conditionalCalls.Load() != 1 {
+				t.Errorf("Expected 1 conditional request, got %d", conditionalCalls.Load())
+			}
+			if header := recorder.Header().Get(HeaderCache); header != string(tt.expectedStatus) {
+				t.Errorf("Expected %s header `%s`, got `%s`", HeaderCache, tt.expectedStatus, header)
+			}
+			if recorder.Code != http.StatusOK || recorder.Body.String() != tt.expectedBody {
+				t.Errorf("Expected 200 `%s`, got %d `%s`", tt.expectedBody, recorder.Code, recorder.Body.String())
+			}
+
+			stored, err := storage.Get(proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/page", nil)))
+			if err != nil || string(stored.Content) != tt.expectedBody {
+				t.Errorf("Expected stored content `%s`, got `%s` (%v)", tt.expectedBody, stored.Content, err)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_AgeHeader(t *testing.T) {
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the request to be answered from cache")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	_ = storage.Set(proxy.cacheKey(req), FileInformation{
+		Envelope:   FileEnvelope{Status: http.StatusOK},
+		Content:    []byte("cached"),
+		Checksum:   checksum([]byte("cached")),
+		ModifiedAt: time.Now().Add(-90 * time.Second),
+	})
+
+	recorder := serveProxy(proxy, req)
+	if age := recorder.Header().Get("Age"); age != "90" {
+		t.Errorf("Expected Age `90`, got `%s`", age)
+	}
+	if header := recorder.Header().Get(HeaderCache); header != string(CacheHit) {
+		t.Errorf("Expected %s header `%s`, got `%s`", HeaderCache, CacheHit, header)
+	}
+}
@@ -0,0 +1,222 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rewritableTypes are the MIME types whose bodies may link back to the origin
+var rewritableTypes = []string{
+	"text/html", "application/xhtml+xml", "text/css",
+	"text/javascript", "application/javascript", "application/x-javascript", "application/ecmascript",
+}
+
+// rewrittenHeaders are the response headers carrying URLs
+var rewrittenHeaders = []string{"Location", "Content-Location", "Link"}
+
+type (
+	// URLRewritePolicy configures the rewriting of origin URLs to the proxy address, so
+	// pages served through the proxy keep loading their links and assets from it.
+	URLRewritePolicy struct {
+		// PublicURL is how clients reach the proxy, like http://localhost:8080.
+		// When empty, the scheme and Host of each request are used.
+		PublicURL string
+		// AssetHosts are other hosts served by the same upstream, like a www. alias
+		// or a static subdomain, whose URLs are also rewritten to the proxy.
+		AssetHosts []string
+	}
+	// urlRewriter replaces absolute origin URLs, including protocol-relative and
+	// JSON-escaped ones, by the proxy address a single request was made to.
+	urlRewriter struct {
+		pattern    *regexp.Regexp
+		publicURL  *url.URL
+		escapedURL string
+	}
+)
+
+// WithURLRewrite rewrites origin URLs found in HTML, CSS and JavaScript bodies, including
+// srcset and inline scripts, and in redirect headers. The stored responses are kept
+// unchanged, the rewriting happens whenever a response is served.
+func WithURLRewrite(policy URLRewritePolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.urlRewrite = &policy
+		proxy.originPattern = compileOriginPattern(proxy.targetURL.Host, policy.AssetHosts)
+	}
+}
+
+// compileOriginPattern matches the scheme, or the protocol-relative slashes, and host of
+// the target and asset hosts.
+func compileOriginPattern(targetHost string, assetHosts []string) *regexp.Regexp {
+	hosts := []string{regexp.QuoteMeta(targetHost)}
+	for _, host := range assetHosts {
+		hosts = append(hosts, regexp.QuoteMeta(host))
+	}
+	return regexp.MustCompile(`(?i)(https?:)?(//|\\/\\/)(` + strings.Join(hosts, "|") + `)`)
+}
+
+// newURLRewriter returns the rewriter for the request, or nil when rewriting is disabled.
+func (proxy *CacheableProxy) newURLRewriter(req *http.Request) *urlRewriter {
+	if proxy.urlRewrite == nil {
+		return nil
+	}
+	publicURL, err := url.Parse(proxy.urlRewrite.PublicURL)
+	if err != nil || publicURL.Host == "" {
+		publicURL = &url.URL{Scheme: "http", Host: req.Host}
+		if req.TLS != nil {
+			publicURL.Scheme = "https"
+		}
+	}
+	return &urlRewriter{
+		pattern:    proxy.originPattern,
+		publicURL:  publicURL,
+		escapedURL: publicURL.Scheme + `:\/\/` + publicURL.Host,
+	}
+}
+
+// rewrite replaces the origin URLs of the headers, returning the rewritten body.
+// Bodies are decoded and encoded again when gzipped, other encodings are left untouched.
+func (rewriter *urlRewriter) rewrite(header http.Header, body []byte) []byte {
+	for _, name := range rewrittenHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			header.Del(name)
+			for _, value := range values {
+				header.Add(name, string(rewriter.replace([]byte(value))))
+			}
+		}
+	}
+	if !isRewritableType(header.Get("Content-Type")) {
+		return body
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	content, ok := decodeBody(encoding, body)
+	if !ok {
+		return body
+	}
+
+	rewritten := rewriter.replace(content)
+	if encoding == "gzip" {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		_, _ = writer.Write(rewritten)
+		_ = writer.Close()
+		rewritten = compressed.Bytes()
+	}
+	header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	return rewritten
+}
+
+// decodeBody returns the body without its gzip encoding, reporting false for other encodings.
+func decodeBody(encoding string, body []byte) ([]byte, bool) {
+	switch encoding {
+	case "", "identity":
+		return body, true
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		content, err := io.ReadAll(reader)
+		return content, err == nil
+	}
+	return nil, false
+}
+
+func (rewriter *urlRewriter) replace(content []byte) []byte {
+	matches := rewriter.pattern.FindAllSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return content
+	}
+
+	var (
+		output bytes.Buffer
+		last   int
+	)
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		hasScheme := match[2] >= 0
+		// Neither another scheme, like ftp://, nor a longer host, like origin.com.evil.org
+		if (!hasScheme && start > 0 && isSchemeChar(content[start-1])) || continuesHost(content[end:]) {
+			continue
+		}
+		output.Write(content[last:start])
+		escaped := content[match[4]] == '\\'
+		switch {
+		case escaped && hasScheme:
+			output.WriteString(rewriter.escapedURL)
+		case escaped:
+			output.WriteString(`\/\/` + rewriter.publicURL.Host)
+		case hasScheme:
+			output.WriteString(rewriter.publicURL.Scheme + "://" + rewriter.publicURL.Host)
+		default:
+			output.WriteString("//" + rewriter.publicURL.Host)
+		}
+		last = end
+	}
+	output.Write(content[last:])
+	return output.Bytes()
+}
+
+func isSchemeChar(char byte) bool {
+	return char == ':' || char == '+' || char == '-' || char == '.' ||
+		('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+}
+
+// continuesHost reports whether the text after a matched host still belongs to the host or its port.
+func continuesHost(rest []byte) bool {
+	if len(rest) == 0 {
+		return false
+	}
+	isHostChar := func(char byte) bool {
+		return char == '-' || char == '_' ||
+			('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+	}
+	switch {
+	case isHostChar(rest[0]):
+		return true
+	case rest[0] == '.' || rest[0] == ':':
+		return len(rest) > 1 && isHostChar(rest[1])
+	}
+	return false
+}
+
+func isRewritableType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	for _, rewritable := range rewritableTypes {
+		if strings.EqualFold(mediaType, rewritable) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteResponse adapts the upstream response sent to the client, once it was stored.
+func (proxy *CacheableProxy) rewriteResponse(resp *http.Response) error {
+	state := proxy.stateOf(resp.Request)
+	if state.rewriter == nil || state.stream != streamNone || resp.Body == nil {
+		return nil
+	}
+	// The stored envelope may share the header map, it must keep the origin URLs
+	resp.Header = resp.Header.Clone()
+	if !isRewritableType(resp.Header.Get("Content-Type")) {
+		state.rewriter.rewrite(resp.Header, nil)
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = state.rewriter.rewrite(resp.Header, body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return nil
+}
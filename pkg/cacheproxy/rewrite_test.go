@@ -0,0 +1,119 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestURLRewriter_Replace(t *testing.T) {
+	proxy, err := New(newMemoryStorage(), "https://example.com", 0, WithURLRewrite(URLRewritePolicy{
+		PublicURL:  "http://localhost:8080",
+		AssetHosts: []string{"static.example.com"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	rewriter := proxy.newURLRewriter(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	testCases := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "attribute",
+			content:  `<a href="https://example.com/page">`,
+			expected: `<a href="http://localhost:8080/page">`,
+		},
+		{
+			name:     "srcset",
+			content:  `srcset="https://static.example.com/a.jpg 1x, //example.com/b.jpg 2x"`,
+			expected: `srcset="http://localhost:8080/a.jpg 1x, //localhost:8080/b.jpg 2x"`,
+		},
+		{
+			name:     "css url",
+			content:  `background: url('http://example.com/bg.png')`,
+			expected: `background: url('http://localhost:8080/bg.png')`,
+		},
+		{
+			name:     "escaped json",
+			content:  `{"api":"https:\/\/example.com\/api"}`,
+			expected: `{"api":"http:\/\/localhost:8080\/api"}`,
+		},
+		{
+			name:     "other hosts",
+			content:  `https://example.com.evil.org https://example.community ftp://example.com https://example.com:8443/`,
+			expected: `https://example.com.evil.org https://example.community ftp://example.com https://example.com:8443/`,
+		},
+		{
+			name:     "end of sentence",
+			content:  `Visit https://example.com.`,
+			expected: `Visit http://localhost:8080.`,
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			if replaced := string(rewriter.replace([]byte(tCase.content))); replaced != tCase.expected {
+				t.Errorf("Expected `%s`, got `%s`", tCase.expected, replaced)
+			}
+		})
+	}
+}
+
+func TestURLRewrite_FreshAndCachedResponses(t *testing.T) {
+	var upstreamURL string
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, upstreamURL+"/page", http.StatusMovedPermanently)
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Header().Set("Content-Encoding", "gzip")
+			writer := gzip.NewWriter(w)
+			_, _ = writer.Write([]byte(`body { background: url(` + upstreamURL + `/bg.png) }`))
+			_ = writer.Close()
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<a href="` + upstreamURL + `/next">next</a>`))
+		}
+	}, WithURLRewrite(URLRewritePolicy{}), WithTrackedTypes("text/html", "text/css"))
+	upstreamURL = proxy.targetURL.String()
+
+	expectedPage := `<a href="http://proxy.local/next">next</a>`
+	for _, cacheStatus := range []CacheStatus{CacheMiss, CacheHit} {
+		req := httptest.NewRequest(http.MethodGet, "http://proxy.local/page", nil)
+		recorder := serveProxy(proxy, req)
+		if body := recorder.Body.String(); body != expectedPage {
+			t.Errorf("Expected `%s` on %s, got `%s`", expectedPage, cacheStatus, body)
+		}
+		if status := recorder.Header().Get(HeaderCache); status != string(cacheStatus) {
+			t.Errorf("Expected %s, got %s", cacheStatus, status)
+		}
+	}
+	stored, _ := storage.Get(proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/page", nil)))
+	if !strings.Contains(string(stored.Content), upstreamURL) {
+		t.Errorf("Expected the stored page to keep the origin URL, got `%s`", stored.Content)
+	}
+
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "http://proxy.local/old", nil))
+	if location := recorder.Header().Get("Location"); location != "http://proxy.local/page" {
+		t.Errorf("Expected the redirect to point to the proxy, got `%s`", location)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.local/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder = serveProxy(proxy, req)
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected a gzipped stylesheet: %v", err)
+	}
+	css, _ := io.ReadAll(reader)
+	if expected := `body { background: url(http://proxy.local/bg.png) }`; string(css) != expected {
+		t.Errorf("Expected `%s`, got `%s`", expected, css)
+	}
+}
@@ -0,0 +1,178 @@
+package cacheproxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+// HeaderDeniedReason explains why the proxy refused to forward a request.
+const HeaderDeniedReason = "X-Denied-Reason"
+
+const (
+	defaultRobotsRefresh = 24 * time.Hour
+	robotsRetryInterval  = time.Minute
+	maxRobotsRedirects   = 5
+	maxRobotsSize        = 500 << 10 // Rules past the first 500 KiB are ignored
+	disallowAllRobots    = "User-agent: *\nDisallow: /\n"
+)
+
+type (
+	// RobotsPolicy makes the proxy obey the robots.txt of every upstream host.
+	RobotsPolicy struct {
+		UserAgent       string
+		RefreshInterval time.Duration // How long a robots.txt is kept, defaults to a day
+	}
+	robotsCache struct {
+		policy    RobotsPolicy
+		transport http.RoundTripper
+		limiter   *upstreamLimiter
+		mutex     sync.Mutex
+		hosts     map[string]*robotsEntry
+	}
+	robotsEntry struct {
+		ready     chan struct{}
+		group     *robotstxt.Group
+		expiresAt time.Time
+	}
+)
+
+// WithRobotsPolicy denies requests disallowed by the upstream robots.txt for the user agent,
+// and applies its Crawl-delay to the upstream politeness limits.
+func WithRobotsPolicy(policy RobotsPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		if policy.RefreshInterval <= 0 {
+			policy.RefreshInterval = defaultRobotsRefresh
+		}
+		proxy.robots = &robotsCache{policy: policy, hosts: make(map[string]*robotsEntry)}
+	}
+}
+
+// group returns the robots rules for the host, fetching them when missing or expired.
+func (rc *robotsCache) group(ctx context.Context, scheme, host string) (*robotstxt.Group, bool) {
+	rc.mutex.Lock()
+	if entry, ok := rc.hosts[host]; ok {
+		select {
+		case <-entry.ready:
+			if time.Now().Before(entry.expiresAt) {
+				rc.mutex.Unlock()
+				return entry.group, false
+			}
+		default: // Another request is already fetching it
+			rc.mutex.Unlock()
+			<-entry.ready
+			return entry.group, false
+		}
+	}
+
+	entry := &robotsEntry{ready: make(chan struct{})}
+	rc.hosts[host] = entry
+	rc.mutex.Unlock()
+
+	var ttl time.Duration
+	entry.group, ttl = rc.fetch(ctx, scheme, host)
+	entry.expiresAt = time.Now().Add(ttl)
+	close(entry.ready)
+	return entry.group, true
+}
+
+// fetch loads the robots.txt of the host, following a few redirects. Server errors
+// disallow everything, but only until the next retry, as the failure is temporary.
+func (rc *robotsCache) fetch(ctx context.Context, scheme, host string) (*robotstxt.Group, time.Duration) {
+	robotsURL := &url.URL{Scheme: scheme, Host: host, Path: "/robots.txt"}
+	ctx = context.WithoutCancel(ctx)
+	for redirects := 0; ; redirects++ {
+		status, body, location, err := rc.get(ctx, robotsURL)
+		if err != nil {
+			slog.Error(
+				"[ PROXY SERVER ] Failed to fetch robots.txt",
+				slog.String("host", host), slog.String("error", err.Error()),
+			)
+			return nil, robotsRetryInterval
+		}
+		if location != nil {
+			if redirects >= maxRobotsRedirects {
+				return nil, robotsRetryInterval
+			}
+			robotsURL = location
+			continue
+		}
+
+		// FindGroup loses the disallow-all of server errors, so those rules are spelled out
+		ttl := rc.policy.RefreshInterval
+		if status >= http.StatusInternalServerError && status <= 599 {
+			status, body, ttl = http.StatusOK, []byte(disallowAllRobots), robotsRetryInterval
+		}
+		robots, err := robotstxt.FromStatusAndBytes(status, body)
+		if err != nil {
+			return nil, robotsRetryInterval
+		}
+		return robots.FindGroup(rc.policy.UserAgent), ttl
+	}
+}
+
+// get sends a single robots.txt request through the politeness limits, returning where
+// it redirects to instead of the body when upstream answers with a redirection.
+func (rc *robotsCache) get(
+	ctx context.Context, robotsURL *url.URL,
+) (status int, body []byte, location *url.URL, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	req.Header.Set("User-Agent", rc.policy.UserAgent)
+
+	release, _, err := rc.limiter.Acquire(ctx, robotsURL.Host)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer release()
+
+	var resp *http.Response
+	if resp, err = rc.transport.RoundTrip(req); err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		location, err = resp.Location()
+		return resp.StatusCode, nil, location, err
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	return resp.StatusCode, body, nil, err
+}
+
+// robotsAllowed checks the request against the upstream robots.txt.
+// Requests are allowed when robots.txt could not be loaded.
+func (proxy *CacheableProxy) robotsAllowed(r *http.Request) bool {
+	if proxy.robots == nil {
+		return true
+	}
+
+	group, refreshed := proxy.robots.group(r.Context(), proxy.targetURL.Scheme, proxy.targetURL.Host)
+	if group == nil {
+		return true
+	}
+	if refreshed && group.CrawlDelay > 0 && proxy.limiter != nil {
+		proxy.limiter.host(proxy.targetURL.Host).limitInterval(group.CrawlDelay)
+	}
+	return group.Test(r.URL.RequestURI())
+}
+
+func (proxy *CacheableProxy) denyByRobots(w http.ResponseWriter, state *requestState) {
+	state.status = CacheBypass
+	denyRequest(w, "disallowed by robots.txt for user-agent "+proxy.robots.policy.UserAgent)
+}
+
+func denyRequest(w http.ResponseWriter, reason string) {
+	w.Header().Set(HeaderDeniedReason, reason)
+	http.Error(w, reason, http.StatusForbidden)
+}
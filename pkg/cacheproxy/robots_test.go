@@ -0,0 +1,118 @@
+package cacheproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheableProxy_Robots(t *testing.T) {
+	var robotsFetches atomic.Int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			_, _ = w.Write([]byte("User-agent: radadar\nDisallow: /private\nCrawl-delay: 2\n\nUser-agent: *\nDisallow: /\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	}
+	proxy, storage := newTestProxy(t, upstream, WithRobotsPolicy(RobotsPolicy{UserAgent: "radadar"}))
+
+	// Pages cached before robots.txt disallowed them are still served
+	cachedReq := httptest.NewRequest(http.MethodGet, "/private/cached", nil)
+	_ = storage.Set(proxy.cacheKey(cachedReq), FileInformation{
+		Envelope: FileEnvelope{Status: http.StatusOK}, Content: []byte("cached"),
+		Checksum: checksum([]byte("cached")), CreatedAt: time.Now(), ModifiedAt: time.Now(),
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{name: "Allowed path", path: "/public/page", expectedStatus: http.StatusOK},
+		{name: "Disallowed path", path: "/private/page", expectedStatus: http.StatusForbidden},
+		{name: "Disallowed path with query", path: "/private?id=1", expectedStatus: http.StatusForbidden},
+		{name: "Cached disallowed path", path: "/private/cached", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			deniedReason := recorder.Header().Get(HeaderDeniedReason)
+			if (tt.expectedStatus == http.StatusForbidden) != (deniedReason != "") {
+				t.Errorf("Unexpected %s header: %q", HeaderDeniedReason, deniedReason)
+			}
+		})
+	}
+
+	if fetches := robotsFetches.Load(); fetches != 1 {
+		t.Errorf("Expected robots.txt to be fetched once, got %d", fetches)
+	}
+
+	// Crawl-delay must be applied to the upstream limiter
+	host := proxy.limiter.host(proxy.targetURL.Host)
+	if interval := time.Duration(float64(time.Second) / host.rate); interval != 2*time.Second {
+		t.Errorf("Expected crawl delay of 2s, got %v", interval)
+	}
+}
+
+func TestRobotsCache_Fetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		allowed     bool
+		expectedTTL time.Duration
+	}{
+		{
+			name: "Redirected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/robots.txt" {
+					http.Redirect(w, r, "/moved/robots.txt", http.StatusMovedPermanently)
+					return
+				}
+				_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			},
+			allowed:     true,
+			expectedTTL: defaultRobotsRefresh,
+		},
+		{
+			name: "Server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedTTL: robotsRetryInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			rc := &robotsCache{
+				policy:    RobotsPolicy{UserAgent: "radadar", RefreshInterval: defaultRobotsRefresh},
+				transport: http.DefaultTransport,
+				limiter:   newUpstreamLimiter(PolitenessPolicy{}),
+			}
+
+			group, ttl := rc.fetch(context.Background(), "http", strings.TrimPrefix(server.URL, "http://"))
+			if group == nil {
+				t.Fatal("Expected robots rules to be loaded")
+			}
+			if allowed := group.Test("/page"); allowed != tt.allowed {
+				t.Errorf("Expected /page allowed to be %v, got %v", tt.allowed, allowed)
+			}
+			if ttl != tt.expectedTTL {
+				t.Errorf("Expected TTL %v, got %v", tt.expectedTTL, ttl)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package cacheproxy
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is an append-only file that is renamed to path.1 once it reaches
+// MaxBytes, shifting older backups up to MaxBackups.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile opens, or creates, the file at path. A maxBytes of zero disables rotation.
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rotating := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := rotating.open(); err != nil {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Join(err, file.Close())
+	}
+	rf.file, rf.size = file, info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(content []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(content)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	written, err := rf.file.Write(content)
+	rf.size += int64(written)
+	return written, err
+}
+
+// rotate closes the current file and moves it to the first backup position.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return rf.open()
+	}
+
+	_ = os.Remove(rf.backupPath(rf.maxBackups))
+	for index := rf.maxBackups - 1; index >= 1; index-- {
+		err := os.Rename(rf.backupPath(index), rf.backupPath(index+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.path, rf.backupPath(1)); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) backupPath(index int) string {
+	return rf.path + "." + strconv.Itoa(index)
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return rf.file.Close()
+}
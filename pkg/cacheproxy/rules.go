@@ -0,0 +1,199 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type (
+	// BodyCanonicalizer rewrites a request body into a stable form before it is hashed.
+	BodyCanonicalizer func(body []byte) []byte
+
+	// CacheRule allows requests with non-idempotent methods to be cached.
+	// Matching requests are keyed with a hash of their canonicalized body.
+	CacheRule struct {
+		PathPrefix   string
+		Methods      []string // Empty means every method
+		Canonicalize BodyCanonicalizer
+	}
+)
+
+// WithCacheRules enables caching for the non-idempotent requests matched by the rules.
+func WithCacheRules(rules ...CacheRule) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		var current []CacheRule
+		if loaded := proxy.rules.Load(); loaded != nil {
+			current = *loaded
+		}
+		proxy.SetCacheRules(append(slices.Clone(current), rules...)...)
+	}
+}
+
+// SetCacheRules replaces the rules of a running proxy. Requests already being
+// handled keep the decisions taken with the previous rules.
+func (proxy *CacheableProxy) SetCacheRules(rules ...CacheRule) {
+	rules = slices.Clone(rules)
+	proxy.rules.Store(&rules)
+}
+
+func isSafeMethod(method string) bool {
+	return method == "" || method == http.MethodGet || method == http.MethodHead
+}
+
+func (proxy *CacheableProxy) matchRule(req *http.Request) (CacheRule, bool) {
+	rules := proxy.rules.Load()
+	if rules == nil {
+		return CacheRule{}, false
+	}
+	for _, rule := range *rules {
+		if rule.matches(req) {
+			return rule, true
+		}
+	}
+	return CacheRule{}, false
+}
+
+func (rule CacheRule) matches(req *http.Request) bool {
+	if !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	return len(rule.Methods) == 0 || slices.ContainsFunc(rule.Methods, func(method string) bool {
+		return strings.EqualFold(method, req.Method)
+	})
+}
+
+func (rule CacheRule) bodyKeySuffix(req *http.Request) string {
+	body, err := requestBody(req)
+	if err != nil {
+		return ""
+	}
+	if rule.Canonicalize != nil {
+		body = rule.Canonicalize(body)
+	}
+	return "|body=" + bodyHash(body)
+}
+
+// CanonicalJSON re-encodes a JSON body with sorted object keys and no insignificant whitespace.
+// Bodies that are not valid JSON are returned unchanged.
+func CanonicalJSON(body []byte) []byte {
+	value, ok := decodeJSON(body)
+	if !ok {
+		return body
+	}
+	return encodeJSON(value, body)
+}
+
+// CanonicalGraphQL canonicalizes a GraphQL over HTTP body, normalizing the whitespace of
+// every query besides sorting the keys of the variables and of the whole payload.
+func CanonicalGraphQL(body []byte) []byte {
+	value, ok := decodeJSON(body)
+	if !ok {
+		return body
+	}
+
+	switch payload := value.(type) {
+	case map[string]any:
+		normalizeGraphQLPayload(payload)
+	case []any: // Batched operations
+		for _, operation := range payload {
+			if opPayload, isMap := operation.(map[string]any); isMap {
+				normalizeGraphQLPayload(opPayload)
+			}
+		}
+	}
+	return encodeJSON(value, body)
+}
+
+func decodeJSON(body []byte) (value any, ok bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, !decoder.More()
+}
+
+func encodeJSON(value any, fallback []byte) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fallback
+	}
+	return encoded
+}
+
+func normalizeGraphQLPayload(payload map[string]any) {
+	if query, ok := payload["query"].(string); ok {
+		payload["query"] = NormalizeGraphQLQuery(query)
+	}
+}
+
+// NormalizeGraphQLQuery removes comments and insignificant whitespace/commas from a query,
+// keeping a single space only where it is required to separate two names.
+func NormalizeGraphQLQuery(query string) string {
+	var (
+		builder     strings.Builder
+		pendingGap  bool
+		lastWritten rune
+		runes       = []rune(query)
+	)
+	isNameRune := func(r rune) bool {
+		return r == '_' || r == '$' || r == '@' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for index := 0; index < len(runes); index++ {
+		current := runes[index]
+		switch {
+		case current == '#': // Comment until the end of line
+			for index < len(runes) && runes[index] != '\n' {
+				index++
+			}
+			pendingGap = true
+			continue
+		case current == ',' || unicode.IsSpace(current):
+			pendingGap = true
+			continue
+		}
+
+		if pendingGap && isNameRune(lastWritten) && isNameRune(current) {
+			builder.WriteRune(' ')
+		}
+		pendingGap = false
+
+		if current == '"' { // Copy string literals verbatim
+			end := stringLiteralEnd(runes, index)
+			builder.WriteString(string(runes[index:end]))
+			index, lastWritten = end-1, current
+			continue
+		}
+		builder.WriteRune(current)
+		lastWritten = current
+	}
+	return builder.String()
+}
+
+// stringLiteralEnd returns the index right after the string, or block string, starting at start.
+func stringLiteralEnd(runes []rune, start int) int {
+	delimiter := []rune(`"`)
+	if hasRunePrefix(runes[start:], []rune(`"""`)) {
+		delimiter = []rune(`"""`)
+	}
+
+	for end := start + len(delimiter); end < len(runes); end++ {
+		if runes[end] == '\\' {
+			end++
+			continue
+		}
+		if hasRunePrefix(runes[end:], delimiter) {
+			return end + len(delimiter)
+		}
+	}
+	return len(runes)
+}
+
+func hasRunePrefix(runes, prefix []rune) bool {
+	return len(runes) >= len(prefix) && slices.Equal(runes[:len(prefix)], prefix)
+}
@@ -0,0 +1,125 @@
+package cacheproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "Sort object keys", body: `{"b": 1, "a": {"d": 2, "c": 3}}`, expected: `{"a":{"c":3,"d":2},"b":1}`},
+		{name: "Keep big numbers", body: `{"id": 12345678901234567890}`, expected: `{"id":12345678901234567890}`},
+		{name: "Invalid JSON unchanged", body: `name=value`, expected: `name=value`},
+		{name: "Trailing data unchanged", body: `{} {}`, expected: `{} {}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := string(CanonicalJSON([]byte(tt.body))); result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCanonicalGraphQL(t *testing.T) {
+	first := `{
+		"variables": {"limit": 10, "after": "x"},
+		"query": "query Search($after: String, $limit: Int) {\n  items(after: $after, limit: $limit) {\n    id # the identifier\n    title\n  }\n}"
+	}`
+	second := `{"query":"query Search($after:String $limit:Int){items(after:$after limit:$limit){id title}}","variables":{"after":"x","limit":10}}`
+
+	firstCanonical, secondCanonical := CanonicalGraphQL([]byte(first)), CanonicalGraphQL([]byte(second))
+	if string(firstCanonical) != string(secondCanonical) {
+		t.Errorf("Expected equivalent bodies to match:\n%s\n%s", firstCanonical, secondCanonical)
+	}
+}
+
+func TestNormalizeGraphQLQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "Collapse whitespace", query: "{  user ( id : 1 ) {  name  } }", expected: "{user(id:1){name}}"},
+		{name: "Keep separator between names", query: "query   Q { a\n b }", expected: "query Q{a b}"},
+		{name: "Commas are insignificant", query: "{a, b,,c}", expected: "{a b c}"},
+		{name: "Drop comments", query: "{a # comment, here\n b}", expected: "{a b}"},
+		{name: "Keep string literals", query: `{a(s: "x  ,  y") b(s: "q\"  ")}`, expected: `{a(s:"x  ,  y")b(s:"q\"  ")}`},
+		{name: "Keep block strings", query: `{a(s: """ "  " """)}`, expected: `{a(s:""" "  " """)}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := NormalizeGraphQLQuery(tt.query); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_PostRules(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write(CanonicalJSON(body))
+	}
+
+	tests := []struct {
+		name          string
+		rules         []CacheRule
+		bodies        []string
+		expectedCalls int32
+		expectedKeys  int
+	}{
+		{
+			name:          "POST without rule is never cached",
+			bodies:        []string{`{"a":1}`, `{"a":1}`},
+			expectedCalls: 2, expectedKeys: 0,
+		},
+		{
+			name:          "POST with rule is cached by body",
+			rules:         []CacheRule{{PathPrefix: "/graphql", Canonicalize: CanonicalJSON}},
+			bodies:        []string{`{"a":1,"b":2}`, `{"b": 2, "a": 1}`, `{"a":2}`},
+			expectedCalls: 2, expectedKeys: 2,
+		},
+		{
+			name:          "Rule for another method",
+			rules:         []CacheRule{{Methods: []string{http.MethodPut}}},
+			bodies:        []string{`{"a":1}`, `{"a":1}`},
+			expectedCalls: 2, expectedKeys: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamCalls.Store(0)
+			proxy, storage := newTestProxy(t, upstream, WithCacheRules(tt.rules...))
+
+			for _, body := range tt.bodies {
+				req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+				recorder := serveProxy(proxy, req)
+				if expected := string(CanonicalJSON([]byte(body))); recorder.Body.String() != expected {
+					t.Errorf("Expected response body %s, got %s", expected, recorder.Body.String())
+				}
+			}
+
+			if calls := upstreamCalls.Load(); calls != tt.expectedCalls {
+				t.Errorf("Expected %d upstream calls, got %d", tt.expectedCalls, calls)
+			}
+			if keys := storage.Len(); keys != tt.expectedKeys {
+				t.Errorf("Expected %d cached keys, got %d", tt.expectedKeys, keys)
+			}
+		})
+	}
+}
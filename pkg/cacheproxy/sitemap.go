@@ -0,0 +1,163 @@
+package cacheproxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// maxSitemapDepth limits how many sitemap indexes can be nested
+	maxSitemapDepth = 4
+	// maxSourceSize bounds a URL source, sitemaps are limited to 50MB uncompressed
+	maxSourceSize = 50 << 20
+)
+
+var (
+	ErrSitemapTooDeep = errors.New("sitemap indexes nested too deep")
+	ErrSourceTooLarge = errors.New("URL source is larger than 50MB")
+)
+
+type (
+	// URLSourceFetcher reads the content at a remote location, used to load sitemaps.
+	URLSourceFetcher func(ctx context.Context, location string) ([]byte, error)
+	sitemapLocation  struct {
+		Loc string `xml:"loc"`
+	}
+	// sitemapDocument decodes both a sitemap urlset and a sitemap index.
+	sitemapDocument struct {
+		XMLName  xml.Name
+		URLs     []sitemapLocation `xml:"url"`
+		Sitemaps []sitemapLocation `xml:"sitemap"`
+	}
+)
+
+// LoadURLs reads the URLs listed at the given location, which can be an http(s) URL
+// or a local file. Sitemaps, sitemap indexes and gzipped sitemaps are followed,
+// anything else is read as a URL list with one URL per line, where blank lines
+// and lines starting with # are ignored.
+func LoadURLs(ctx context.Context, location string, fetch URLSourceFetcher) ([]string, error) {
+	if fetch == nil {
+		fetch = func(ctx context.Context, location string) ([]byte, error) {
+			return fetchLocation(ctx, http.DefaultTransport, location)
+		}
+	}
+	seen := make(map[string]struct{})
+	return loadURLs(ctx, location, fetch, seen, 0)
+}
+
+func loadURLs(
+	ctx context.Context, location string, fetch URLSourceFetcher,
+	seen map[string]struct{}, depth int,
+) ([]string, error) {
+	if depth > maxSitemapDepth {
+		return nil, fmt.Errorf("%w: %s", ErrSitemapTooDeep, location)
+	}
+	if _, ok := seen[location]; ok {
+		return nil, nil
+	}
+	seen[location] = struct{}{}
+
+	content, err := fetch(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = gunzipIfNeeded(content); err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", location, err)
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return ReadURLList(bytes.NewReader(content))
+	}
+
+	var document sitemapDocument
+	if err = xml.Unmarshal(trimmed, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap %s: %w", location, err)
+	}
+	urls := make([]string, 0, len(document.URLs))
+	for _, entry := range document.URLs {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	for _, child := range document.Sitemaps {
+		childURLs, childErr := loadURLs(ctx, strings.TrimSpace(child.Loc), fetch, seen, depth+1)
+		if childErr != nil {
+			return nil, childErr
+		}
+		urls = append(urls, childURLs...)
+	}
+	return urls, nil
+}
+
+// ReadURLList reads one URL per line, skipping blank lines and # comments.
+func ReadURLList(reader io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+func gunzipIfNeeded(content []byte) ([]byte, error) {
+	if len(content) < 2 || content[0] != 0x1f || content[1] != 0x8b {
+		return content, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readSource(reader)
+}
+
+// readSource reads the whole source, failing instead of loading more than maxSourceSize.
+func readSource(reader io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(reader, maxSourceSize+1))
+	if err == nil && len(content) > maxSourceSize {
+		return nil, ErrSourceTooLarge
+	}
+	return content, err
+}
+
+// fetchLocation reads local files directly and downloads anything with an http(s) scheme
+// through the transport.
+func fetchLocation(ctx context.Context, transport http.RoundTripper, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readSource(file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", location, resp.StatusCode)
+	}
+	return readSource(resp.Body)
+}
@@ -0,0 +1,314 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys used on FileInformation.ExtraMetadata by stream recordings
+const (
+	MetadataStream          = "stream"
+	MetadataStreamTruncated = "stream-truncated"
+)
+
+// streamKind tells which long-lived response a request is for
+type streamKind string
+
+const (
+	streamNone      streamKind = ""
+	streamEvents    streamKind = "sse"
+	streamWebsocket streamKind = "websocket"
+	// streamUpgrade is any other protocol upgrade, passed through but never recorded
+	streamUpgrade streamKind = "upgrade"
+)
+
+var errStreamRecording = errors.New("stream recordings are only replayed in offline mode")
+
+type (
+	// StreamRecordPolicy limits what is kept of each recorded stream. Once a limit
+	// is reached, the remaining frames are passed through without being recorded.
+	StreamRecordPolicy struct {
+		MaxFrames int
+		MaxBytes  int
+	}
+	// streamFrame is a single server-sent event, or a WebSocket message.
+	streamFrame struct {
+		// Offset is the time passed since the stream opened
+		Offset     time.Duration `json:"offset"`
+		FromClient bool          `json:"from_client,omitempty"`
+		// AfterClientFrames is how many client messages preceded a server message
+		AfterClientFrames int    `json:"after_client_frames,omitempty"`
+		Binary            bool   `json:"binary,omitempty"`
+		Data              []byte `json:"data"`
+	}
+	streamRecorder struct {
+		mutex        sync.Mutex
+		policy       StreamRecordPolicy
+		start        time.Time
+		frames       []streamFrame
+		size         int
+		clientFrames int
+		truncated    bool
+		finish       sync.Once
+		save         func(frames []streamFrame, truncated bool)
+	}
+)
+
+// WithStreamRecording stores the events of SSE streams and the messages of WebSockets
+// passing through the proxy, so WithOfflineMode can replay them later.
+func WithStreamRecording(policy StreamRecordPolicy) ProxyOption {
+	if policy.MaxFrames <= 0 {
+		policy.MaxFrames = 1000
+	}
+	if policy.MaxBytes <= 0 {
+		policy.MaxBytes = 1 << 20
+	}
+	return func(proxy *CacheableProxy) {
+		proxy.streamRecording = &policy
+	}
+}
+
+func isWebsocketUpgrade(req *http.Request) bool {
+	return headerHasToken(req.Header, "Connection", "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+func acceptsEventStream(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestStream detects, before contacting upstream, the requests that open a stream.
+func requestStream(req *http.Request) streamKind {
+	switch {
+	case isWebsocketUpgrade(req):
+		return streamWebsocket
+	case headerHasToken(req.Header, "Connection", "upgrade"):
+		return streamUpgrade
+	case acceptsEventStream(req):
+		return streamEvents
+	}
+	return streamNone
+}
+
+// responseStream detects the responses that must be passed through without being read.
+func responseStream(resp *http.Response) streamKind {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		if strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+			return streamWebsocket
+		}
+		return streamUpgrade
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+		return streamEvents
+	}
+	return streamNone
+}
+
+func isStreamRecording(info FileInformation) bool {
+	return info.ExtraMetadata[MetadataStream] != ""
+}
+
+// passStream lets a streaming response through untouched, recording it when enabled.
+func (proxy *CacheableProxy) passStream(resp *http.Response, state *requestState, kind streamKind) {
+	state.stream, state.status = kind, CacheBypass
+	// A stream may stay open for hours, it must not hold an upstream slot meanwhile
+	if state.releaseUpstream != nil {
+		state.releaseUpstream()
+	}
+	if proxy.streamRecording == nil || state.key == "" {
+		return
+	}
+
+	recorder := &streamRecorder{
+		policy: *proxy.streamRecording,
+		start:  time.Now(),
+		save:   proxy.streamSaver(resp, state.key, kind),
+	}
+	switch kind {
+	case streamEvents:
+		resp.Body = &eventStreamRecorder{ReadCloser: resp.Body, recorder: recorder}
+	case streamWebsocket:
+		// The body of an upgrade response is the connection itself, read and written by the ReverseProxy
+		if conn, ok := resp.Body.(io.ReadWriteCloser); ok {
+			resp.Body = &websocketRecorder{
+				ReadWriteCloser: conn,
+				recorder:        recorder,
+				server:          wsMessageReader{maxBuffer: recorder.policy.MaxBytes},
+				client:          wsMessageReader{maxBuffer: recorder.policy.MaxBytes},
+			}
+		}
+	}
+}
+
+func (proxy *CacheableProxy) streamSaver(
+	resp *http.Response, key string, kind streamKind,
+) func([]streamFrame, bool) {
+	fileURL := resp.Request.RequestURI
+	header := proxy.storedHeaders(resp.Header)
+	status := uint16(resp.StatusCode)
+	return func(frames []streamFrame, truncated bool) {
+		content, err := json.Marshal(frames)
+		if err != nil {
+			slog.Error("[ PROXY SERVER ] Failed to encode stream", slog.String("error", err.Error()))
+			return
+		}
+		now := time.Now()
+		info := FileInformation{
+			FileMIME:      FileMIME{Name: fileURL, MimeType: header.Get("Content-Type")},
+			Envelope:      FileEnvelope{Headers: header, Status: status},
+			Content:       content,
+			Checksum:      checksum(content),
+			CreatedAt:     now,
+			ModifiedAt:    now,
+			ExtraMetadata: map[string]string{MetadataStream: string(kind)},
+		}
+		if truncated {
+			info.ExtraMetadata[MetadataStreamTruncated] = "true"
+		}
+		if err = proxy.store(key, info); err != nil {
+			slog.Error(
+				"[ PROXY SERVER ] Failed to store stream",
+				slog.String("key", key), slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+func (recorder *streamRecorder) add(frame streamFrame) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.truncated {
+		return
+	}
+	if len(recorder.frames) >= recorder.policy.MaxFrames ||
+		recorder.size+len(frame.Data) > recorder.policy.MaxBytes {
+		recorder.truncated = true
+		return
+	}
+
+	frame.Offset = time.Since(recorder.start)
+	if frame.FromClient {
+		recorder.clientFrames++
+	} else {
+		frame.AfterClientFrames = recorder.clientFrames
+	}
+	recorder.frames = append(recorder.frames, frame)
+	recorder.size += len(frame.Data)
+}
+
+// close stores the recording, once the stream ended from either side.
+func (recorder *streamRecorder) close() {
+	recorder.finish.Do(func() {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		recorder.save(recorder.frames, recorder.truncated)
+	})
+}
+
+// eventStreamRecorder splits the SSE body read by the client into events.
+type eventStreamRecorder struct {
+	io.ReadCloser
+	recorder *streamRecorder
+	pending  []byte
+}
+
+func (body *eventStreamRecorder) Read(content []byte) (int, error) {
+	read, err := body.ReadCloser.Read(content)
+	body.pending = append(body.pending, content[:read]...)
+	for {
+		event, rest, found := cutEvent(body.pending)
+		if !found {
+			break
+		}
+		body.recorder.add(streamFrame{Data: event})
+		body.pending = rest
+	}
+	if len(body.pending) > body.recorder.policy.MaxBytes {
+		body.pending = nil
+	}
+	if err != nil {
+		body.recorder.close()
+	}
+	return read, err
+}
+
+func (body *eventStreamRecorder) Close() error {
+	err := body.ReadCloser.Close()
+	body.recorder.close()
+	return err
+}
+
+// cutEvent returns the first complete event of an SSE stream, without the blank line ending it.
+func cutEvent(stream []byte) (event, rest []byte, found bool) {
+	end, separator := -1, 0
+	for _, candidate := range [][]byte{[]byte("\n\n"), []byte("\r\n\r\n"), []byte("\r\r")} {
+		if index := bytes.Index(stream, candidate); index >= 0 && (end < 0 || index < end) {
+			end, separator = index, len(candidate)
+		}
+	}
+	if end < 0 {
+		return nil, stream, false
+	}
+	return append([]byte(nil), stream[:end]...), stream[end+separator:], true
+}
+
+// websocketRecorder decodes the messages exchanged on an upgraded connection.
+// Reads carry the server messages and writes the client ones.
+type websocketRecorder struct {
+	io.ReadWriteCloser
+	recorder       *streamRecorder
+	server, client wsMessageReader
+}
+
+func (conn *websocketRecorder) Read(content []byte) (int, error) {
+	read, err := conn.ReadWriteCloser.Read(content)
+	conn.record(conn.server.feed(content[:read]), false)
+	// Once upstream stopped sending, nothing is left to replay
+	if err != nil {
+		conn.recorder.close()
+	}
+	return read, err
+}
+
+func (conn *websocketRecorder) Write(content []byte) (int, error) {
+	conn.record(conn.client.feed(content), true)
+	return conn.ReadWriteCloser.Write(content)
+}
+
+func (conn *websocketRecorder) Close() error {
+	err := conn.ReadWriteCloser.Close()
+	conn.recorder.close()
+	return err
+}
+
+func (conn *websocketRecorder) record(messages []wsFrame, fromClient bool) {
+	for _, message := range messages {
+		if message.opcode != wsOpText && message.opcode != wsOpBinary {
+			continue
+		}
+		conn.recorder.add(streamFrame{
+			FromClient: fromClient,
+			Binary:     message.opcode == wsOpBinary,
+			Data:       message.payload,
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package cacheproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebsocketKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// waitRecording polls the storage until the stream recording of the key is stored.
+func waitRecording(t *testing.T, storage *memoryStorage, key string) FileInformation {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if info, err := storage.Get(key); err == nil && isStreamRecording(info) {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected a stream recording for %s", key)
+	return FileInformation{}
+}
+
+func TestStream_EventsPassThroughAndReplay(t *testing.T) {
+	release := make(chan struct{})
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>page</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		_, _ = w.Write([]byte("data: two\n\n"))
+	},
+		WithStreamRecording(StreamRecordPolicy{}),
+		WithPoliteness(PolitenessPolicy{MaxInFlight: 1, Overflow: OverflowUnavailable}),
+	)
+	server := httptest.NewServer(http.HandlerFunc(proxy.Handler))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if cacheStatus := resp.Header.Get(HeaderCache); cacheStatus != string(CacheBypass) {
+		t.Errorf("Expected %s, got %s", CacheBypass, cacheStatus)
+	}
+
+	firstEvent := make(chan string, 1)
+	reader := bufio.NewReader(resp.Body)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		firstEvent <- line
+	}()
+	select {
+	case line := <-firstEvent:
+		if line != "data: one\n" {
+			t.Errorf("Expected the first event, got `%s`", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the first event before the stream ends")
+	}
+
+	// The open stream must not hold the only upstream slot
+	pageResp, err := http.Get(server.URL + "/page")
+	if err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	_ = pageResp.Body.Close()
+	if pageResp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 while the stream is open, got %d", pageResp.StatusCode)
+	}
+
+	close(release)
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "\ndata: two\n\n" {
+		t.Errorf("Expected the second event, got `%s`", rest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	key := proxy.cacheKey(req)
+	info := waitRecording(t, storage, key)
+	if info.ExtraMetadata[MetadataStream] != string(streamEvents) {
+		t.Errorf("Expected an SSE recording, got %v", info.ExtraMetadata)
+	}
+
+	// Online, the recording is never served as a regular response
+	recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if body, cacheStatus := recorder.Body.String(), recorder.Header().Get(HeaderCache); body != "data: one\n\ndata: two\n\n" ||
+		cacheStatus != string(CacheBypass) {
+		t.Errorf("Expected the live stream, got %s `%s`", cacheStatus, body)
+	}
+
+	offline, err := New(storage, proxy.targetURL.String(), 0, WithOfflineMode())
+	if err != nil {
+		t.Fatalf("Failed to create offline proxy: %v", err)
+	}
+	recorder = serveProxy(offline, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if body := recorder.Body.String(); body != "data: one\n\ndata: two\n\n" {
+		t.Errorf("Expected the recorded events, got `%s`", body)
+	}
+	if cacheStatus := recorder.Header().Get(HeaderCache); cacheStatus != string(CacheHit) {
+		t.Errorf("Expected %s, got %s", CacheHit, cacheStatus)
+	}
+}
+
+func TestOfflineMode(t *testing.T) {
+	storage := newMemoryStorage()
+	proxy, err := New(storage, "http://example.com", 0, WithOfflineMode(), WithCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	now := time.Now()
+	for path, modifiedAt := range map[string]time.Time{"/fresh": now, "/expired": now.Add(-2 * time.Hour)} {
+		content := []byte("content of " + path)
+		_ = storage.Set(proxy.cacheKey(httptest.NewRequest(http.MethodGet, path, nil)), FileInformation{
+			Envelope:   FileEnvelope{Status: http.StatusOK},
+			Content:    content,
+			Checksum:   checksum(content),
+			ModifiedAt: modifiedAt,
+		})
+	}
+
+	testCases := []struct {
+		path        string
+		expected    int
+		cacheStatus CacheStatus
+	}{
+		{path: "/fresh", expected: http.StatusOK, cacheStatus: CacheHit},
+		{path: "/expired", expected: http.StatusOK, cacheStatus: CacheStale},
+		{path: "/missing", expected: http.StatusGatewayTimeout, cacheStatus: CacheMiss},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.path, func(t *testing.T) {
+			recorder := serveProxy(proxy, httptest.NewRequest(http.MethodGet, tCase.path, nil))
+			if recorder.Code != tCase.expected {
+				t.Errorf("Expected %d, got %d", tCase.expected, recorder.Code)
+			}
+			if cacheStatus := recorder.Header().Get(HeaderCache); cacheStatus != string(tCase.cacheStatus) {
+				t.Errorf("Expected %s, got %s", tCase.cacheStatus, cacheStatus)
+			}
+		})
+	}
+}
+
+// echoWebsocket answers every client message with the same message prefixed by "echo:".
+func echoWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	_ = buffered.Flush()
+
+	reader := wsMessageReader{}
+	chunk := make([]byte, 512)
+	for {
+		read, readErr := buffered.Read(chunk)
+		for _, message := range reader.feed(chunk[:read]) {
+			if message.opcode == wsOpClose {
+				_ = writeWebsocketFrame(conn, wsOpClose, nil)
+				return
+			}
+			_ = writeWebsocketFrame(conn, wsOpText, append([]byte("echo:"), message.payload...))
+		}
+		if readErr != nil {
+			return
+		}
+	}
+}
+
+type testWebsocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	frames wsMessageReader
+}
+
+func dialWebsocket(t *testing.T, serverURL string, path string) *testWebsocket {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\n"+
+		"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testWebsocketKey+"\r\n\r\n")
+	socket := &testWebsocket{conn: conn, reader: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(socket.reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read the handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != websocketAcceptKey(testWebsocketKey) {
+		t.Fatalf("Expected the upgrade to be accepted, got %d %v", resp.StatusCode, resp.Header)
+	}
+	return socket
+}
+
+// send writes a masked frame, as clients must.
+func (socket *testWebsocket) send(t *testing.T, opcode byte, payload string) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask...)
+	for index := range len(payload) {
+		frame = append(frame, payload[index]^mask[index%4])
+	}
+	if _, err := socket.conn.Write(frame); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+func (socket *testWebsocket) receive(t *testing.T) string {
+	t.Helper()
+	chunk := make([]byte, 512)
+	for {
+		read, err := socket.reader.Read(chunk)
+		if messages := socket.frames.feed(chunk[:read]); len(messages) > 0 {
+			return string(messages[0].payload)
+		}
+		if err != nil {
+			t.Fatalf("Failed to receive message: %v", err)
+		}
+	}
+}
+
+func TestStream_WebsocketRecordAndReplay(t *testing.T) {
+	proxy, storage := newTestProxy(t, echoWebsocket, WithStreamRecording(StreamRecordPolicy{}))
+	server := httptest.NewServer(http.HandlerFunc(proxy.Handler))
+	t.Cleanup(server.Close)
+
+	socket := dialWebsocket(t, server.URL, "/socket")
+	socket.send(t, wsOpText, "hello")
+	if message := socket.receive(t); message != "echo:hello" {
+		t.Errorf("Expected the echoed message, got `%s`", message)
+	}
+	socket.send(t, wsOpClose, "")
+
+	info := waitRecording(t, storage, proxy.cacheKey(httptest.NewRequest(http.MethodGet, "/socket", nil)))
+	if info.ExtraMetadata[MetadataStream] != string(streamWebsocket) {
+		t.Errorf("Expected a WebSocket recording, got %v", info.ExtraMetadata)
+	}
+
+	offline, err := New(storage, proxy.targetURL.String(), 0, WithOfflineMode())
+	if err != nil {
+		t.Fatalf("Failed to create offline proxy: %v", err)
+	}
+	offlineServer := httptest.NewServer(http.HandlerFunc(offline.Handler))
+	t.Cleanup(offlineServer.Close)
+
+	replay := dialWebsocket(t, offlineServer.URL, "/socket")
+	replay.send(t, wsOpText, "hello")
+	if message := replay.receive(t); message != "echo:hello" {
+		t.Errorf("Expected the replayed message, got `%s`", message)
+	}
+	replay.send(t, wsOpClose, "")
+}
+
+// lineChannel is an access log destination that hands every line to the test.
+type lineChannel chan string
+
+func (lines lineChannel) Write(line []byte) (int, error) {
+	lines <- string(line)
+	return len(line), nil
+}
+
+func TestStream_WebsocketLogsUpgradeStatus(t *testing.T) {
+	lines := make(lineChannel, 1)
+	proxy, _ := newTestProxy(t, echoWebsocket, WithAccessLog(lines, AccessLogJSON))
+	server := httptest.NewServer(http.HandlerFunc(proxy.Handler))
+	t.Cleanup(server.Close)
+
+	socket := dialWebsocket(t, server.URL, "/socket")
+	socket.send(t, wsOpClose, "")
+	_ = socket.conn.Close()
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, `"status":101`) {
+			t.Errorf("Expected the upgrade status to be logged, got %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the WebSocket request to be logged")
+	}
+}
+
+func TestWebsocketFrames_Fragmented(t *testing.T) {
+	stream := []byte{0x01, 0x03, 'a', 'b', 'c', 0x89, 0x00, 0x80, 0x02, 'd', 'e'}
+	reader := wsMessageReader{}
+	var messages []wsFrame
+	for _, chunk := range [][]byte{stream[:4], stream[4:9], stream[9:]} {
+		messages = append(messages, reader.feed(chunk)...)
+	}
+	if len(messages) != 2 || messages[0].opcode != 0x9 ||
+		messages[1].opcode != wsOpText || string(messages[1].payload) != "abcde" {
+		t.Errorf("Expected a ping and the reassembled text message, got %+v", messages)
+	}
+}
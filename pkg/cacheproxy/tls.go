@@ -0,0 +1,134 @@
+package cacheproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+)
+
+// selfSignedValidity is how long generated certificates are valid for
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLSPolicy makes the proxy listener serve HTTPS, with HTTP/2 support.
+// Without certificate files, a self-signed certificate is generated for
+// localhost, the target host and the extra Hosts.
+type TLSPolicy struct {
+	CertFile string
+	KeyFile  string
+	Hosts    []string
+}
+
+// WithTLS serves the proxy over TLS according to the policy.
+func WithTLS(policy TLSPolicy) ProxyOption {
+	return func(proxy *CacheableProxy) {
+		proxy.tlsPolicy = &policy
+	}
+}
+
+// prepareTLS loads, or generates, the certificate served by the listener.
+func (proxy *CacheableProxy) prepareTLS() error {
+	if proxy.tlsPolicy == nil {
+		return nil
+	}
+
+	var (
+		certificate tls.Certificate
+		err         error
+	)
+	if proxy.tlsPolicy.CertFile != "" || proxy.tlsPolicy.KeyFile != "" {
+		certificate, err = tls.LoadX509KeyPair(proxy.tlsPolicy.CertFile, proxy.tlsPolicy.KeyFile)
+	} else {
+		hosts := append([]string{"localhost", "127.0.0.1", "::1", proxy.targetURL.Hostname()}, proxy.tlsPolicy.Hosts...)
+		if proxy.bindHost != "" {
+			hosts = append(hosts, proxy.bindHost)
+		}
+		certificate, err = selfSignedCertificate(hosts, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+	if certificate.Leaf == nil {
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	proxy.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return nil
+}
+
+// selfSignedCertificate creates an ECDSA certificate valid for the given DNS names and IPs.
+func selfSignedCertificate(hosts []string, now time.Time) (tls.Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"radadar cache proxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if !slices.Contains(template.DNSNames, host) {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject.CommonName = template.DNSNames[0]
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{derBytes}, PrivateKey: privateKey, Leaf: leaf}, nil
+}
+
+// CertificatePool returns a pool trusting the certificate served by the proxy,
+// for clients that must accept a self-signed one. It is nil when TLS is disabled.
+func (proxy *CacheableProxy) CertificatePool() *x509.CertPool {
+	if proxy.tlsConfig == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(proxy.tlsConfig.Certificates[0].Leaf)
+	return pool
+}
+
+// ServeScheme is the URL scheme clients must use to reach the proxy listener.
+func (proxy *CacheableProxy) ServeScheme() string {
+	if proxy.tlsConfig != nil {
+		return "https"
+	}
+	return "http"
+}
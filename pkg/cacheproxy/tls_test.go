@@ -0,0 +1,91 @@
+package cacheproxy
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificateFiles stores a generated certificate and key as PEM files.
+func writeCertificateFiles(t *testing.T) (string, string) {
+	t.Helper()
+	certificate, err := selfSignedCertificate([]string{"localhost", "127.0.0.1"}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	if err = os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestCacheableProxy_TLSListener(t *testing.T) {
+	certFile, keyFile := writeCertificateFiles(t)
+	tests := []struct {
+		name   string
+		policy TLSPolicy
+	}{
+		{name: "Self-signed certificate", policy: TLSPolicy{}},
+		{name: "Certificate files", policy: TLSPolicy{CertFile: certFile, KeyFile: keyFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("<html>secure</html>"))
+			}, WithTLS(tt.policy))
+			if proxy.ServeScheme() != "https" {
+				t.Errorf("Expected https scheme, got %s", proxy.ServeScheme())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			startFeedback := make(chan string, 1)
+			go func() { _ = proxy.Listen(ctx, startFeedback) }()
+			<-startFeedback
+
+			client := &http.Client{Transport: proxy.RedirectRoundTripper(), Timeout: 5 * time.Second}
+			resp, err := client.Get(proxy.targetURL.String() + "/page")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.TLS == nil || resp.ProtoMajor != 2 {
+				t.Errorf("Expected an HTTP/2 response over TLS, got %s", resp.Proto)
+			}
+			if string(body) != "<html>secure</html>" {
+				t.Errorf("Expected the proxied body, got `%s`", body)
+			}
+		})
+	}
+}
+
+func TestCacheableProxy_TLSMissingFiles(t *testing.T) {
+	_, err := New(newMemoryStorage(), "http://example.com", 0, WithTLS(TLSPolicy{
+		CertFile: filepath.Join(t.TempDir(), "missing.pem"), KeyFile: "missing.key",
+	}))
+	if err == nil {
+		t.Errorf("Expected New to fail with missing certificate files")
+	}
+}
@@ -0,0 +1,27 @@
+package cacheproxy
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jictyvoo/radadar_crawlsdk/pkg/cacheproxy"
+
+// traceContext propagates spans using the W3C trace context headers, whatever the global propagator is.
+var traceContext = propagation.TraceContext{}
+
+// startSpan uses the global tracer provider, so tracing stays disabled until one is registered.
+func startSpan(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,75 @@
+package cacheproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
+)
+
+func TestTracing_SpansShareTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var upstreamTraceParent string
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceParent = r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html>traced</html>"))
+	})
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxy.Handler))
+	defer proxyServer.Close()
+
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	origin, _ := url.Parse("http://example.com")
+	client := &http.Client{Transport: httptransport.NewTransportRewrite(origin, proxyURL.Host)}
+
+	resp, err := client.Get("http://example.com/page")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	expectedNames := []string{
+		"TransportRewrite.RoundTrip", "CacheableProxy.Handler",
+		"cache.lookup", "upstream.fetch", "cache.store",
+	}
+	root, ok := spans["TransportRewrite.RoundTrip"]
+	if !ok {
+		t.Fatalf("Expected a TransportRewrite.RoundTrip span, got %v", spans)
+	}
+	for _, name := range expectedNames {
+		span, found := spans[name]
+		if !found {
+			t.Errorf("Expected span %s to be recorded", name)
+			continue
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("Expected span %s to share the trace %s, got %s",
+				name, root.SpanContext.TraceID(), span.SpanContext.TraceID())
+		}
+	}
+
+	handler := spans["CacheableProxy.Handler"]
+	if handler.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Errorf("Expected the handler span to be a child of the transport span")
+	}
+	if upstreamTraceParent == "" {
+		t.Errorf("Expected the upstream request to carry a traceparent header")
+	}
+}
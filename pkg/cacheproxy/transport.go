@@ -0,0 +1,162 @@
+package cacheproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
+)
+
+var errHandlerAborted = errors.New("cache proxy handler aborted the response")
+
+type (
+	// Transport is an http.RoundTripper serving the requests for the proxy target through the
+	// proxy Handler, in process, so the cache is used without a listener nor a network hop.
+	// The access policy is not checked, and connection upgrades like WebSocket are not supported.
+	Transport struct {
+		proxy *CacheableProxy
+		// Fallback receives the requests for other hosts, httptransport.DefaultTransport when nil
+		Fallback http.RoundTripper
+	}
+	// pipeResponseWriter streams the response written by the Handler to the body read by the client.
+	pipeResponseWriter struct {
+		header     http.Header
+		sentHeader http.Header
+		status     int
+		pipe       *io.PipeWriter
+		once       sync.Once
+		ready      chan struct{}
+		abortErr   error
+		// discard drops the body of HEAD responses, which the client never reads
+		discard bool
+	}
+)
+
+// NewTransport creates a proxy for the target, returning the Transport that serves it in process.
+func NewTransport(storage CacheStorage, targetURL string, options ...ProxyOption) (*Transport, error) {
+	proxy, err := New(storage, targetURL, 0, options...)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.InProcessTransport(), nil
+}
+
+// InProcessTransport returns a Transport serving the requests for the target through this proxy.
+func (proxy *CacheableProxy) InProcessTransport() *Transport {
+	return &Transport{proxy: proxy}
+}
+
+func (transport *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.EqualFold(req.URL.Host, transport.proxy.targetURL.Host) {
+		fallback := transport.Fallback
+		if fallback == nil {
+			fallback = httptransport.DefaultTransport
+		}
+		return fallback.RoundTrip(req)
+	}
+
+	// The Handler expects a server request, holding only the path and query on its URL
+	inbound := req.Clone(req.Context())
+	inbound.URL = &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	inbound.RequestURI = inbound.URL.RequestURI()
+	inbound.Host = transport.proxy.targetURL.Host
+	if inbound.Body == nil {
+		inbound.Body = http.NoBody
+	}
+
+	reader, pipe := io.Pipe()
+	writer := &pipeResponseWriter{
+		header: make(http.Header), pipe: pipe, ready: make(chan struct{}), discard: req.Method == http.MethodHead,
+	}
+	go transport.serve(writer, inbound)
+
+	select {
+	case <-writer.ready:
+	case <-req.Context().Done():
+		_ = reader.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+	if writer.abortErr != nil {
+		return nil, writer.abortErr
+	}
+
+	contentLength := int64(-1)
+	if rawLength := writer.sentHeader.Get("Content-Length"); rawLength != "" {
+		if parsed, err := strconv.ParseInt(rawLength, 10, 64); err == nil {
+			contentLength = parsed
+		}
+	}
+	var body io.ReadCloser = reader
+	if req.Method == http.MethodHead {
+		_ = reader.Close()
+		body = http.NoBody
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", writer.status, http.StatusText(writer.status)),
+		StatusCode:    writer.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        writer.sentHeader,
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// serve runs the Handler, closing the body once it returns. The reverse proxy aborts
+// by panicking with http.ErrAbortHandler, which is reported as an error to the client.
+func (transport *Transport) serve(writer *pipeResponseWriter, inbound *http.Request) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := errHandlerAborted
+			if recovered != http.ErrAbortHandler {
+				err = fmt.Errorf("%w: %v", errHandlerAborted, recovered)
+			}
+			writer.once.Do(func() {
+				writer.abortErr = err
+				close(writer.ready)
+			})
+			_ = writer.pipe.CloseWithError(err)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		_ = writer.pipe.Close()
+	}()
+	transport.proxy.Handler(writer, inbound)
+}
+
+func (pw *pipeResponseWriter) Header() http.Header {
+	return pw.header
+}
+
+func (pw *pipeResponseWriter) WriteHeader(statusCode int) {
+	// Informational responses, like 103 Early Hints, are not the final response
+	if statusCode >= 100 && statusCode < 200 {
+		return
+	}
+	pw.once.Do(func() {
+		pw.status = statusCode
+		pw.sentHeader = pw.header.Clone()
+		close(pw.ready)
+	})
+}
+
+func (pw *pipeResponseWriter) Write(content []byte) (int, error) {
+	pw.WriteHeader(http.StatusOK)
+	if pw.discard {
+		return len(content), nil
+	}
+	return pw.pipe.Write(content)
+}
+
+// Flush sends the headers, the body is already given to the client as it is written.
+func (pw *pipeResponseWriter) Flush() {
+	pw.WriteHeader(http.StatusOK)
+}
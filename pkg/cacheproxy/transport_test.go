@@ -0,0 +1,154 @@
+package cacheproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jictyvoo/radadar_crawlsdk/internal/repositories/datasources/dsrest"
+)
+
+func TestTransport_CachesInProcess(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html>"+r.URL.RequestURI()+"</html>")
+	})
+	client := &http.Client{Transport: proxy.InProcessTransport(), Timeout: 5 * time.Second}
+
+	for _, expected := range []CacheStatus{CacheMiss, CacheHit} {
+		resp, err := client.Get(proxy.targetURL.String() + "/page?id=1")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "<html>/page?id=1</html>" {
+			t.Errorf("Expected the upstream page, got %d `%s`", resp.StatusCode, body)
+		}
+		if status := CacheStatus(resp.Header.Get(HeaderCache)); status != expected {
+			t.Errorf("Expected %s, got %s", expected, status)
+		}
+	}
+	if upstreamCalls.Load() != 1 || storage.Len() != 1 {
+		t.Errorf("Expected a single upstream call and entry, got %d and %d", upstreamCalls.Load(), storage.Len())
+	}
+}
+
+func TestTransport_HTTPDatasource(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html>"+r.URL.Path+"</html>")
+	})
+	datasource := dsrest.NewHTTPDatasource(proxy.InProcessTransport())
+	defer datasource.Close()
+
+	for range 2 {
+		page, err := datasource.DownloadPage(context.Background(), proxy.targetURL.String()+"/page")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		if page != "<html>/page</html>" {
+			t.Errorf("Expected the upstream page, got `%s`", page)
+		}
+	}
+	resp, err := datasource.Head(proxy.targetURL.String()+"/page", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if status := CacheStatus(http.Header(resp.Headers).Get(HeaderCache)); status != CacheHit {
+		t.Errorf("Expected the HEAD to be served from the cache, got %s", status)
+	}
+	if upstreamCalls.Load() != 1 {
+		t.Errorf("Expected a single upstream call, got %d", upstreamCalls.Load())
+	}
+}
+
+func TestTransport_FallbackForOtherHosts(t *testing.T) {
+	proxy, storage := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the target to not be requested")
+	})
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "other")
+	}))
+	defer other.Close()
+
+	transport := proxy.InProcessTransport()
+	transport.Fallback = http.DefaultTransport
+	resp, err := (&http.Client{Transport: transport}).Get(other.URL + "/page")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "other" || storage.Len() != 0 {
+		t.Errorf("Expected the other host to be reached directly, got `%s`", body)
+	}
+}
+
+func TestTransport_StreamsEvents(t *testing.T) {
+	release := make(chan struct{})
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	})
+	defer close(release)
+
+	req := httptest.NewRequest(http.MethodGet, proxy.targetURL.String()+"/events", nil)
+	req.RequestURI = ""
+	resp, err := proxy.InProcessTransport().RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: first") {
+		t.Errorf("Expected the first event before the stream ends, got `%s` %v", line, err)
+	}
+}
+
+func TestTransport_HeadDiscardsBody(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	defer slog.SetDefault(previous)
+	page := strings.Repeat("<p>content</p>", 1<<12)
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, page)
+	})
+
+	client := &http.Client{Transport: proxy.InProcessTransport(), Timeout: 5 * time.Second}
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodHead} {
+		req, _ := http.NewRequest(method, proxy.targetURL.String()+"/page", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || (method == http.MethodHead && len(body) != 0) {
+			t.Errorf("Expected %s to answer 200 without a body, got %d with %d bytes", method, resp.StatusCode, len(body))
+		}
+	}
+	// The handler may still be writing once the client has the headers
+	for proxy.metrics.inFlight.Value() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected the HEAD bodies to be discarded quietly, got:\n%s", logs.String())
+	}
+}
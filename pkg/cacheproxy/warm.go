@@ -0,0 +1,172 @@
+package cacheproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const defaultWarmConcurrency = 4
+
+var ErrOutsideTarget = errors.New("URL does not belong to the proxy target")
+
+type (
+	// WarmOptions configures how the cache is primed.
+	WarmOptions struct {
+		Concurrency int // Requests handled at the same time, the politeness limits still apply
+		// Progress is called after every URL, from the goroutine that fetched it
+		Progress func(progress WarmProgress)
+	}
+	// WarmProgress describes the outcome of a single URL while warming.
+	WarmProgress struct {
+		URL         string
+		Status      int
+		CacheStatus CacheStatus
+		Err         error
+		Completed   int
+		Total       int
+	}
+	WarmFailure struct {
+		URL string
+		Err error
+	}
+	// WarmReport summarizes a warm up.
+	WarmReport struct {
+		Total     int
+		Succeeded int
+		Failures  []WarmFailure
+	}
+)
+
+// WarmFrom primes the cache with every URL listed at the location, as read by LoadURLs.
+func (proxy *CacheableProxy) WarmFrom(
+	ctx context.Context, location string, options WarmOptions,
+) (WarmReport, error) {
+	urls, err := proxy.LoadURLs(ctx, location)
+	if err != nil {
+		return WarmReport{}, err
+	}
+	return proxy.Warm(ctx, urls, options), nil
+}
+
+// LoadURLs reads the URLs listed at the location, as the package LoadURLs does.
+// Sitemaps hosted on the proxy target are fetched through the proxy itself,
+// and the other remote ones with the proxy upstream transport.
+func (proxy *CacheableProxy) LoadURLs(ctx context.Context, location string) ([]string, error) {
+	return LoadURLs(ctx, location, proxy.fetchSource)
+}
+
+// Warm requests every URL through the proxy Handler, without opening a listener,
+// so the politeness limits, robots rules and tracked MIME types are applied as usual.
+func (proxy *CacheableProxy) Warm(ctx context.Context, urls []string, options WarmOptions) WarmReport {
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmConcurrency
+	}
+
+	var (
+		report   = WarmReport{Total: len(urls)}
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		urlsChan = make(chan string)
+	)
+	for range min(concurrency, max(len(urls), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rawURL := range urlsChan {
+				writer := newInProcessWriter(false)
+				err := proxy.serveInProcess(ctx, rawURL, writer)
+				if err == nil && writer.status >= http.StatusBadRequest {
+					err = fmt.Errorf("upstream answered with status %d", writer.status)
+				}
+
+				mutex.Lock()
+				if err != nil {
+					report.Failures = append(report.Failures, WarmFailure{URL: rawURL, Err: err})
+				} else {
+					report.Succeeded++
+				}
+				progress := WarmProgress{
+					URL: rawURL, Status: writer.status, Err: err,
+					CacheStatus: CacheStatus(writer.Header().Get(HeaderCache)),
+					Completed:   report.Succeeded + len(report.Failures), Total: report.Total,
+				}
+				mutex.Unlock()
+				if options.Progress != nil {
+					options.Progress(progress)
+				}
+			}
+		}()
+	}
+
+	dispatched := 0
+	for _, rawURL := range urls {
+		if ctx.Err() != nil {
+			break
+		}
+		urlsChan <- rawURL
+		dispatched++
+	}
+	close(urlsChan)
+	wg.Wait()
+
+	// URLs left when the context ended still count, so Succeeded and Failures add up to Total
+	for _, rawURL := range urls[dispatched:] {
+		report.Failures = append(report.Failures, WarmFailure{URL: rawURL, Err: ctx.Err()})
+	}
+	return report
+}
+
+// serveInProcess sends a GET for the URL through the Handler, writing the response to writer.
+func (proxy *CacheableProxy) serveInProcess(ctx context.Context, rawURL string, writer *inProcessWriter) error {
+	req, err := proxy.newInProcessRequest(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	proxy.Handler(writer, req)
+	return nil
+}
+
+// newInProcessRequest builds the server request the Handler expects for a GET of the URL.
+func (proxy *CacheableProxy) newInProcessRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Host != "" && !strings.EqualFold(target.Host, proxy.targetURL.Host) {
+		return nil, fmt.Errorf("%w: %s", ErrOutsideTarget, rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = target.RequestURI()
+	req.Host = proxy.targetURL.Host
+	return req, nil
+}
+
+// fetchSource reads URL sources, using the proxy for the ones hosted on its target.
+func (proxy *CacheableProxy) fetchSource(ctx context.Context, location string) ([]byte, error) {
+	target, err := url.Parse(location)
+	if err != nil || !strings.EqualFold(target.Host, proxy.targetURL.Host) {
+		return fetchLocation(ctx, proxy.upstreamTransport(), location)
+	}
+
+	writer := newInProcessWriter(true)
+	if err = proxy.serveInProcess(ctx, location, writer); err != nil {
+		return nil, err
+	}
+	if writer.status != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", location, writer.status)
+	}
+	return readSource(&writer.body)
+}
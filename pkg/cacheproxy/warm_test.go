@@ -0,0 +1,92 @@
+package cacheproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadURLList(t *testing.T) {
+	urls, err := ReadURLList(strings.NewReader("# nightly\nhttp://a.example/1\n\n  http://a.example/2  \n"))
+	if err != nil {
+		t.Fatalf("Failed to read list: %v", err)
+	}
+	expected := []string{"http://a.example/1", "http://a.example/2"}
+	if strings.Join(urls, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+}
+
+func TestCacheableProxy_WarmFrom(t *testing.T) {
+	var targetURL string
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap_index.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0"?><sitemapindex><sitemap><loc>` +
+				targetURL + `/sitemap.xml.gz</loc></sitemap></sitemapindex>`))
+		case "/sitemap.xml.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(gzipped.Bytes())
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+		}
+	}
+	proxy, storage := newTestProxy(t, upstream)
+	targetURL = proxy.targetURL.String()
+	_, _ = gzipWriter.Write([]byte(`<urlset><url><loc>` + targetURL + `/a</loc></url>` +
+		`<url><loc>` + targetURL + `/b</loc></url><url><loc>` + targetURL + `/missing</loc></url>` +
+		`<url><loc>http://elsewhere.example/c</loc></url></urlset>`))
+	_ = gzipWriter.Close()
+
+	var progressCalls atomic.Int32
+	report, err := proxy.WarmFrom(
+		context.Background(), targetURL+"/sitemap_index.xml",
+		WarmOptions{Concurrency: 2, Progress: func(WarmProgress) { progressCalls.Add(1) }},
+	)
+	if err != nil {
+		t.Fatalf("Failed to warm: %v", err)
+	}
+
+	if report.Total != 4 || report.Succeeded != 2 || len(report.Failures) != 2 {
+		t.Errorf("Expected 4 URLs with 2 failures, got %+v", report)
+	}
+	if progressCalls.Load() != 4 {
+		t.Errorf("Expected 4 progress calls, got %d", progressCalls.Load())
+	}
+	for _, failure := range report.Failures {
+		if strings.HasSuffix(failure.URL, "/c") && !errors.Is(failure.Err, ErrOutsideTarget) {
+			t.Errorf("Expected %v for %s, got %v", ErrOutsideTarget, failure.URL, failure.Err)
+		}
+	}
+	// Only the tracked HTML pages are stored, the sitemaps are not
+	if storage.Len() != 2 {
+		t.Errorf("Expected 2 cached pages, got %d", storage.Len())
+	}
+}
+
+func TestCacheableProxy_WarmCanceled(t *testing.T) {
+	proxy, _ := newTestProxy(t, func(w http.ResponseWriter, r *http.Request) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report := proxy.Warm(ctx, []string{"/a", "/b", "/c", "/d", "/e"}, WarmOptions{Concurrency: 2})
+	if report.Total != 5 || report.Succeeded+len(report.Failures) != report.Total {
+		t.Errorf("Expected every URL to be counted, got %+v", report)
+	}
+	for _, failure := range report.Failures {
+		if !errors.Is(failure.Err, context.Canceled) {
+			t.Errorf("Expected %v for %s, got %v", context.Canceled, failure.URL, failure.Err)
+		}
+	}
+}
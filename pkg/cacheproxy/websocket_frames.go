@@ -0,0 +1,149 @@
+package cacheproxy
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+)
+
+// WebSocket opcodes, as defined by RFC 6455
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+)
+
+// wsAcceptGUID is appended to the client key to compute Sec-WebSocket-Accept
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type (
+	wsFrame struct {
+		fin     bool
+		opcode  byte
+		payload []byte
+	}
+	// wsMessageReader reassembles the messages of a WebSocket byte stream fed in chunks.
+	wsMessageReader struct {
+		buffer    []byte
+		maxBuffer int
+		broken    bool
+		pending   *wsFrame // Fragmented message waiting for its final frame
+	}
+)
+
+func websocketAcceptKey(clientKey string) string {
+	hash := sha1.Sum([]byte(clientKey + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// feed consumes the next chunk of the stream, returning the messages and control frames it completed.
+// Streams that outgrow maxBuffer without completing a frame are ignored from then on.
+func (reader *wsMessageReader) feed(data []byte) []wsFrame {
+	if reader.broken {
+		return nil
+	}
+	reader.buffer = append(reader.buffer, data...)
+
+	var frames []wsFrame
+	for {
+		frame, size, ok := parseWebsocketFrame(reader.buffer)
+		if !ok {
+			break
+		}
+		reader.buffer = reader.buffer[size:]
+		if message, complete := reader.assemble(frame); complete {
+			frames = append(frames, message)
+		}
+	}
+	if reader.maxBuffer > 0 && len(reader.buffer) > reader.maxBuffer {
+		reader.broken, reader.buffer, reader.pending = true, nil, nil
+	}
+	return frames
+}
+
+func (reader *wsMessageReader) assemble(frame wsFrame) (wsFrame, bool) {
+	switch {
+	case frame.opcode >= wsOpClose: // Control frames are never fragmented
+		return frame, true
+	case frame.opcode == wsOpContinuation:
+		if reader.pending == nil {
+			return wsFrame{}, false
+		}
+		reader.pending.payload = append(reader.pending.payload, frame.payload...)
+		if !frame.fin {
+			return wsFrame{}, false
+		}
+		message := *reader.pending
+		message.fin, reader.pending = true, nil
+		return message, true
+	case !frame.fin:
+		reader.pending = &frame
+		return wsFrame{}, false
+	}
+	return frame, true
+}
+
+// parseWebsocketFrame decodes the frame at the start of data, unmasking its payload.
+func parseWebsocketFrame(data []byte) (wsFrame, int, bool) {
+	if len(data) < 2 {
+		return wsFrame{}, 0, false
+	}
+	frame := wsFrame{fin: data[0]&0x80 != 0, opcode: data[0] & 0x0f}
+	masked := data[1]&0x80 != 0
+	length, offset := uint64(data[1]&0x7f), 2
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return wsFrame{}, 0, false
+		}
+		length, offset = uint64(binary.BigEndian.Uint16(data[2:4])), 4
+	case 127:
+		if len(data) < 10 {
+			return wsFrame{}, 0, false
+		}
+		length, offset = binary.BigEndian.Uint64(data[2:10]), 10
+	}
+
+	var mask []byte
+	if masked {
+		if len(data) < offset+4 {
+			return wsFrame{}, 0, false
+		}
+		mask, offset = data[offset:offset+4], offset+4
+	}
+	if uint64(len(data)-offset) < length {
+		return wsFrame{}, 0, false
+	}
+
+	end := offset + int(length)
+	frame.payload = append([]byte(nil), data[offset:end]...)
+	for index := range mask {
+		for position := index; position < len(frame.payload); position += len(mask) {
+			frame.payload[position] ^= mask[index]
+		}
+	}
+	return frame, end, true
+}
+
+// writeWebsocketFrame writes a single unmasked frame, as sent from a server.
+func writeWebsocketFrame(writer io.Writer, opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(payload)
+	return err
+}
@@ -0,0 +1,305 @@
+package httptransport
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newForwardProxy answers every proxied request itself, requiring the given credentials.
+func newForwardProxy(t *testing.T, name string, served *atomic.Int32) *httptest.Server {
+	t.Helper()
+	expectedAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("crawler:secret"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != expectedAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		served.Add(1)
+		_, _ = io.WriteString(w, name+" "+r.URL.String())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newSOCKS5Proxy accepts username/password authenticated CONNECT commands.
+func newSOCKS5Proxy(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		methods := make([]byte, header[1])
+		_, _ = io.ReadFull(conn, methods)
+		_, _ = conn.Write([]byte{5, 2})
+
+		// Username and password sub-negotiation, RFC 1929
+		credentials := make([]byte, 2)
+		_, _ = io.ReadFull(conn, credentials)
+		user := make([]byte, credentials[1])
+		_, _ = io.ReadFull(conn, user)
+		passwordLength := make([]byte, 1)
+		_, _ = io.ReadFull(conn, passwordLength)
+		password := make([]byte, passwordLength[0])
+		_, _ = io.ReadFull(conn, password)
+		if string(user) != "crawler" || string(password) != "secret" {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+
+		request := make([]byte, 4)
+		_, _ = io.ReadFull(conn, request)
+		var host string
+		switch request[3] {
+		case 1:
+			address := make([]byte, 4)
+			_, _ = io.ReadFull(conn, address)
+			host = net.IP(address).String()
+		case 3:
+			length := make([]byte, 1)
+			_, _ = io.ReadFull(conn, length)
+			domain := make([]byte, length[0])
+			_, _ = io.ReadFull(conn, domain)
+			host = string(domain)
+		}
+		portBytes := make([]byte, 2)
+		_, _ = io.ReadFull(conn, portBytes)
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes)))))
+		if err != nil {
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go func() { _, _ = io.Copy(target, conn) }()
+		_, _ = io.Copy(conn, target)
+	}
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener
+}
+
+func readBody(t *testing.T, transport http.RoundTripper, target string) string {
+	t.Helper()
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, target, nil))
+	if err != nil {
+		t.Fatalf("Failed request to %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestEgressTransport_HTTPProxyWithAuth(t *testing.T) {
+	var served atomic.Int32
+	proxyServer := newForwardProxy(t, "first", &served)
+	proxyURL := "http://crawler:secret@" + proxyServer.Listener.Addr().String()
+
+	egress, err := NewEgressTransport(EgressPolicy{Proxies: []string{proxyURL}})
+	if err != nil {
+		t.Fatalf("Failed to create transport: %v", err)
+	}
+	if body := readBody(t, egress, "http://origin.test/page"); body != "first http://origin.test/page" {
+		t.Errorf("Expected the request to go through the proxy, got `%s`", body)
+	}
+}
+
+func TestEgressTransport_SOCKS5WithAuth(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin "+r.URL.Path)
+	}))
+	defer origin.Close()
+	listener := newSOCKS5Proxy(t)
+
+	egress, err := NewEgressTransport(EgressPolicy{
+		Proxies: []string{"socks5://crawler:secret@" + listener.Addr().String()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create transport: %v", err)
+	}
+	if body := readBody(t, egress, origin.URL+"/page"); body != "origin /page" {
+		t.Errorf("Expected the origin response through SOCKS5, got `%s`", body)
+	}
+}
+
+func TestEgressTransport_Selection(t *testing.T) {
+	var firstServed, secondServed atomic.Int32
+	first := newForwardProxy(t, "first", &firstServed)
+	second := newForwardProxy(t, "second", &secondServed)
+	proxies := []string{
+		"http://crawler:secret@" + first.Listener.Addr().String(),
+		"http://crawler:secret@" + second.Listener.Addr().String(),
+	}
+
+	testCases := []struct {
+		name           string
+		selection      EgressSelection
+		hosts          []string
+		expectedFirst  int32
+		expectedSecond int32
+	}{
+		{
+			name:          "round robin",
+			selection:     EgressRoundRobin,
+			hosts:         []string{"a.test", "a.test", "a.test", "a.test"},
+			expectedFirst: 2, expectedSecond: 2,
+		},
+		{
+			name:      "sticky by host",
+			selection: EgressStickyByHost,
+			hosts:     []string{"a.test", "a.test", "a.test", "a.test"},
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			firstServed.Store(0)
+			secondServed.Store(0)
+			egress, err := NewEgressTransport(EgressPolicy{Proxies: proxies, Selection: tCase.selection})
+			if err != nil {
+				t.Fatalf("Failed to create transport: %v", err)
+			}
+			for _, host := range tCase.hosts {
+				readBody(t, egress, "http://"+host+"/")
+			}
+			if tCase.selection == EgressStickyByHost {
+				if firstServed.Load() != 0 && secondServed.Load() != 0 {
+					t.Errorf("Expected a single proxy, got %d and %d", firstServed.Load(), secondServed.Load())
+				}
+				return
+			}
+			if firstServed.Load() != tCase.expectedFirst || secondServed.Load() != tCase.expectedSecond {
+				t.Errorf(
+					"Expected %d and %d, got %d and %d", tCase.expectedFirst, tCase.expectedSecond,
+					firstServed.Load(), secondServed.Load(),
+				)
+			}
+		})
+	}
+}
+
+func TestEgressTransport_EjectsUnhealthyProxy(t *testing.T) {
+	var served atomic.Int32
+	healthy := newForwardProxy(t, "healthy", &served)
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	unreachableURL := "http://" + unreachable.Addr().String()
+	_ = unreachable.Close()
+
+	egress, err := NewEgressTransport(EgressPolicy{
+		Proxies:          []string{unreachableURL, "http://crawler:secret@" + healthy.Listener.Addr().String()},
+		FailureThreshold: 1,
+		EjectDuration:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create transport: %v", err)
+	}
+
+	if _, err = egress.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.test/", nil)); err == nil {
+		t.Fatal("Expected the unreachable proxy to fail")
+	}
+	for range 4 {
+		readBody(t, egress, "http://a.test/")
+	}
+	if served.Load() != 4 {
+		t.Errorf("Expected every request on the healthy proxy, got %d", served.Load())
+	}
+	if status := egress.Status(); status[0].EjectedUntil.IsZero() || !status[1].EjectedUntil.IsZero() {
+		t.Errorf("Expected only the unreachable proxy to be ejected, got %+v", status)
+	}
+}
+
+func TestEgressTransport_OriginErrorsKeepProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer origin.Close()
+	closedOrigin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedURL := "http://" + closedOrigin.Addr().String() + "/"
+	_ = closedOrigin.Close()
+	var served atomic.Int32
+	forward := newForwardProxy(t, "forward", &served)
+	listener := newSOCKS5Proxy(t)
+
+	testCases := []struct {
+		name          string
+		proxy         string
+		target        string
+		expectEjected bool
+	}{
+		{
+			name:   "Origin bad gateway through SOCKS5",
+			proxy:  "socks5://crawler:secret@" + listener.Addr().String(),
+			target: origin.URL + "/",
+		},
+		{
+			name:   "Origin refused through SOCKS5",
+			proxy:  "socks5://crawler:secret@" + listener.Addr().String(),
+			target: closedURL,
+		},
+		{
+			name:          "CONNECT refused by the proxy",
+			proxy:         "http://" + forward.Listener.Addr().String(),
+			target:        "https://a.test/",
+			expectEjected: true,
+		},
+		{
+			name:          "Forwarded request without credentials",
+			proxy:         "http://" + forward.Listener.Addr().String(),
+			target:        "http://a.test/",
+			expectEjected: true,
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			egress, err := NewEgressTransport(EgressPolicy{
+				Proxies: []string{tCase.proxy}, FailureThreshold: 1, EjectDuration: time.Minute,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create transport: %v", err)
+			}
+			resp, err := egress.RoundTrip(httptest.NewRequest(http.MethodGet, tCase.target, nil))
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if ejected := !egress.Status()[0].EjectedUntil.IsZero(); ejected != tCase.expectEjected {
+				t.Errorf("Expected ejected %t, got %t (err: %v)", tCase.expectEjected, ejected, err)
+			}
+		})
+	}
+}
+
+func TestNewEgressTransport_InvalidProxies(t *testing.T) {
+	for _, proxies := range [][]string{nil, {"ftp://proxy.test:21"}, {"http://"}} {
+		if _, err := NewEgressTransport(EgressPolicy{Proxies: proxies}); err == nil {
+			t.Errorf("Expected %v to be rejected", proxies)
+		}
+	}
+}
@@ -1,16 +1,30 @@
 package httptransport
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/jictyvoo/radadar_crawlsdk/pkg/httptransport"
+
 type TransportRewrite struct {
 	originRoute   *url.URL
 	redirectRoute string
 	Transport     http.RoundTripper
+	// RedirectScheme is used on the rewritten requests, http when empty
+	RedirectScheme string
+	// Header is added to the rewritten requests, like the credentials of the redirect route
+	Header http.Header
 }
 
 func NewTransportRewrite(originRoute *url.URL, redirectRoute string) *TransportRewrite {
@@ -21,7 +35,34 @@ func NewTransportRewrite(originRoute *url.URL, redirectRoute string) *TransportR
 	}
 }
 
+// NewTLSTransportRewrite redirects the requests to a route served over TLS, trusting the
+// certificates in rootCAs, or the system ones when nil. HTTP/2 is used when the route supports it.
+func NewTLSTransportRewrite(
+	originRoute *url.URL, redirectRoute string, rootCAs *x509.CertPool,
+) *TransportRewrite {
+	transport := DefaultTransport.Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	return &TransportRewrite{
+		originRoute:    originRoute,
+		redirectRoute:  redirectRoute,
+		Transport:      transport,
+		RedirectScheme: "https",
+	}
+}
+
 func (t *TransportRewrite) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(
+		req.Context(), "TransportRewrite.RoundTrip",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		),
+	)
+	defer span.End()
+	req = req.Clone(ctx)
+
 	// Check if the request URL matches the domain
 	if strings.Contains(req.URL.Host, t.originRoute.Host) {
 		// Rewrite the request URL to localhost
@@ -31,9 +72,27 @@ func (t *TransportRewrite) RoundTrip(req *http.Request) (*http.Response, error)
 			slog.String("redirect2-host", t.redirectRoute),
 		)
 		req.URL.Host = t.redirectRoute
-		req.URL.Scheme = "http"
+		req.URL.Scheme = t.RedirectScheme
+		if req.URL.Scheme == "" {
+			req.URL.Scheme = "http"
+		}
+		for name, values := range t.Header {
+			req.Header[name] = values
+		}
+		span.SetAttributes(attribute.String("radadar.redirect_host", t.redirectRoute))
+
+		// Propagate the span, so the proxy cache decision can be followed from here.
+		// Other hosts never receive it, as trace ids must not leave the crawler and its proxy.
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
 	}
 
 	// Call the next transport (which sends the request)
-	return t.Transport.RoundTrip(req)
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	return resp, nil
 }
@@ -1,10 +1,16 @@
 package httptransport
 
 import (
+	"context"
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type TestRoundTripper struct {
@@ -115,3 +121,73 @@ func TestTransportRewrite_RoundTrip(t *testing.T) {
 		t.Run(tCase.name, tCase.Test)
 	}
 }
+
+func TestTransportRewrite_TLSRedirect(t *testing.T) {
+	redirectServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || r.ProtoMajor != 2 {
+			t.Errorf("Expected an HTTP/2 request over TLS, got %s", r.Proto)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	redirectServer.EnableHTTP2 = true
+	redirectServer.StartTLS()
+	defer redirectServer.Close()
+
+	redirectURL, err := url.Parse(redirectServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	originURL, _ := url.Parse("http://origin.example")
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(redirectServer.Certificate())
+
+	tr := NewTLSTransportRewrite(originURL, redirectURL.Host, rootCAs)
+	req := httptest.NewRequest(http.MethodGet, "http://origin.example/resource", nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Unexpected error in RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code `%d`, but got %s", http.StatusOK, resp.Status)
+	}
+}
+
+func TestTransportRewrite_TraceContextOnlyOnRedirect(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	received := make(map[string]string)
+	var mutex sync.Mutex
+	newServer := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			received[name] = r.Header.Get("Traceparent")
+			mutex.Unlock()
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	redirectServer, thirdParty := newServer("redirect"), newServer("third party")
+
+	originURL, _ := url.Parse("http://origin.example")
+	tr := NewTransportRewrite(originURL, redirectServer.Listener.Addr().String())
+	for _, target := range []string{"http://origin.example/page", thirdParty.URL + "/script.js"} {
+		ctx, span := provider.Tracer("test").Start(context.Background(), "fetch")
+		resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+		span.End()
+		if err != nil {
+			t.Fatalf("Unexpected error in RoundTrip to %s: %v", target, err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	if received["redirect"] == "" {
+		t.Errorf("Expected the redirect route to receive the trace context")
+	}
+	if traceParent := received["third party"]; traceParent != "" {
+		t.Errorf("Expected the third party host to not receive the trace context, got %q", traceParent)
+	}
+}